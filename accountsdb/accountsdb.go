@@ -3,15 +3,100 @@ package accountsdb
 import (
 	"encoding/json"
 	"errors"
-	"maps"
+	"fmt"
+	"io"
+	"math"
 	"os"
+	"slices"
+	"strings"
+	"sync"
 )
 
-type Accounts map[string]float64
+// ValidatorAccount is the reserved account validator earns fees to.
+const ValidatorAccount = "validator"
 
-// Simple in-memory representation of accounts and their balances.
+type Accounts map[string]Balance
+
+// Simple representation of accounts and their balances, kept in memory
+// unless it's given another store. It's safe for concurrent use.
 type AccountsDb struct {
-	Accounts Accounts
+	store     Store
+	mu        sync.RWMutex        // Guards `store`.
+	normalize func(string) string // Applied on every account name.
+	bloom     *bloomFilter        // Short-circuits lookups of missing accounts, nil if disabled.
+
+	accountLocks   map[string]*accountLock // Per-account locks, see WithLock.
+	accountLocksMu sync.Mutex
+
+	// Called after an account is created, see OnAccountCreated.
+	onCreate func(account string, initialBalance float64)
+
+	reserved ReservedAccountPolicy // See WithReservedAccountPolicy.
+}
+
+// Option configures optional behaviour of a db.
+type Option func(*AccountsDb)
+
+// WithNormalizer sets a function that's applied on every account name
+// before it's looked up or stored, so that differently spelled names
+// can resolve to the same account. Defaults to identity.
+func WithNormalizer(normalize func(string) string) Option {
+	return func(db *AccountsDb) {
+		db.normalize = normalize
+	}
+}
+
+// WithBloomFilter maintains a bloom filter of account names alongside
+// the db, letting Exists rule out missing accounts without a map lookup.
+// The filter is sized for the expected number of accounts at given
+// false positive rate (e.g. 0.01). Disabled by default.
+func WithBloomFilter(expectedAccounts int, falsePositiveRate float64) Option {
+	return func(db *AccountsDb) {
+		db.bloom = newBloomFilter(expectedAccounts, falsePositiveRate)
+	}
+}
+
+// WithStore sets where the db keeps balances, e.g. a store persisting
+// them across restarts. Defaults to a MemStore. See InitFromStore to use
+// accounts already in the store.
+func WithStore(store Store) Option {
+	return func(db *AccountsDb) {
+		db.store = store
+	}
+}
+
+// ReservedAccountPolicy decides what's done when the validator account
+// isn't among the loaded accounts. See WithReservedAccountPolicy.
+type ReservedAccountPolicy struct {
+	require bool
+	balance float64
+}
+
+var (
+	// CreateZero creates the validator account with no balance.
+	CreateZero = ReservedAccountPolicy{}
+	// RequireExisting reports a missing validator account as an error.
+	RequireExisting = ReservedAccountPolicy{require: true}
+)
+
+// CreateWithBalance creates the validator account with given balance,
+// e.g. to pre-fund it.
+func CreateWithBalance(balance float64) ReservedAccountPolicy {
+	return ReservedAccountPolicy{balance: balance}
+}
+
+// WithReservedAccountPolicy sets what's done when the validator account
+// isn't among the loaded accounts. Defaults to CreateZero.
+func WithReservedAccountPolicy(policy ReservedAccountPolicy) Option {
+	return func(db *AccountsDb) {
+		db.reserved = policy
+	}
+}
+
+// TrimLower is a normalizer that trims surrounding whitespace
+// and lowercases account names.
+func TrimLower(account string) string {
+	return strings.ToLower(strings.TrimSpace(account))
 }
 
 // InitFromSnapshot initializes a new accounts database
@@ -24,7 +109,13 @@ type AccountsDb struct {
 //	  "carol": 4,
 //	  ...
 //	}
-func InitFromSnapshot(snapshot string) (*AccountsDb, error) {
+//
+// A balance may also be an object carrying metadata of the account,
+// e.g. `"dave": {"amount": 10, "frozen": true}`, see Balance.
+//
+// If a normalizer is given, names that normalize to the same
+// account are reported as an error rather than being merged.
+func InitFromSnapshot(snapshot string, opts ...Option) (*AccountsDb, error) {
 	// Open the snapshot file.
 	file, err := os.Open(snapshot)
 	if err != nil {
@@ -32,49 +123,309 @@ func InitFromSnapshot(snapshot string) (*AccountsDb, error) {
 	}
 	defer file.Close()
 
-	// Create a db object.
+	return InitFromReader(file, opts...)
+}
+
+// InitFromReader initializes a new accounts database from
+// a snapshot read from r. See InitFromSnapshot for the format.
+func InitFromReader(r io.Reader, opts ...Option) (*AccountsDb, error) {
+	db := newDb(opts...)
+
+	err := db.load(r)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.finishLoading()
+	if err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// InitFromStore initializes a new accounts database on top of the
+// accounts already in the store, e.g. the ones persisted by a previous
+// run. Accounts are expected to be stored by their normalized names.
+func InitFromStore(store Store, opts ...Option) (*AccountsDb, error) {
+	db := newDb(opts...)
+	db.store = store
+
+	store.Range(func(account string, _ Balance) bool {
+		db.track(account)
+		return true
+	})
+
+	err := db.finishLoading()
+	if err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// InitFromSnapshots initializes a new accounts database by merging
+// accounts of all the given snapshot files. See InitFromSnapshot for
+// the format.
+//
+// Every account must appear in exactly one of the files; an account
+// found in more than one is reported as an error instead of one of them
+// silently winning. The validator account is created once after all
+// files are merged, if none of them has it.
+func InitFromSnapshots(paths ...string) (*AccountsDb, error) {
+	db := newDb()
+
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		err = db.load(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	err := db.finishLoading()
+	if err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// newDb creates an empty db with given options applied.
+func newDb(opts ...Option) *AccountsDb {
 	db := &AccountsDb{}
+	for _, opt := range opts {
+		opt(db)
+	}
+
+	if db.store == nil {
+		db.store = NewMemStore()
+	}
+
+	return db
+}
+
+// load parses a snapshot from r and adds its accounts to the db.
+// Accounts are stored by their normalized names; a name that's already
+// in the db is reported as an error.
+func (db *AccountsDb) load(r io.Reader) error {
 	// Parse the snapshot.
-	err = json.NewDecoder(file).Decode(&db.Accounts)
+	var accounts Accounts
+	err := json.NewDecoder(r).Decode(&accounts)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	// Make sure all balances are valid (>= 0).
-	for _, balance := range db.Accounts {
-		if balance < 0 {
-			return nil, errors.New("invalid balance data in accounts snapshot")
+	for account, balance := range accounts {
+		name := db.Normalize(account)
+		if _, ok := db.store.Get(name); ok {
+			return errors.New("duplicate account in accounts snapshot: " + name)
 		}
+
+		db.store.Set(name, balance)
+		db.track(name)
 	}
 
-	// Create the validator account if it's not created.
-	_, ok := db.Accounts["validator"]
+	return nil
+}
+
+// finishLoading validates loaded accounts and creates
+// the reserved ones. Called once all snapshots are loaded.
+func (db *AccountsDb) finishLoading() error {
+	// Make sure all balances are valid (>= 0).
+	valid := true
+	db.store.Range(func(_ string, balance Balance) bool {
+		valid = balance.Overdraft >= 0 && balance.Available() >= 0
+		return valid
+	})
+	if !valid {
+		return errors.New("invalid balance data in accounts snapshot")
+	}
+
+	// Create the validator account if it's not created,
+	// as the reserved account policy says.
+	validator := db.Normalize(ValidatorAccount)
+	_, ok := db.store.Get(validator)
 	if !ok {
-		db.Accounts["validator"] = 0
+		if db.reserved.require {
+			return errors.New("validator account is missing from accounts snapshot")
+		}
+		if !(db.reserved.balance >= 0) {
+			return errors.New("validator account can't start with a negative balance")
+		}
+
+		db.store.Set(validator, Balance{Amount: db.reserved.balance})
+		db.track(validator)
 	}
 
-	return db, nil
+	return nil
 }
 
 // GetBalance returns the balance of the given account.
 // An error is returned if the account does not exist in records.
 func (db *AccountsDb) GetBalance(account string) (float64, error) {
-	balance, ok := db.Accounts[account]
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.balanceOf(db.Normalize(account))
+}
+
+// Exists returns true if the account exists in records.
+func (db *AccountsDb) Exists(account string) bool {
+	account = db.Normalize(account)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.bloom != nil && !db.bloom.mayContain(account) {
+		return false
+	}
+
+	_, ok := db.store.Get(account)
+	return ok
+}
+
+// track records a newly created account in the bloom filter.
+// Must be called with the lock held.
+func (db *AccountsDb) track(account string) {
+	if db.bloom != nil {
+		db.bloom.add(account)
+	}
+}
+
+// balanceOf is GetBalance for callers that already hold the lock.
+// Account name must already be normalized.
+func (db *AccountsDb) balanceOf(account string) (float64, error) {
+	balance, ok := db.store.Get(account)
 	if !ok {
 		return 0, errors.New("no such account")
 	}
 
+	return balance.Amount, nil
+}
+
+// GetAccount returns the balance of the given account along with its
+// metadata. An error is returned if the account does not exist in records.
+func (db *AccountsDb) GetAccount(account string) (Balance, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	balance, ok := db.store.Get(db.Normalize(account))
+	if !ok {
+		return Balance{}, errors.New("no such account")
+	}
+
 	return balance, nil
 }
 
+// Available returns how much can be taken from the given account,
+// its balance plus its overdraft allowance. An error is returned if the
+// account does not exist in records.
+func (db *AccountsDb) Available(account string) (float64, error) {
+	balance, err := db.GetAccount(account)
+	if err != nil {
+		return 0, err
+	}
+
+	return balance.Available(), nil
+}
+
+// SetOverdraft lets the account's balance go down to -limit rather
+// than zero. A zero limit restores the zero floor.
+func (db *AccountsDb) SetOverdraft(account string, limit float64) error {
+	if !(limit >= 0) || math.IsInf(limit, 0) {
+		return errors.New("overdraft limit must be a non-negative number")
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	account = db.Normalize(account)
+	balance, ok := db.store.Get(account)
+	if !ok {
+		return errors.New("no such account")
+	}
+
+	balance.Overdraft = limit
+	db.store.Set(account, balance)
+	return nil
+}
+
+// IsFrozen returns true if the account exists and is frozen.
+func (db *AccountsDb) IsFrozen(account string) bool {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	balance, _ := db.store.Get(db.Normalize(account))
+	return balance.Frozen
+}
+
+// Freeze halts activity on the account, any operation decreasing its
+// balance is rejected until it's unfrozen. Credits are still accepted.
+func (db *AccountsDb) Freeze(account string) error {
+	return db.setFrozen(account, true)
+}
+
+// Unfreeze lets a frozen account be debited again.
+func (db *AccountsDb) Unfreeze(account string) error {
+	return db.setFrozen(account, false)
+}
+
+func (db *AccountsDb) setFrozen(account string, frozen bool) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	account = db.Normalize(account)
+	balance, ok := db.store.Get(account)
+	if !ok {
+		return errors.New("no such account")
+	}
+
+	balance.Frozen = frozen
+	db.store.Set(account, balance)
+	return nil
+}
+
+// MarkUpdated records given batch index as the last one
+// changing each of the accounts. Missing accounts are ignored.
+func (db *AccountsDb) MarkUpdated(batchIdx uint64, accounts ...string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, account := range accounts {
+		account = db.Normalize(account)
+		if balance, ok := db.store.Get(account); ok {
+			balance.UpdatedAt = batchIdx
+			db.store.Set(account, balance)
+		}
+	}
+}
+
+// setAmount sets the amount of an account, keeping its metadata.
+// Must be called with the lock held.
+func (db *AccountsDb) setAmount(account string, amount float64) {
+	balance, _ := db.store.Get(account)
+	balance.Amount = amount
+	db.store.Set(account, balance)
+}
+
 // UpdateBy updates the account's balance by given amount.
 // If the given account does not exist, it will be created
 // and provided amount will be given to it.
 //
-// If the operation would cause balance to go negative, it'll
-// not take place and an error returned.
+// If the operation would cause balance to go negative (beyond the
+// overdraft allowance of the account, if any), or decrease the balance
+// of a frozen account, it'll not take place and an error returned.
 func (db *AccountsDb) UpdateBy(account string, amount float64) error {
-	balance, err := db.GetBalance(account)
+	db.mu.Lock()
+
+	account = db.Normalize(account)
+	balance, err := db.balanceOf(account)
 	// Account does not exist; let's create it.
 	if err != nil {
 		// If the provided amount is negative, prefer 0 instead.
@@ -85,32 +436,373 @@ func (db *AccountsDb) UpdateBy(account string, amount float64) error {
 		}
 
 		// Create the account.
-		db.Accounts[account] = validAmount
+		db.store.Set(account, Balance{Amount: validAmount})
+		db.track(account)
+		db.mu.Unlock()
+
+		db.created(account, validAmount)
 		return nil
 	}
+	defer db.mu.Unlock()
 
-	// Check if this operation causes the balance to go negative.
+	record, _ := db.store.Get(account)
+	if amount < 0 && record.Frozen {
+		return errors.New("account is frozen")
+	}
+
+	// Check if this operation causes the balance to go negative,
+	// below the overdraft allowance if the account has one.
 	newBalance := balance + amount
-	if newBalance < 0 {
+	if newBalance < -record.Overdraft {
 		return errors.New("operation causes balance to go negative")
 	}
 
 	// All is well; update the balance.
-	db.Accounts[account] = newBalance
+	db.setAmount(account, newBalance)
+	return nil
+}
+
+// SetBalance sets the account's balance, creating the account if
+// it does not exist. Unlike UpdateBy, no checks take place.
+// Metadata of the account is left as is.
+func (db *AccountsDb) SetBalance(account string, balance float64) {
+	db.mu.Lock()
+
+	account = db.Normalize(account)
+	_, exists := db.store.Get(account)
+	db.setAmount(account, balance)
+	if !exists {
+		db.track(account)
+	}
+	db.mu.Unlock()
+
+	if !exists {
+		db.created(account, balance)
+	}
+}
+
+// Apply adds each delta to the balance of its account as a single
+// operation, readers see either none or all of them. Stores that are
+// a BatchStore persist them in a single write too. Missing accounts
+// are created starting from zero. Every account given, even with a zero
+// delta, is marked as updated by given batch. Accounts in sequences get
+// their commit sequence set along, see Balance.Sequence. No balance
+// checks are made, deltas are expected to be validated already.
+func (db *AccountsDb) Apply(deltas map[string]float64, sequences map[string]uint64, batchIdx uint64) {
+	type creation struct {
+		account string
+		balance float64
+	}
+	var created []creation
+
+	db.mu.Lock()
+	balances := make(Accounts, len(deltas))
+	for account, delta := range deltas {
+		account = db.Normalize(account)
+		balance, exists := balances[account]
+		if !exists {
+			balance, exists = db.store.Get(account)
+		}
+		balance.Amount += delta
+		balance.UpdatedAt = batchIdx
+		if sequence, ok := sequences[account]; ok {
+			balance.Sequence = sequence
+		}
+		balances[account] = balance
+
+		if !exists {
+			db.track(account)
+			created = append(created, creation{account, balance.Amount})
+		}
+	}
+	setMany(db.store, balances)
+	db.mu.Unlock()
+
+	for _, c := range created {
+		db.created(c.account, c.balance)
+	}
+}
+
+// OnAccountCreated sets a function that's called once for every account
+// created after this call, with the balance the account starts with.
+// It's called without the db locked, so it's free to query the db.
+//
+// Copies of the db don't inherit it; simulating on a copy never
+// reports accounts that don't exist in this db.
+func (db *AccountsDb) OnAccountCreated(fn func(account string, initialBalance float64)) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.onCreate = fn
+}
+
+// created reports a newly created account. Must be called
+// without holding the lock.
+func (db *AccountsDb) created(account string, initialBalance float64) {
+	db.mu.RLock()
+	fn := db.onCreate
+	db.mu.RUnlock()
+
+	if fn != nil {
+		fn(account, initialBalance)
+	}
+}
+
+// Delete removes the account from records.
+// The validator account can't be deleted.
+func (db *AccountsDb) Delete(account string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	account = db.Normalize(account)
+	if account == db.Normalize(ValidatorAccount) {
+		return errors.New("validator account can't be deleted")
+	}
+
+	if _, ok := db.store.Get(account); !ok {
+		return errors.New("no such account")
+	}
+
+	db.store.Delete(account)
 	return nil
 }
 
-// Copy returns a copy of the db.
-// Modifications on the returned db won't affect the original one.
+// AccountCount returns the number of accounts in records.
+// The validator account is only counted if includeValidator is true.
+func (db *AccountsDb) AccountCount(includeValidator bool) int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	count := db.store.Len()
+	if _, ok := db.store.Get(db.Normalize(ValidatorAccount)); ok && !includeValidator {
+		count--
+	}
+
+	return count
+}
+
+// TotalSupply returns the sum of all balances, validator account included.
+func (db *AccountsDb) TotalSupply() float64 {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var total float64 = 0
+	db.store.Range(func(_ string, balance Balance) bool {
+		total += balance.Amount
+		return true
+	})
+
+	return total
+}
+
+// Copy returns a copy of the db, kept in memory whatever the store of
+// the original one is. Modifications on the returned db won't affect
+// the original one. Accounts aren't copied upfront: the ones the copy
+// hasn't modified are read from the original, so modifications on it
+// show through. The copy isn't a snapshot then, callers needing one keep
+// the original from being modified for as long as they use the copy.
 func (db *AccountsDb) Copy() *AccountsDb {
-	copy := make(Accounts, len(db.Accounts))
-	maps.Copy(copy, db.Accounts)
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	copy := newOverlayStore(db)
+
+	var bloom *bloomFilter
+	if db.bloom != nil {
+		bloom = db.bloom.clone()
+	}
 
-	return &AccountsDb{Accounts: copy}
+	return &AccountsDb{store: copy, normalize: db.normalize, bloom: bloom}
+}
+
+// Accounts returns a copy of every account of the db,
+// by their normalized names.
+func (db *AccountsDb) Accounts() Accounts {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.store.Snapshot()
+}
+
+// Balances returns a copy of the amount of every account of the db, by
+// their normalized names. It's what the exported Accounts field used to
+// hold before accounts carried metadata, see Accounts for the records.
+func (db *AccountsDb) Balances() map[string]float64 {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	balances := make(map[string]float64, db.store.Len())
+	db.store.Range(func(account string, balance Balance) bool {
+		balances[account] = balance.Amount
+		return true
+	})
+
+	return balances
+}
+
+// Restore replaces every account of the db with given ones, e.g. the
+// accounts of a snapshot. Accounts are stored by their normalized names;
+// names that normalize to the same account are reported as an error,
+// leaving the db untouched. The validator account is created if missing,
+// as the reserved account policy says.
+func (db *AccountsDb) Restore(accounts Accounts) error {
+	normalized := make(Accounts, len(accounts))
+	for account, balance := range accounts {
+		if balance.Overdraft < 0 || balance.Available() < 0 {
+			return errors.New("invalid balance of account " + account)
+		}
+
+		name := db.Normalize(account)
+		if _, ok := normalized[name]; ok {
+			return errors.New("duplicate account: " + name)
+		}
+		normalized[name] = balance
+	}
+
+	// Finish loading them aside, so that a failure leaves the db untouched.
+	restored := &AccountsDb{store: &MemStore{accounts: normalized}, normalize: db.normalize, reserved: db.reserved}
+	err := restored.finishLoading()
+	if err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var stale []string
+	db.store.Range(func(account string, _ Balance) bool {
+		if _, ok := normalized[account]; !ok {
+			stale = append(stale, account)
+		}
+		return true
+	})
+	for _, account := range stale {
+		db.store.Delete(account)
+	}
+
+	setMany(db.store, normalized)
+	for account := range normalized {
+		db.track(account)
+	}
+
+	return nil
 }
 
 // Earn increases the balance of validator account by given amount.
 func (db *AccountsDb) Earn(amount float64) {
-	balance, _ := db.GetBalance("validator")
-	db.Accounts["validator"] = balance + amount
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	validator := db.Normalize(ValidatorAccount)
+	balance, err := db.balanceOf(validator)
+	if err != nil {
+		db.track(validator)
+	}
+	db.setAmount(validator, balance+amount)
+}
+
+// Normalize returns the name given account is stored by.
+func (db *AccountsDb) Normalize(account string) string {
+	if db.normalize == nil {
+		return account
+	}
+
+	return db.normalize(account)
+}
+
+// WriteSnapshot writes the accounts to w in snapshot format,
+// the output can be loaded back by InitFromReader. Balances without
+// metadata are written as bare numbers.
+func (db *AccountsDb) WriteSnapshot(w io.Writer) error {
+	return db.WriteSnapshotIndent(w, "")
+}
+
+// WriteSnapshotIndent is like WriteSnapshot but puts each account
+// on its own line, indented by given indent. An empty indent
+// produces the same compact output as WriteSnapshot.
+func (db *AccountsDb) WriteSnapshotIndent(w io.Writer, indent string) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", indent)
+
+	return encoder.Encode(db.store.Snapshot())
+}
+
+// WithLock runs fn while holding the locks of given accounts, so that
+// multi-step operations on them are atomic with respect to other WithLock
+// callers, including batch commits of the validator.
+//
+// Locks are acquired in sorted order to avoid deadlocks between
+// overlapping account sets. They aren't reentrant: fn must not call
+// WithLock on any of the same accounts. Returns the error of fn.
+func (db *AccountsDb) WithLock(accounts []string, fn func(*AccountsDb) error) error {
+	names := make([]string, 0, len(accounts))
+	for _, account := range accounts {
+		names = append(names, db.Normalize(account))
+	}
+	slices.Sort(names)
+	names = slices.Compact(names)
+
+	locks := db.acquireLocks(names)
+	defer db.releaseLocks(names)
+
+	for _, lock := range locks {
+		lock.Lock()
+	}
+	defer func() {
+		for _, lock := range slices.Backward(locks) {
+			lock.Unlock()
+		}
+	}()
+
+	return fn(db)
+}
+
+// accountLock is the lock of an account, counting WithLock callers
+// that hold or wait for it.
+type accountLock struct {
+	sync.Mutex
+	refs int
+}
+
+// acquireLocks returns the locks of given accounts, creating them if
+// needed. Each must be released by releaseLocks once done with.
+func (db *AccountsDb) acquireLocks(accounts []string) []*accountLock {
+	db.accountLocksMu.Lock()
+	defer db.accountLocksMu.Unlock()
+
+	if db.accountLocks == nil {
+		db.accountLocks = make(map[string]*accountLock)
+	}
+
+	locks := make([]*accountLock, 0, len(accounts))
+	for _, account := range accounts {
+		lock, ok := db.accountLocks[account]
+		if !ok {
+			lock = &accountLock{}
+			db.accountLocks[account] = lock
+		}
+		lock.refs++
+		locks = append(locks, lock)
+	}
+
+	return locks
+}
+
+// releaseLocks releases the locks of given accounts, deleting the ones
+// nobody holds or waits for, so that locks don't pile up for accounts
+// locked once.
+func (db *AccountsDb) releaseLocks(accounts []string) {
+	db.accountLocksMu.Lock()
+	defer db.accountLocksMu.Unlock()
+
+	for _, account := range accounts {
+		lock := db.accountLocks[account]
+		lock.refs--
+		if lock.refs == 0 {
+			delete(db.accountLocks, account)
+		}
+	}
 }
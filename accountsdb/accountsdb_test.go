@@ -0,0 +1,525 @@
+package accountsdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newTestDb loads a db from given snapshot.
+func newTestDb(t *testing.T, snapshot string, opts ...Option) *AccountsDb {
+	t.Helper()
+
+	db, err := InitFromReader(strings.NewReader(snapshot), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestNormalizer(t *testing.T) {
+	db := newTestDb(t, `{"Alice ": 10}`, WithNormalizer(TrimLower))
+
+	for _, account := range []string{"Alice ", "alice", " ALICE"} {
+		balance, err := db.GetBalance(account)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if balance != 10 {
+			t.Errorf("balance of %q is %v, want 10", account, balance)
+		}
+	}
+
+	err := db.UpdateBy("alice", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance, _ := db.GetBalance("Alice "); balance != 15 {
+		t.Errorf("balance of %q is %v, want 15", "Alice ", balance)
+	}
+	if n := db.AccountCount(false); n != 1 {
+		t.Errorf("got %d accounts, want 1", n)
+	}
+}
+
+func TestNormalizerRejectsCollidingNames(t *testing.T) {
+	_, err := InitFromReader(strings.NewReader(`{"alice": 1, "Alice": 2}`), WithNormalizer(TrimLower))
+	if err == nil {
+		t.Error("accounts colliding once normalized were loaded")
+	}
+}
+
+func TestWithoutNormalizer(t *testing.T) {
+	db := newTestDb(t, `{"Alice ": 10}`)
+
+	if _, err := db.GetBalance("alice"); err == nil {
+		t.Error("names are normalized by default")
+	}
+}
+
+func TestAccountCount(t *testing.T) {
+	db := newTestDb(t, `{"alice": 10, "bob": 0}`)
+
+	check := func(want int) {
+		t.Helper()
+		if n := db.AccountCount(false); n != want {
+			t.Errorf("got %d accounts, want %d", n, want)
+		}
+		if n := db.AccountCount(true); n != want+1 {
+			t.Errorf("got %d accounts with the validator, want %d", n, want+1)
+		}
+	}
+	check(2)
+
+	err := db.UpdateBy("carol", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(3)
+
+	err = db.Delete("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(2)
+
+	if db.Delete(ValidatorAccount) == nil {
+		t.Error("validator account was deleted")
+	}
+	if db.Delete("alice") == nil {
+		t.Error("deleted account was deleted again")
+	}
+	check(2)
+}
+
+func TestOnAccountCreated(t *testing.T) {
+	db := newTestDb(t, `{"alice": 10}`)
+
+	created := make(map[string][]float64)
+	db.OnAccountCreated(func(account string, initialBalance float64) {
+		created[account] = append(created[account], initialBalance)
+	})
+
+	// Copies don't report accounts created on them.
+	copy := db.Copy()
+	copy.UpdateBy("dave", 1)
+
+	db.UpdateBy("alice", 5)
+	db.UpdateBy("bob", 3)
+	db.UpdateBy("bob", 4)
+	db.Apply(map[string]float64{"alice": -1, "carol": 2}, nil, 1)
+
+	want := map[string][]float64{"bob": {3}, "carol": {2}}
+	if !reflect.DeepEqual(created, want) {
+		t.Errorf("created accounts %v, want %v", created, want)
+	}
+}
+
+// writeSnapshots writes every snapshot to a file of its own,
+// returning their paths.
+func writeSnapshots(t *testing.T, snapshots ...string) []string {
+	t.Helper()
+
+	paths := make([]string, len(snapshots))
+	for i, snapshot := range snapshots {
+		paths[i] = filepath.Join(t.TempDir(), fmt.Sprintf("accounts-%d.json", i))
+		err := os.WriteFile(paths[i], []byte(snapshot), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return paths
+}
+
+func TestInitFromSnapshots(t *testing.T) {
+	db, err := InitFromSnapshots(writeSnapshots(t, `{"alice": 10, "bob": 1}`, `{"carol": 5, "validator": 7}`)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]float64{"alice": 10, "bob": 1, "carol": 5, ValidatorAccount: 7}
+	for account, balance := range want {
+		if got, _ := db.GetBalance(account); got != balance {
+			t.Errorf("merged balance of %s is %v, want %v", account, got, balance)
+		}
+	}
+	if n := db.AccountCount(true); n != len(want) {
+		t.Errorf("got %d accounts, want %d", n, len(want))
+	}
+
+	// Validator account is created once if missing from every file.
+	db, err = InitFromSnapshots(writeSnapshots(t, `{"alice": 10}`, `{"bob": 1}`)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := db.AccountCount(true); n != 3 {
+		t.Errorf("got %d accounts, want 3", n)
+	}
+}
+
+func TestInitFromSnapshotsConflict(t *testing.T) {
+	for _, snapshots := range [][]string{
+		{`{"alice": 10, "bob": 1}`, `{"bob": 1}`},
+		{`{"alice": 10, "validator": 1}`, `{"validator": 1}`},
+	} {
+		_, err := InitFromSnapshots(writeSnapshots(t, snapshots...)...)
+		if err == nil || !strings.Contains(err.Error(), "duplicate account") {
+			t.Errorf("merging %v gave error %v, want a duplicate account", snapshots, err)
+		}
+	}
+}
+
+// move moves amount from one account to another in two steps,
+// checking nobody else touches them meanwhile.
+func move(db *AccountsDb, from, to string, amount float64) error {
+	a, _ := db.GetBalance(from)
+	b, _ := db.GetBalance(to)
+
+	err := db.UpdateBy(from, -amount)
+	if err != nil {
+		return err
+	}
+	runtime.Gosched()
+	err = db.UpdateBy(to, amount)
+	if err != nil {
+		return err
+	}
+
+	c, _ := db.GetBalance(from)
+	d, _ := db.GetBalance(to)
+	if c != a-amount || d != b+amount {
+		return fmt.Errorf("%s and %s changed from %v and %v to %v and %v, moving %v", from, to, a, b, c, d, amount)
+	}
+
+	return nil
+}
+
+// Run with -race.
+func TestWithLockOverlapping(t *testing.T) {
+	db := newTestDb(t, `{"alice": 1000, "bob": 1000, "carol": 1000}`)
+
+	// Both sets have bob, given in either order.
+	sets := [][]string{{"alice", "bob"}, {"carol", "bob"}}
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := range 4 {
+		set := sets[i%2]
+		wg.Go(func() {
+			for range 200 {
+				err := db.WithLock(set, func(db *AccountsDb) error {
+					if err := move(db, set[0], set[1], 1); err != nil {
+						return err
+					}
+					return move(db, set[1], set[0], 2)
+				})
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		})
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+	if n := len(db.accountLocks); n != 0 {
+		t.Errorf("%d account lock(s) left once released, want 0", n)
+	}
+
+	// Each set moved 400 net out of bob, twice.
+	want := map[string]float64{"alice": 1400, "bob": 200, "carol": 1400}
+	for account, want := range want {
+		if balance, _ := db.GetBalance(account); balance != want {
+			t.Errorf("%s has %v, want %v", account, balance, want)
+		}
+	}
+}
+
+func TestWithLockReturnsError(t *testing.T) {
+	db := newTestDb(t, `{"alice": 1}`)
+
+	want := errors.New("failed")
+	err := db.WithLock([]string{"alice", "alice"}, func(db *AccountsDb) error {
+		return want
+	})
+	if err != want {
+		t.Errorf("got error %v, want %v", err, want)
+	}
+
+	// Locks are released either way.
+	err = db.WithLock([]string{"alice"}, func(db *AccountsDb) error { return nil })
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFreezeUnfreeze(t *testing.T) {
+	db := newTestDb(t, `{"alice": 10}`)
+
+	err := db.Freeze("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !db.IsFrozen("alice") {
+		t.Fatal("alice isn't frozen")
+	}
+	if err := db.UpdateBy("alice", -1); err == nil {
+		t.Error("debited a frozen account")
+	}
+
+	err = db.Unfreeze("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateBy("alice", -1); err != nil {
+		t.Errorf("can't debit an unfrozen account: %v", err)
+	}
+}
+
+func TestOverdraft(t *testing.T) {
+	db := newTestDb(t, `{"alice": 10, "pool": {"amount": 10, "overdraft": 50}}`)
+
+	// Without an overdraft, the zero floor holds.
+	if err := db.UpdateBy("alice", -20); err == nil {
+		t.Error("alice went below zero")
+	}
+	if err := db.UpdateBy("pool", -20); err != nil {
+		t.Errorf("can't overdraw the pool: %v", err)
+	}
+	if err := db.UpdateBy("pool", -41); err == nil {
+		t.Error("pool went beyond its overdraft")
+	}
+	if balance, _ := db.GetBalance("pool"); balance != -10 {
+		t.Errorf("pool has %v, want -10", balance)
+	}
+
+	err := db.SetOverdraft("alice", 15)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateBy("alice", -20); err != nil {
+		t.Errorf("can't overdraw alice: %v", err)
+	}
+	if available, _ := db.Available("alice"); available != 5 {
+		t.Errorf("alice has %v available, want 5", available)
+	}
+	if err := db.SetOverdraft("nobody", 1); err == nil {
+		t.Error("set the overdraft of a missing account")
+	}
+}
+
+// Run with -race.
+func TestApplyIsAtomic(t *testing.T) {
+	db := newTestDb(t, `{"alice": 1000, "bob": 1000, "carol": 1000}`)
+
+	// Batches move balance around, never changing the total.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 1000 {
+			db.Apply(map[string]float64{"alice": -2, "bob": 1, "carol": 1}, nil, uint64(i+1))
+		}
+	}()
+
+	for reading := true; reading; {
+		select {
+		case <-done:
+			reading = false
+		default:
+		}
+
+		balances := db.Balances()
+		if total := balances["alice"] + balances["bob"] + balances["carol"]; total != 3000 {
+			t.Fatalf("read a partially applied batch: %v", balances)
+		}
+	}
+
+	want := map[string]float64{"alice": -1000, "bob": 2000, "carol": 2000, ValidatorAccount: 0}
+	if balances := db.Balances(); !maps.Equal(balances, want) {
+		t.Errorf("balances %v, want %v", balances, want)
+	}
+}
+
+// BenchmarkCommit commits batches touching 64 accounts, applying them
+// at once or account by account, while readers query balances.
+func BenchmarkCommit(b *testing.B) {
+	const accounts = 64
+
+	snapshot := []byte("{")
+	deltas := make(map[string]float64, accounts)
+	for i := range accounts {
+		if i > 0 {
+			snapshot = append(snapshot, ',')
+		}
+		snapshot = fmt.Appendf(snapshot, `"account%d": 1`, i)
+		deltas[fmt.Sprintf("account%d", i)] = 1
+	}
+	snapshot = append(snapshot, '}')
+
+	commits := map[string]func(db *AccountsDb, batchIdx uint64){
+		"apply": func(db *AccountsDb, batchIdx uint64) {
+			db.Apply(deltas, nil, batchIdx)
+		},
+		"update-by": func(db *AccountsDb, batchIdx uint64) {
+			for account, delta := range deltas {
+				db.UpdateBy(account, delta)
+			}
+			db.MarkUpdated(batchIdx, slices.Collect(maps.Keys(deltas))...)
+		},
+	}
+
+	for _, name := range []string{"apply", "update-by"} {
+		b.Run(name, func(b *testing.B) {
+			db, err := InitFromReader(bytes.NewReader(snapshot))
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			var readers sync.WaitGroup
+			done := make(chan struct{})
+			for i := range 4 {
+				account := fmt.Sprintf("account%d", i)
+				readers.Go(func() {
+					for {
+						select {
+						case <-done:
+							return
+						default:
+							db.GetBalance(account)
+						}
+					}
+				})
+			}
+
+			b.ResetTimer()
+			for i := range b.N {
+				commits[name](db, uint64(i+1))
+			}
+			b.StopTimer()
+
+			close(done)
+			readers.Wait()
+		})
+	}
+}
+
+func TestReservedAccountPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  ReservedAccountPolicy
+		missing float64 // Validator balance if missing from the snapshot, -1 for an error.
+	}{
+		{"create zero", CreateZero, 0},
+		{"require existing", RequireExisting, -1},
+		{"create with balance", CreateWithBalance(5), 5},
+	}
+
+	for _, test := range tests {
+		// Kept as is whatever the policy.
+		db := newTestDb(t, `{"alice": 1, "validator": 7}`, WithReservedAccountPolicy(test.policy))
+		if balance, _ := db.GetBalance(ValidatorAccount); balance != 7 {
+			t.Errorf("%s: existing validator account has %v, want 7", test.name, balance)
+		}
+
+		db, err := InitFromReader(strings.NewReader(`{"alice": 1}`), WithReservedAccountPolicy(test.policy))
+		if test.missing < 0 {
+			if err == nil {
+				t.Errorf("%s: missing validator account not reported", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if balance, err := db.GetBalance(ValidatorAccount); err != nil || balance != test.missing {
+			t.Errorf("%s: missing validator account has %v (error %v), want %v", test.name, balance, err, test.missing)
+		}
+	}
+}
+
+func TestRestore(t *testing.T) {
+	db := newTestDb(t, `{"alice": 1, "bob": 2, "validator": 3}`,
+		WithNormalizer(TrimLower), WithReservedAccountPolicy(RequireExisting))
+
+	err := db.Restore(Accounts{" Bob": {Amount: 5}, "carol": {Amount: 6}, "validator": {Amount: 7}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]float64{"bob": 5, "carol": 6, "validator": 7}
+	if balances := db.Balances(); !maps.Equal(balances, want) {
+		t.Errorf("restored balances %v, want %v", balances, want)
+	}
+
+	// Each failure leaves the db as it was.
+	for name, accounts := range map[string]Accounts{
+		"negative balance":  {"alice": {Amount: -1}, "validator": {}},
+		"duplicate account": {"alice": {}, " Alice": {}, "validator": {}},
+		"missing validator": {"alice": {Amount: 1}},
+	} {
+		err := db.Restore(accounts)
+		if err == nil {
+			t.Errorf("%s: restored", name)
+		}
+		if balances := db.Balances(); !maps.Equal(balances, want) {
+			t.Errorf("%s: balances %v once failed, want %v", name, balances, want)
+		}
+	}
+}
+
+func TestCopy(t *testing.T) {
+	db := newTestDb(t, `{"alice": 1, "bob": 2, "carol": 3}`)
+	copy := db.Copy()
+
+	copy.SetBalance("alice", 10)
+	copy.SetBalance("dave", 4)
+	err := copy.Delete("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]float64{"alice": 10, "carol": 3, "dave": 4, ValidatorAccount: 0}
+	if balances := copy.Balances(); !maps.Equal(balances, want) {
+		t.Errorf("copy has %v, want %v", balances, want)
+	}
+	if n := copy.AccountCount(true); n != len(want) {
+		t.Errorf("copy has %d accounts, want %d", n, len(want))
+	}
+	original := map[string]float64{"alice": 1, "bob": 2, "carol": 3, ValidatorAccount: 0}
+	if balances := db.Balances(); !maps.Equal(balances, original) {
+		t.Errorf("original has %v once the copy is modified, want %v", balances, original)
+	}
+
+	// Accounts the copy hasn't modified are read from the original.
+	db.SetBalance("carol", 30)
+	db.SetBalance("bob", 20)
+	if balance, _ := copy.GetBalance("carol"); balance != 30 {
+		t.Errorf("carol has %v on the copy, want 30", balance)
+	}
+	if copy.Exists("bob") {
+		t.Error("bob deleted on the copy exists again")
+	}
+
+	// Copies of copies too.
+	twice := copy.Copy()
+	twice.SetBalance("carol", 300)
+	if balance, _ := twice.GetBalance("alice"); balance != 10 {
+		t.Errorf("alice has %v on the copy of the copy, want 10", balance)
+	}
+	if balance, _ := copy.GetBalance("carol"); balance != 30 {
+		t.Errorf("carol has %v on the copy, want 30", balance)
+	}
+}
@@ -0,0 +1,62 @@
+package accountsdb
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// Balance is the record of an account: its amount and metadata.
+type Balance struct {
+	Amount float64 `json:"amount"`
+	// Frozen accounts can't be debited.
+	Frozen bool `json:"frozen,omitempty"`
+	// Index of the batch that's last changed the account.
+	UpdatedAt uint64 `json:"updatedAt,omitempty"`
+	// How far below zero the amount may go, e.g. for liquidity pools.
+	Overdraft float64 `json:"overdraft,omitempty"`
+	// Number of committed transactions that have changed the account.
+	Sequence uint64 `json:"sequence,omitempty"`
+}
+
+// hasMetadata returns true if anything but the amount is set.
+func (balance Balance) hasMetadata() bool {
+	return balance.Frozen || balance.UpdatedAt != 0 || balance.Overdraft != 0 || balance.Sequence != 0
+}
+
+// Available returns how much can be taken from the account,
+// the amount plus the overdraft allowance.
+func (balance Balance) Available() float64 {
+	return balance.Amount + balance.Overdraft
+}
+
+// MarshalJSON writes balances without metadata as bare numbers,
+// so that such snapshots stay in the plain format.
+func (balance Balance) MarshalJSON() ([]byte, error) {
+	if !balance.hasMetadata() {
+		return json.Marshal(balance.Amount)
+	}
+
+	// Alias drops the methods, otherwise we'd recurse.
+	type plain Balance
+	return json.Marshal(plain(balance))
+}
+
+// UnmarshalJSON reads a balance either as a bare number,
+// or as an object carrying metadata.
+func (balance *Balance) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || data[0] != '{' {
+		*balance = Balance{}
+		return json.Unmarshal(data, &balance.Amount)
+	}
+
+	type plain Balance
+	var decoded plain
+	err := json.Unmarshal(data, &decoded)
+	if err != nil {
+		return err
+	}
+
+	*balance = Balance(decoded)
+	return nil
+}
@@ -0,0 +1,65 @@
+package accountsdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	db := newTestDb(t, `{
+		"alice": 10,
+		"bob": {"amount": 20, "frozen": true, "updatedAt": 3},
+		"carol": {"amount": 0.5}
+	}`)
+
+	var buffer bytes.Buffer
+	err := db.WriteSnapshot(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Metadata survives, plain balances stay bare numbers.
+	var written map[string]json.RawMessage
+	err = json.Unmarshal(buffer.Bytes(), &written)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for account, want := range map[string]string{
+		"alice": `10`,
+		"bob":   `{"amount":20,"frozen":true,"updatedAt":3}`,
+		"carol": `0.5`,
+	} {
+		if got := string(written[account]); got != want {
+			t.Errorf("%s is written as %s, want %s", account, got, want)
+		}
+	}
+
+	reloaded := newTestDb(t, buffer.String())
+	for account := range db.Balances() {
+		want, _ := db.GetAccount(account)
+		got, err := reloaded.GetAccount(account)
+		if err != nil {
+			t.Errorf("%s: %v", account, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("%s is %+v after reload, want %+v", account, got, want)
+		}
+	}
+}
+
+func TestFrozenAccountCannotBeDebited(t *testing.T) {
+	db := newTestDb(t, `{"alice": {"amount": 10, "frozen": true}}`)
+
+	if err := db.UpdateBy("alice", -1); err == nil {
+		t.Error("debited a frozen account")
+	}
+	// Credits are fine.
+	if err := db.UpdateBy("alice", 5); err != nil {
+		t.Error(err)
+	}
+	if balance, _ := db.GetBalance("alice"); balance != 15 {
+		t.Errorf("balance is %v, want 15", balance)
+	}
+}
@@ -0,0 +1,71 @@
+package accountsdb
+
+import (
+	"hash/fnv"
+	"math"
+	"slices"
+)
+
+// bloomFilter answers whether an account may exist.
+// "No" is always right, "yes" may be a false positive.
+//
+// Items can't be removed from a bloom filter; deleted accounts
+// keep their bits set and only cost an extra map lookup.
+type bloomFilter struct {
+	bits []uint64
+	k    uint64 // Number of hashes per item.
+}
+
+// newBloomFilter creates a filter sized for n items with
+// given false positive rate.
+func newBloomFilter(n int, falsePositiveRate float64) *bloomFilter {
+	n = max(n, 1)
+
+	// Optimal size and hash count for the rate.
+	m := math.Ceil(-float64(n) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	k := math.Round(m / float64(n) * math.Ln2)
+
+	return &bloomFilter{
+		bits: make([]uint64, (uint64(m)+63)/64),
+		k:    max(uint64(k), 1),
+	}
+}
+
+// hashes returns the two base hashes of an item,
+// the rest is derived by double hashing.
+func (filter *bloomFilter) hashes(item string) (uint64, uint64) {
+	hasher := fnv.New64a()
+	hasher.Write([]byte(item))
+	sum := hasher.Sum64()
+
+	// Keep the second hash odd so it can't get stuck on a cycle.
+	return sum, (sum>>32 | sum<<32) | 1
+}
+
+func (filter *bloomFilter) add(item string) {
+	h1, h2 := filter.hashes(item)
+	size := uint64(len(filter.bits)) * 64
+
+	for i := range filter.k {
+		bit := (h1 + i*h2) % size
+		filter.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+func (filter *bloomFilter) mayContain(item string) bool {
+	h1, h2 := filter.hashes(item)
+	size := uint64(len(filter.bits)) * 64
+
+	for i := range filter.k {
+		bit := (h1 + i*h2) % size
+		if filter.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (filter *bloomFilter) clone() *bloomFilter {
+	return &bloomFilter{bits: slices.Clone(filter.bits), k: filter.k}
+}
@@ -0,0 +1,109 @@
+package accountsdb
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestBloomFilter(t *testing.T) {
+	filter := newBloomFilter(1000, 0.01)
+	for i := range 1000 {
+		filter.add(fmt.Sprintf("account%d", i))
+	}
+
+	for i := range 1000 {
+		if !filter.mayContain(fmt.Sprintf("account%d", i)) {
+			t.Fatalf("account%d was added but isn't contained", i)
+		}
+	}
+
+	falsePositives := 0
+	for i := range 10000 {
+		if filter.mayContain(fmt.Sprintf("missing%d", i)) {
+			falsePositives++
+		}
+	}
+	// 1% expected, leave room for chance.
+	if falsePositives > 300 {
+		t.Errorf("%d false positives out of 10000", falsePositives)
+	}
+}
+
+func TestExistsWithBloomFilter(t *testing.T) {
+	db := newTestDb(t, `{"alice": 10}`, WithBloomFilter(100, 0.01))
+
+	if !db.Exists("alice") || !db.Exists(ValidatorAccount) {
+		t.Error("loaded accounts don't exist")
+	}
+	if db.Exists("bob") {
+		t.Error("bob exists before being created")
+	}
+
+	err := db.UpdateBy("bob", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !db.Exists("bob") {
+		t.Error("bob doesn't exist once created")
+	}
+
+	// Deleted accounts keep their bits, the store has the last word.
+	err = db.Delete("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.Exists("bob") {
+		t.Error("bob exists once deleted")
+	}
+
+	// Copies have a filter of their own.
+	copy := db.Copy()
+	copy.UpdateBy("carol", 1)
+	if !copy.Exists("carol") || db.Exists("carol") {
+		t.Error("carol created on a copy isn't only in the copy")
+	}
+}
+
+// BenchmarkExists looks up mostly missing accounts, the case the bloom
+// filter is for.
+func BenchmarkExists(b *testing.B) {
+	const accounts = 100000
+
+	snapshot := []byte("{")
+	for i := range accounts {
+		if i > 0 {
+			snapshot = append(snapshot, ',')
+		}
+		snapshot = fmt.Appendf(snapshot, `"account%d": 1`, i)
+	}
+	snapshot = append(snapshot, '}')
+
+	lookups := make([]string, 1024)
+	for i := range lookups {
+		// One in ten exists.
+		if i%10 == 0 {
+			lookups[i] = fmt.Sprintf("account%d", i*97%accounts)
+		} else {
+			lookups[i] = fmt.Sprintf("missing%d", i)
+		}
+	}
+
+	for _, bloom := range []bool{false, true} {
+		b.Run(fmt.Sprintf("bloom=%v", bloom), func(b *testing.B) {
+			var opts []Option
+			if bloom {
+				opts = append(opts, WithBloomFilter(accounts, 0.01))
+			}
+			db, err := InitFromReader(bytes.NewReader(snapshot), opts...)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.ResetTimer()
+			for i := range b.N {
+				db.Exists(lookups[i%len(lookups)])
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+// Package boltstore keeps balances of an accounts db in a BoltDB file,
+// so they persist across restarts without snapshot files.
+package boltstore
+
+import (
+	"encoding/json"
+	"fmt"
+	adb "transactioner/accountsdb"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Bucket balances are kept in, by account name.
+var accountsBucket = []byte("accounts")
+
+// Store is an accountsdb.Store on top of a BoltDB file. Every change is
+// written in its own transaction, it's durable once the call returns.
+// Batches applied by the db are written in a single one, see SetMany.
+//
+// The db interface has no way to report storage errors, so failing to
+// read or write the file panics rather than losing balances silently.
+type Store struct {
+	db *bolt.DB
+	n  int // Number of accounts, counted once opened and kept up to date.
+}
+
+var _ adb.BatchStore = (*Store)(nil)
+
+// Open opens the store at given path, creating it if it doesn't exist.
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var n int
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(accountsBucket)
+		if err != nil {
+			return err
+		}
+
+		n = bucket.Stats().KeyN
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Store{db: db, n: n}, nil
+}
+
+// Close closes the underlying file.
+func (store *Store) Close() error {
+	return store.db.Close()
+}
+
+func (store *Store) Get(account string) (balance adb.Balance, ok bool) {
+	store.view(func(bucket *bolt.Bucket) error {
+		value := bucket.Get([]byte(account))
+		if value == nil {
+			return nil
+		}
+
+		ok = true
+		return json.Unmarshal(value, &balance)
+	})
+
+	return balance, ok
+}
+
+func (store *Store) Set(account string, balance adb.Balance) {
+	value, err := json.Marshal(balance)
+	if err != nil {
+		panic(err)
+	}
+
+	var created bool
+	store.update(func(bucket *bolt.Bucket) error {
+		created = bucket.Get([]byte(account)) == nil
+		return bucket.Put([]byte(account), value)
+	})
+	if created {
+		store.n++
+	}
+}
+
+// SetMany stores every given balance in a single transaction, either
+// all of them are persisted or none.
+func (store *Store) SetMany(balances adb.Accounts) {
+	values := make(map[string][]byte, len(balances))
+	for account, balance := range balances {
+		value, err := json.Marshal(balance)
+		if err != nil {
+			panic(err)
+		}
+		values[account] = value
+	}
+
+	var created int
+	store.update(func(bucket *bolt.Bucket) error {
+		created = 0
+		for account, value := range values {
+			if bucket.Get([]byte(account)) == nil {
+				created++
+			}
+			err := bucket.Put([]byte(account), value)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	store.n += created
+}
+
+func (store *Store) Delete(account string) {
+	var deleted bool
+	store.update(func(bucket *bolt.Bucket) error {
+		deleted = bucket.Get([]byte(account)) != nil
+		return bucket.Delete([]byte(account))
+	})
+	if deleted {
+		store.n--
+	}
+}
+
+func (store *Store) Len() int {
+	return store.n
+}
+
+func (store *Store) Range(fn func(account string, balance adb.Balance) bool) {
+	store.view(func(bucket *bolt.Bucket) error {
+		cursor := bucket.Cursor()
+		for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+			var balance adb.Balance
+			err := json.Unmarshal(value, &balance)
+			if err != nil {
+				return fmt.Errorf("account %q: %w", key, err)
+			}
+
+			if !fn(string(key), balance) {
+				return nil
+			}
+		}
+
+		return nil
+	})
+}
+
+func (store *Store) Snapshot() adb.Accounts {
+	accounts := make(adb.Accounts)
+	store.Range(func(account string, balance adb.Balance) bool {
+		accounts[account] = balance
+		return true
+	})
+
+	return accounts
+}
+
+func (store *Store) view(fn func(*bolt.Bucket) error) {
+	err := store.db.View(func(tx *bolt.Tx) error {
+		return fn(tx.Bucket(accountsBucket))
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (store *Store) update(fn func(*bolt.Bucket) error) {
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		return fn(tx.Bucket(accountsBucket))
+	})
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,71 @@
+package boltstore
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	adb "transactioner/accountsdb"
+	"transactioner/accountsdb/storetest"
+)
+
+// open opens a store at path, closing it when the test ends.
+func open(t *testing.T, path string) *Store {
+	t.Helper()
+
+	store, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	return store
+}
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) adb.Store {
+		return open(t, filepath.Join(t.TempDir(), "accounts.db"))
+	})
+}
+
+func TestReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.db")
+
+	store := open(t, path)
+	db, err := adb.InitFromReader(strings.NewReader(`{"alice": 100, "bob": 0}`), adb.WithStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.UpdateBy("alice", -10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Apply(map[string]float64{"bob": 10}, nil, 1)
+	err = db.Freeze("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := store.Snapshot()
+
+	err = store.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A restart, no snapshot file involved.
+	store = open(t, path)
+	if n := store.Len(); n != len(want) {
+		t.Errorf("got %d accounts after reopening, want %d", n, len(want))
+	}
+	reopened, err := adb.InitFromStore(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for account, balance := range want {
+		if got, err := reopened.GetAccount(account); err != nil || got != balance {
+			t.Errorf("got %+v for %s after reopening, want %+v", got, account, balance)
+		}
+	}
+	if !reopened.IsFrozen("alice") {
+		t.Error("alice isn't frozen anymore after reopening")
+	}
+}
@@ -0,0 +1,188 @@
+package accountsdb
+
+import "maps"
+
+// Store is where a db keeps its balances. Account names given to a store
+// are already normalized. Stores don't need to be safe for concurrent use,
+// the db guards them.
+//
+// MemStore is used unless the db is given another one, see WithStore.
+type Store interface {
+	// Get returns the balance of the account, ok is false if there's none.
+	Get(account string) (balance Balance, ok bool)
+	// Set stores the balance of the account, creating it if needed.
+	Set(account string, balance Balance)
+	// Delete removes the account, if exists.
+	Delete(account string)
+	// Len returns the number of accounts.
+	Len() int
+	// Range calls fn for every account, until fn returns false.
+	// fn must not modify the store.
+	Range(fn func(account string, balance Balance) bool)
+	// Snapshot returns a copy of every account.
+	Snapshot() Accounts
+}
+
+// BatchStore is a store that can set many balances as one operation,
+// e.g. in a single transaction of the underlying database, so that a
+// batch costs one write and is never half-persisted. AccountsDb.Apply
+// uses it when the store implements it, otherwise balances are set one
+// by one.
+type BatchStore interface {
+	Store
+	// SetMany stores every given balance, creating accounts if needed.
+	SetMany(balances Accounts)
+}
+
+// setMany stores every given balance, at once if the store can.
+func setMany(store Store, balances Accounts) {
+	if batch, ok := store.(BatchStore); ok {
+		batch.SetMany(balances)
+		return
+	}
+
+	for account, balance := range balances {
+		store.Set(account, balance)
+	}
+}
+
+// MemStore keeps balances in a map, they're lost once the process exits.
+type MemStore struct {
+	accounts Accounts
+}
+
+// NewMemStore creates an empty in-memory store.
+func NewMemStore() *MemStore {
+	return &MemStore{accounts: make(Accounts)}
+}
+
+func (store *MemStore) Get(account string) (Balance, bool) {
+	balance, ok := store.accounts[account]
+	return balance, ok
+}
+
+func (store *MemStore) Set(account string, balance Balance) {
+	store.accounts[account] = balance
+}
+
+func (store *MemStore) SetMany(balances Accounts) {
+	maps.Copy(store.accounts, balances)
+}
+
+func (store *MemStore) Delete(account string) {
+	delete(store.accounts, account)
+}
+
+func (store *MemStore) Len() int {
+	return len(store.accounts)
+}
+
+func (store *MemStore) Range(fn func(account string, balance Balance) bool) {
+	for account, balance := range store.accounts {
+		if !fn(account, balance) {
+			return
+		}
+	}
+}
+
+func (store *MemStore) Snapshot() Accounts {
+	return maps.Clone(store.accounts)
+}
+
+// overlayStore is the store of a copy of a db. It keeps changes of the
+// copy in memory, reading accounts the copy hasn't changed through to
+// the original db, so that copying doesn't cost a copy of every account.
+type overlayStore struct {
+	base    *AccountsDb
+	changed Accounts            // Set on the copy.
+	deleted map[string]struct{} // Deleted on the copy.
+}
+
+func newOverlayStore(base *AccountsDb) *overlayStore {
+	return &overlayStore{base: base, changed: make(Accounts), deleted: make(map[string]struct{})}
+}
+
+func (store *overlayStore) Get(account string) (Balance, bool) {
+	if balance, ok := store.changed[account]; ok {
+		return balance, true
+	}
+	if _, ok := store.deleted[account]; ok {
+		return Balance{}, false
+	}
+
+	return store.baseGet(account)
+}
+
+// baseGet reads the account from the original db.
+func (store *overlayStore) baseGet(account string) (Balance, bool) {
+	store.base.mu.RLock()
+	defer store.base.mu.RUnlock()
+
+	return store.base.store.Get(account)
+}
+
+func (store *overlayStore) Set(account string, balance Balance) {
+	store.changed[account] = balance
+	delete(store.deleted, account)
+}
+
+func (store *overlayStore) SetMany(balances Accounts) {
+	for account, balance := range balances {
+		store.Set(account, balance)
+	}
+}
+
+func (store *overlayStore) Delete(account string) {
+	delete(store.changed, account)
+	store.deleted[account] = struct{}{}
+}
+
+func (store *overlayStore) Len() int {
+	store.base.mu.RLock()
+	defer store.base.mu.RUnlock()
+
+	n := store.base.store.Len()
+	for account := range store.changed {
+		if _, ok := store.base.store.Get(account); !ok {
+			n++
+		}
+	}
+	for account := range store.deleted {
+		if _, ok := store.base.store.Get(account); ok {
+			n--
+		}
+	}
+
+	return n
+}
+
+func (store *overlayStore) Range(fn func(account string, balance Balance) bool) {
+	for account, balance := range store.changed {
+		if !fn(account, balance) {
+			return
+		}
+	}
+
+	store.base.mu.RLock()
+	defer store.base.mu.RUnlock()
+
+	store.base.store.Range(func(account string, balance Balance) bool {
+		if _, ok := store.changed[account]; ok {
+			return true
+		}
+		if _, ok := store.deleted[account]; ok {
+			return true
+		}
+		return fn(account, balance)
+	})
+}
+
+func (store *overlayStore) Snapshot() Accounts {
+	accounts := make(Accounts)
+	store.Range(func(account string, balance Balance) bool {
+		accounts[account] = balance
+		return true
+	})
+
+	return accounts
+}
@@ -0,0 +1,11 @@
+package accountsdb_test
+
+import (
+	"testing"
+	adb "transactioner/accountsdb"
+	"transactioner/accountsdb/storetest"
+)
+
+func TestMemStore(t *testing.T) {
+	storetest.Run(t, func(*testing.T) adb.Store { return adb.NewMemStore() })
+}
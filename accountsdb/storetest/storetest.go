@@ -0,0 +1,149 @@
+// Package storetest tests implementations of accountsdb.Store,
+// so that every store is held to the same behavior.
+package storetest
+
+import (
+	"maps"
+	"strings"
+	"testing"
+	adb "transactioner/accountsdb"
+)
+
+// Run tests a store, directly and as the store of a db. Open must
+// return a new empty store every time it's called.
+func Run(t *testing.T, open func(t *testing.T) adb.Store) {
+	t.Run("GetSetDelete", func(t *testing.T) {
+		store := open(t)
+
+		if _, ok := store.Get("alice"); ok {
+			t.Error("got a balance from an empty store")
+		}
+
+		alice := adb.Balance{Amount: 10, Frozen: true, UpdatedAt: 3, Overdraft: 5, Sequence: 7}
+		store.Set("alice", alice)
+		store.Set("bob", adb.Balance{Amount: 1})
+		if got, ok := store.Get("alice"); !ok || got != alice {
+			t.Errorf("got %+v, want %+v", got, alice)
+		}
+		if n := store.Len(); n != 2 {
+			t.Errorf("got %d accounts, want 2", n)
+		}
+
+		// Overwritten, not added.
+		store.Set("bob", adb.Balance{Amount: 2})
+		if got, _ := store.Get("bob"); got.Amount != 2 {
+			t.Errorf("bob has %v, want 2", got.Amount)
+		}
+
+		store.Delete("bob")
+		store.Delete("nobody")
+		if _, ok := store.Get("bob"); ok {
+			t.Error("bob is still there once deleted")
+		}
+		if n := store.Len(); n != 1 {
+			t.Errorf("got %d accounts, want 1", n)
+		}
+	})
+
+	t.Run("RangeAndSnapshot", func(t *testing.T) {
+		store := open(t)
+
+		want := adb.Accounts{"alice": {Amount: 1}, "bob": {Amount: 2, Frozen: true}, "carol": {Amount: 3}}
+		for account, balance := range want {
+			store.Set(account, balance)
+		}
+
+		got := make(adb.Accounts)
+		store.Range(func(account string, balance adb.Balance) bool {
+			got[account] = balance
+			return true
+		})
+		if !maps.Equal(got, want) {
+			t.Errorf("ranged over %v, want %v", got, want)
+		}
+
+		calls := 0
+		store.Range(func(string, adb.Balance) bool {
+			calls++
+			return false
+		})
+		if calls != 1 {
+			t.Errorf("range went on for %d calls after fn returned false", calls)
+		}
+
+		snapshot := store.Snapshot()
+		if !maps.Equal(snapshot, want) {
+			t.Errorf("got snapshot %v, want %v", snapshot, want)
+		}
+		// It's a copy.
+		snapshot["alice"] = adb.Balance{Amount: 100}
+		if got, _ := store.Get("alice"); got.Amount != 1 {
+			t.Errorf("modifying a snapshot changed the store")
+		}
+	})
+
+	t.Run("SetMany", func(t *testing.T) {
+		store, ok := open(t).(adb.BatchStore)
+		if !ok {
+			t.Skip("not a batch store")
+		}
+
+		store.Set("alice", adb.Balance{Amount: 1})
+		store.SetMany(adb.Accounts{"alice": {Amount: 2}, "bob": {Amount: 3}})
+		want := adb.Accounts{"alice": {Amount: 2}, "bob": {Amount: 3}}
+		if got := store.Snapshot(); !maps.Equal(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if n := store.Len(); n != 2 {
+			t.Errorf("got %d accounts, want 2", n)
+		}
+	})
+
+	t.Run("Db", func(t *testing.T) {
+		store := open(t)
+		db, err := adb.InitFromReader(strings.NewReader(`{"alice": 100, "bob": {"amount": 5, "frozen": true}}`), adb.WithStore(store))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = db.UpdateBy("alice", -30)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.UpdateBy("bob", -1); err == nil {
+			t.Error("debited a frozen account")
+		}
+		db.Apply(map[string]float64{"alice": -10, "carol": 10}, map[string]uint64{"alice": 1}, 4)
+		err = db.Delete("bob")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Changes of the db end up in the store.
+		want := adb.Accounts{
+			"alice":              {Amount: 60, UpdatedAt: 4, Sequence: 1},
+			"carol":              {Amount: 10, UpdatedAt: 4},
+			adb.ValidatorAccount: {},
+		}
+		if got := store.Snapshot(); !maps.Equal(got, want) {
+			t.Errorf("store holds %v, want %v", got, want)
+		}
+		if supply := db.TotalSupply(); supply != 70 {
+			t.Errorf("total supply is %v, want 70", supply)
+		}
+
+		// Another db picks up where the first left off.
+		reopened, err := adb.InitFromStore(store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for account, balance := range want {
+			if got, err := reopened.GetAccount(account); err != nil || got != balance {
+				t.Errorf("db on the same store holds %+v for %s, want %+v", got, account, balance)
+			}
+		}
+		if n := reopened.AccountCount(false); n != 2 {
+			t.Errorf("got %d accounts, want 2", n)
+		}
+	})
+}
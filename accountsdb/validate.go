@@ -0,0 +1,219 @@
+package accountsdb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ValidateSnapshot checks an accounts snapshot file without loading it
+// into a db. Unlike InitFromSnapshot, it doesn't stop at the first
+// problem; every problem found is reported in the returned error.
+//
+// Checked are the top-level shape (an object of numbers or balance
+// objects), duplicate account keys, negative or non-finite balances,
+// malformed balance metadata, and the checksum if
+// a sidecar "<path>.sha256" file exists next to the snapshot, as
+// written by sha256sum.
+func ValidateSnapshot(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	problems := validateAccounts(content)
+
+	err = verifyChecksum(path, content)
+	if err != nil {
+		problems = append(problems, err)
+	}
+
+	return errors.Join(problems...)
+}
+
+// Validate checks the invariants of the db at runtime, e.g. after
+// restoring a state or periodically to detect corruption: every amount
+// and overdraft is finite, no balance goes below its overdraft and the
+// validator account exists. The first violation found is returned.
+func (db *AccountsDb) Validate() error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if _, ok := db.store.Get(db.Normalize(ValidatorAccount)); !ok {
+		return errors.New("validator account is missing")
+	}
+
+	var problem error
+	db.store.Range(func(account string, balance Balance) bool {
+		switch {
+		case math.IsNaN(balance.Amount) || math.IsInf(balance.Amount, 0):
+			problem = fmt.Errorf("account %q: balance %v is not finite", account, balance.Amount)
+		case math.IsNaN(balance.Overdraft) || math.IsInf(balance.Overdraft, 0) || balance.Overdraft < 0:
+			problem = fmt.Errorf("account %q: overdraft %v is not a non-negative number", account, balance.Overdraft)
+		case balance.Available() < 0:
+			problem = fmt.Errorf("account %q: negative balance %v", account, balance.Amount)
+		}
+
+		return problem == nil
+	})
+
+	return problem
+}
+
+// validateAccounts walks the snapshot token by token, which lets it
+// notice duplicate keys that decoding into a map would silently merge.
+func validateAccounts(content []byte) []error {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+
+	token, err := decoder.Token()
+	if err != nil {
+		return []error{fmt.Errorf("malformed snapshot: %w", err)}
+	}
+	if token != json.Delim('{') {
+		return []error{errors.New("snapshot is not a JSON object")}
+	}
+
+	var problems []error
+	seen := make(map[string]bool)
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return append(problems, fmt.Errorf("malformed snapshot: %w", err))
+		}
+		account := token.(string) // Object keys are always strings.
+
+		if seen[account] {
+			problems = append(problems, fmt.Errorf("account %q: duplicate key", account))
+		}
+		seen[account] = true
+
+		var value any
+		err = decoder.Decode(&value)
+		if err != nil {
+			return append(problems, fmt.Errorf("malformed snapshot: %w", err))
+		}
+
+		// Balances with metadata carry the amount in a field.
+		var overdraft float64
+		if object, ok := value.(map[string]any); ok {
+			problems = append(problems, validateMetadata(account, object)...)
+			value = object["amount"]
+
+			if number, ok := object["overdraft"].(json.Number); ok {
+				overdraft, _ = number.Float64()
+			}
+		}
+
+		number, ok := value.(json.Number)
+		if !ok {
+			problems = append(problems, fmt.Errorf("account %q: balance is not a number", account))
+			continue
+		}
+
+		balance, err := number.Float64()
+		if err != nil || math.IsInf(balance, 0) {
+			problems = append(problems, fmt.Errorf("account %q: balance is out of range", account))
+			continue
+		}
+
+		if balance < -max(overdraft, 0) {
+			problems = append(problems, fmt.Errorf("account %q: negative balance", account))
+		}
+	}
+
+	// Consume the closing brace and make sure nothing follows it.
+	_, err = decoder.Token()
+	if err != nil {
+		return append(problems, fmt.Errorf("malformed snapshot: %w", err))
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		problems = append(problems, errors.New("unexpected data after snapshot"))
+	}
+
+	return problems
+}
+
+// validateMetadata checks the fields of a balance object other than
+// the amount, see Balance.
+func validateMetadata(account string, object map[string]any) []error {
+	var problems []error
+	for _, key := range slices.Sorted(maps.Keys(object)) {
+		value := object[key]
+		switch key {
+		case "amount":
+		case "frozen":
+			if _, ok := value.(bool); !ok {
+				problems = append(problems, fmt.Errorf("account %q: frozen is not a boolean", account))
+			}
+		case "overdraft":
+			number, ok := value.(json.Number)
+			if !ok {
+				problems = append(problems, fmt.Errorf("account %q: overdraft is not a number", account))
+				continue
+			}
+
+			if limit, err := number.Float64(); err != nil || limit < 0 || math.IsInf(limit, 0) {
+				problems = append(problems, fmt.Errorf("account %q: overdraft is not a non-negative number", account))
+			}
+		case "updatedAt":
+			number, ok := value.(json.Number)
+			if !ok {
+				problems = append(problems, fmt.Errorf("account %q: updatedAt is not a number", account))
+				continue
+			}
+
+			if _, err := strconv.ParseUint(number.String(), 10, 64); err != nil {
+				problems = append(problems, fmt.Errorf("account %q: updatedAt is not a batch index", account))
+			}
+		case "sequence":
+			number, ok := value.(json.Number)
+			if !ok {
+				problems = append(problems, fmt.Errorf("account %q: sequence is not a number", account))
+				continue
+			}
+
+			if _, err := strconv.ParseUint(number.String(), 10, 64); err != nil {
+				problems = append(problems, fmt.Errorf("account %q: sequence is not a non-negative integer", account))
+			}
+		default:
+			problems = append(problems, fmt.Errorf("account %q: unknown field %q", account, key))
+		}
+	}
+
+	return problems
+}
+
+// verifyChecksum compares the content against "<path>.sha256" if exists.
+func verifyChecksum(path string, content []byte) error {
+	sidecar, err := os.ReadFile(path + ".sha256")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// sha256sum format: "<hex digest>  <file name>".
+	fields := strings.Fields(string(sidecar))
+	if len(fields) == 0 {
+		return errors.New("checksum file is empty")
+	}
+
+	sum := sha256.Sum256(content)
+	if !strings.EqualFold(fields[0], hex.EncodeToString(sum[:])) {
+		return errors.New("checksum mismatch")
+	}
+
+	return nil
+}
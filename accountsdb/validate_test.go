@@ -0,0 +1,140 @@
+package accountsdb
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateSnapshot(t *testing.T) {
+	tests := []struct {
+		snapshot string
+		// Diagnostics the error must carry, none if valid.
+		want []string
+	}{
+		{`{"alice": 10, "bob": 0.5, "validator": 0}`, nil},
+		{`{"alice": {"amount": -5, "overdraft": 10, "frozen": true, "updatedAt": 3, "sequence": 1}}`, nil},
+
+		{`[1, 2]`, []string{"not a JSON object"}},
+		{`{"alice": 10`, []string{"malformed snapshot"}},
+		{`{"alice": 10} {}`, []string{"unexpected data after snapshot"}},
+		{`{"alice": 10, "alice": 5}`, []string{`account "alice": duplicate key`}},
+		{`{"alice": -1}`, []string{`account "alice": negative balance`}},
+		{`{"alice": {"amount": -11, "overdraft": 10}}`, []string{`account "alice": negative balance`}},
+		{`{"alice": 1e400}`, []string{`account "alice": balance is out of range`}},
+		{`{"alice": "10"}`, []string{`account "alice": balance is not a number`}},
+		{`{"alice": {"amount": 1, "frozen": "yes"}}`, []string{"frozen is not a boolean"}},
+		{`{"alice": {"amount": 1, "overdraft": -1}}`, []string{"overdraft is not a non-negative number"}},
+		{`{"alice": {"amount": 1, "sequence": 1.5}}`, []string{"sequence is not a non-negative integer"}},
+		{`{"alice": {"amount": 1, "color": "red"}}`, []string{`unknown field "color"`}},
+		// Every problem is reported, not just the first one.
+		{`{"alice": -1, "bob": "1", "alice": 2}`, []string{
+			`account "alice": negative balance`,
+			`account "bob": balance is not a number`,
+			`account "alice": duplicate key`,
+		}},
+	}
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), "accounts.json")
+		err := os.WriteFile(path, []byte(test.snapshot), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = ValidateSnapshot(path)
+		if len(test.want) == 0 {
+			if err != nil {
+				t.Errorf("%s: %v", test.snapshot, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: no problem found", test.snapshot)
+			continue
+		}
+		for _, want := range test.want {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: got %q, want %q", test.snapshot, err, want)
+			}
+		}
+	}
+}
+
+func TestValidateSnapshotChecksum(t *testing.T) {
+	snapshot := []byte(`{"alice": 10}`)
+	path := filepath.Join(t.TempDir(), "accounts.json")
+	err := os.WriteFile(path, snapshot, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256(snapshot)
+	err = os.WriteFile(path+".sha256", []byte(hex.EncodeToString(sum[:])+"  accounts.json\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ValidateSnapshot(path)
+	if err != nil {
+		t.Errorf("matching checksum: %v", err)
+	}
+
+	err = os.WriteFile(path, []byte(`{"alice": 11}`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ValidateSnapshot(path)
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("tampered snapshot gave error %v, want a checksum mismatch", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		inject func(store Store)
+		want   string // In the error, empty if valid.
+	}{
+		{"valid", func(Store) {}, ""},
+		{"overdrawn within the limit", func(store Store) {
+			store.Set("bob", Balance{Amount: -5, Overdraft: 10})
+		}, ""},
+		{"negative", func(store Store) {
+			store.Set("bob", Balance{Amount: -5})
+		}, "negative balance"},
+		{"beyond the overdraft", func(store Store) {
+			store.Set("bob", Balance{Amount: -5, Overdraft: 1})
+		}, "negative balance"},
+		{"NaN", func(store Store) {
+			store.Set("bob", Balance{Amount: math.NaN()})
+		}, "not finite"},
+		{"infinite", func(store Store) {
+			store.Set("bob", Balance{Amount: math.Inf(1)})
+		}, "not finite"},
+		{"NaN overdraft", func(store Store) {
+			store.Set("bob", Balance{Amount: 1, Overdraft: math.NaN()})
+		}, "overdraft"},
+		{"validator account missing", func(store Store) {
+			store.Delete(ValidatorAccount)
+		}, "validator account is missing"},
+	}
+
+	for _, test := range tests {
+		db := newTestDb(t, `{"alice": 10, "bob": 10}`)
+		test.inject(db.store)
+
+		err := db.Validate()
+		if test.want == "" {
+			if err != nil {
+				t.Errorf("%s: %v", test.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got error %v, want %q", test.name, err, test.want)
+		}
+	}
+}
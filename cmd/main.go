@@ -1,10 +1,31 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+	"transactioner/accountsdb"
+	"transactioner/loadgen"
 	"transactioner/validator"
 )
 
 func main() {
+	// Check snapshots without starting the validator:
+	//
+	//	go run cmd/main.go validate-snapshot accounts.json ...
+	if len(os.Args) > 1 && os.Args[1] == "validate-snapshot" {
+		os.Exit(validateSnapshots(os.Args[2:]))
+	}
+
+	// Measure throughput of a running validator:
+	//
+	//	go run cmd/main.go loadgen -rate 1000 -duration 10s
+	if len(os.Args) > 1 && os.Args[1] == "loadgen" {
+		os.Exit(generateLoad(os.Args[2:]))
+	}
+
 	// Create a validator.
 	vali, err := validator.NewFromSnapshot("./accounts.json")
 	if err != nil {
@@ -13,3 +34,57 @@ func main() {
 
 	vali.Run()
 }
+
+// validateSnapshots reports problems of each snapshot and
+// returns the exit code, non-zero if any snapshot is invalid.
+func validateSnapshots(paths []string) int {
+	if len(paths) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: validate-snapshot <path>...")
+		return 2
+	}
+
+	code := 0
+	for _, path := range paths {
+		err := accountsdb.ValidateSnapshot(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s:\n%v\n", path, err)
+			code = 1
+			continue
+		}
+
+		fmt.Printf("%s: ok\n", path)
+	}
+
+	return code
+}
+
+// generateLoad sends synthetic transactions to a validator and
+// reports how it went. Returns the exit code.
+func generateLoad(args []string) int {
+	flags := flag.NewFlagSet("loadgen", flag.ExitOnError)
+	addr := flags.String("addr", "localhost:2001", "UDP address of the validator")
+	rate := flags.Int("rate", 1000, "transactions per second, 0 for unlimited")
+	count := flags.Int("count", 0, "transactions to send, 0 for unlimited")
+	duration := flags.Duration("duration", 10*time.Second, "how long to send for")
+	acks := flags.Duration("ack-timeout", 0, "how long to wait for acks after sending, 0 if acks are disabled")
+	flags.Parse(args)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	defer cancel()
+
+	stats, err := loadgen.Generate(ctx, *addr, loadgen.Options{Rate: *rate, Count: *count, AckTimeout: *acks})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Printf("sent %d transactions in %v (%.0f/s), %d failed\n", stats.Sent, stats.Elapsed, stats.Rate(), stats.Failed)
+	if *acks > 0 {
+		fmt.Printf("accepted %d, unacknowledged %d\n", stats.Accepted, stats.Unacknowledged())
+		for reason, n := range stats.Rejected {
+			fmt.Printf("rejected %d: %s\n", n, reason)
+		}
+	}
+
+	return 0
+}
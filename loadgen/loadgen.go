@@ -0,0 +1,194 @@
+// Package loadgen sends synthetic transactions to a validator over UDP,
+// to measure how much it keeps up with.
+package loadgen
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"os"
+	"sync"
+	"time"
+	"transactioner/models"
+
+	"go.uber.org/ratelimit"
+)
+
+// Options configure the generated load. Zero values are replaced by
+// defaults, see Generate.
+type Options struct {
+	// Transactions sent per second, unlimited if zero.
+	Rate int
+	// Transactions sent at most, unlimited if zero. Load is generated
+	// until either this many are sent or the context is done.
+	Count int
+	// Accounts balance is moved between, alice, bob and carol by default.
+	// They should exist in the validator's db.
+	Accounts []string
+	// Transfers are uniform in (0, MaxAmount], 1 by default.
+	MaxAmount float64
+	// Fee every transaction pays, 1 by default.
+	Fee float64
+	// How long to wait for acks after the last send, zero if acks aren't
+	// expected. The validator must have them enabled, see WithUDPAck.
+	AckTimeout time.Duration
+	// Seed of generated transactions, load is reproducible with the same seed.
+	Seed uint64
+}
+
+// Stats are what's recorded while generating load.
+type Stats struct {
+	Sent     uint64
+	Accepted uint64            // Acknowledged as accepted.
+	Rejected map[string]uint64 // Acknowledged as rejected, by drop reason.
+	Failed   uint64            // Couldn't be sent.
+	Elapsed  time.Duration     // Of sending, acks not included.
+}
+
+// Rate returns transactions sent per second.
+func (stats Stats) Rate() float64 {
+	if stats.Elapsed <= 0 {
+		return 0
+	}
+
+	return float64(stats.Sent) / stats.Elapsed.Seconds()
+}
+
+// Unacknowledged returns how many sent transactions got no ack.
+func (stats Stats) Unacknowledged() uint64 {
+	acked := stats.Accepted
+	for _, n := range stats.Rejected {
+		acked += n
+	}
+
+	return stats.Sent - min(acked, stats.Sent)
+}
+
+// ack is the part of the validator's UDP ack we're interested in.
+type ack struct {
+	Accepted bool   `json:"accepted"`
+	Reason   string `json:"reason"`
+}
+
+// Generate sends transactions to the validator's UDP address, e.g.
+// "localhost:2001", until opts.Count transactions are sent or ctx is done.
+// One of them must be set, otherwise it never returns.
+func Generate(ctx context.Context, addr string, opts Options) (Stats, error) {
+	if len(opts.Accounts) == 0 {
+		opts.Accounts = []string{"alice", "bob", "carol"}
+	}
+	if len(opts.Accounts) < 2 {
+		return Stats{}, errors.New("at least two accounts are needed")
+	}
+	if opts.MaxAmount <= 0 {
+		opts.MaxAmount = 1
+	}
+	if opts.Fee <= 0 {
+		opts.Fee = 1
+	}
+
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return Stats{}, err
+	}
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return Stats{}, err
+	}
+	defer conn.Close()
+
+	stats := Stats{Rejected: make(map[string]uint64)}
+	var statsMu sync.Mutex
+
+	// Count acks as they come, until the connection is closed.
+	var acks sync.WaitGroup
+	if opts.AckTimeout > 0 {
+		acks.Add(1)
+		go func() {
+			defer acks.Done()
+
+			buffer := make([]byte, 1024)
+			for {
+				n, err := conn.Read(buffer)
+				if err != nil {
+					if errors.Is(err, net.ErrClosed) || errors.Is(err, os.ErrDeadlineExceeded) {
+						return
+					}
+					continue
+				}
+
+				var response ack
+				if json.Unmarshal(buffer[:n], &response) != nil {
+					continue
+				}
+
+				statsMu.Lock()
+				if response.Accepted {
+					stats.Accepted++
+				} else {
+					stats.Rejected[response.Reason]++
+				}
+				statsMu.Unlock()
+			}
+		}()
+	}
+
+	limiter := ratelimit.NewUnlimited()
+	if opts.Rate > 0 {
+		limiter = ratelimit.New(opts.Rate)
+	}
+	random := rand.New(rand.NewPCG(opts.Seed, 0))
+
+	start := time.Now()
+	for opts.Count == 0 || stats.Sent+stats.Failed < uint64(opts.Count) {
+		if ctx.Err() != nil {
+			break
+		}
+		limiter.Take()
+
+		msg, err := json.Marshal(transaction(random, opts))
+		if err != nil {
+			return stats, err
+		}
+
+		_, err = conn.Write(msg)
+
+		statsMu.Lock()
+		if err != nil {
+			stats.Failed++
+		} else {
+			stats.Sent++
+		}
+		statsMu.Unlock()
+	}
+	elapsed := time.Since(start)
+
+	if opts.AckTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(opts.AckTimeout))
+		acks.Wait()
+	}
+
+	statsMu.Lock()
+	defer statsMu.Unlock()
+
+	stats.Elapsed = elapsed
+	return stats, nil
+}
+
+// transaction returns a random transfer between two distinct accounts,
+// paid by the sender.
+func transaction(random *rand.Rand, opts Options) *models.Transaction {
+	from := random.IntN(len(opts.Accounts))
+	to := (from + 1 + random.IntN(len(opts.Accounts)-1)) % len(opts.Accounts)
+	amount := opts.MaxAmount * (1 - random.Float64()) // In (0, MaxAmount].
+
+	return &models.Transaction{
+		Fee: models.Fee{Payer: opts.Accounts[from], Amount: opts.Fee},
+		Instructions: []models.Instruction{
+			{Account: opts.Accounts[from], Change: -amount},
+			{Account: opts.Accounts[to], Change: amount},
+		},
+	}
+}
@@ -0,0 +1,76 @@
+package loadgen
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+	"transactioner/validator"
+)
+
+// countingSink accepts every batch, counting transactions in them.
+type countingSink struct {
+	committed atomic.Uint64
+}
+
+func (sink *countingSink) Send(batch []*validator.Transaction) (int, error) {
+	sink.committed.Add(uint64(len(batch)))
+	return http.StatusOK, nil
+}
+
+// BenchmarkGenerate blasts transactions at an in-process validator as
+// fast as they can be sent, reporting how many per second are sent and
+// how many of those make it into a batch.
+func BenchmarkGenerate(b *testing.B) {
+	// The validator writes snapshots to the working directory.
+	b.Chdir(b.TempDir())
+
+	snapshot := filepath.Join(b.TempDir(), "accounts.json")
+	err := os.WriteFile(snapshot, []byte(`{"alice": 1e12, "bob": 1e12, "carol": 1e12}`), 0o644)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	sink := &countingSink{}
+	vali, err := validator.NewFromSnapshot(snapshot, validator.WithListenAddr("127.0.0.1:0"), validator.WithSink(sink))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		vali.RunContext(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	b.ResetTimer()
+	start := time.Now()
+	stats, err := Generate(context.Background(), vali.Addr().String(), Options{Count: b.N})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	// Wait for what was received to be committed. UDP may lose some on
+	// the way, so only until commits stop coming.
+	committed, elapsed := sink.committed.Load(), time.Since(start)
+	for committed < stats.Sent {
+		time.Sleep(100 * time.Millisecond)
+		if sink.committed.Load() == committed {
+			break
+		}
+		committed, elapsed = sink.committed.Load(), time.Since(start)
+	}
+	b.StopTimer()
+
+	b.ReportMetric(stats.Rate(), "sent/s")
+	b.ReportMetric(float64(committed)/elapsed.Seconds(), "committed/s")
+	b.ReportMetric(1-float64(committed)/float64(stats.Sent), "dropped")
+}
@@ -1,12 +1,127 @@
 package models
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Errors of Instruction.Validate.
+var (
+	ErrEmptyAccount          = errors.New("account is empty")
+	ErrNonFiniteChange       = errors.New("change is not a finite number")
+	ErrNoReferenceAccount    = errors.New("reference change has no account")
+	ErrNoSign                = errors.New("reference change has no sign")
+	ErrUnknownSign           = errors.New("reference change has unknown sign")
+	ErrUnknownReferenceField = errors.New("reference change has unknown field")
+	ErrUnknownChange         = errors.New("change is neither a number nor a reference change")
+)
+
+// Instruction changes the balance of an account.
+//
+// `Change` is either a number to add to the balance, or a reference
+// change object adding ("plus") or subtracting ("minus") the balance
+// of another account:
+//
+//	{"account": "bob", "sign": "minus"}
 type Instruction struct {
 	Account string `json:"account"`
 	Change  any    `json:"change"`
 }
 
-// IsChangeFloat64 returns true if `Change` is float64.
+// IsChangeFloat64 returns true if `Change` is a number,
+// either decoded as float64 or as `json.Number`.
 func (instruction *Instruction) IsChangeFloat64() bool {
-	_, ok := instruction.Change.(float64)
-	return ok
+	switch instruction.Change.(type) {
+	case float64, json.Number:
+		return true
+	default:
+		return false
+	}
+}
+
+// Validate checks the instruction is well-formed: it names an account
+// and its change is either a finite number or a reference change with
+// a known sign. It doesn't look at balances.
+func (instruction *Instruction) Validate() error {
+	if instruction.Account == "" {
+		return ErrEmptyAccount
+	}
+
+	switch change := instruction.Change.(type) {
+	case float64:
+		if !isFinite(change) {
+			return ErrNonFiniteChange
+		}
+	case json.Number:
+		// Over-range numbers are parsed as infinity
+		// along with an error, either way it's invalid.
+		f, err := change.Float64()
+		if err != nil || !isFinite(f) {
+			return ErrNonFiniteChange
+		}
+	case map[string]any:
+		account, ok := change["account"].(string)
+		if !ok || account == "" {
+			return ErrNoReferenceAccount
+		}
+
+		sign, ok := change["sign"].(string)
+		if !ok {
+			return ErrNoSign
+		}
+
+		if sign != "plus" && sign != "minus" {
+			return fmt.Errorf("%w: %s", ErrUnknownSign, sign)
+		}
+
+		// They'd be left out of the content hash, see Transaction.Hash.
+		for key := range change {
+			if key != "account" && key != "sign" {
+				return fmt.Errorf("%w: %s", ErrUnknownReferenceField, key)
+			}
+		}
+	default:
+		return ErrUnknownChange
+	}
+
+	return nil
+}
+
+// canonical returns the instruction with its change in the form it's
+// hashed in: numbers as their shortest decimal, so that 1, 1.0 and 1e0
+// are the same, and reference changes with their known fields only.
+func (instruction Instruction) canonical() Instruction {
+	switch change := instruction.Change.(type) {
+	case float64:
+		if isFinite(change) {
+			instruction.Change = canonicalNumber(change)
+		}
+	case json.Number:
+		f, err := change.Float64()
+		if err == nil && isFinite(f) {
+			instruction.Change = canonicalNumber(f)
+		}
+	case map[string]any:
+		reference := make(map[string]any, 2)
+		for _, key := range []string{"account", "sign"} {
+			if value, ok := change[key]; ok {
+				reference[key] = value
+			}
+		}
+		instruction.Change = reference
+	}
+
+	return instruction
+}
+
+// canonicalNumber returns the shortest decimal of f, without exponent.
+func canonicalNumber(f float64) json.Number {
+	// Negative zero is the same amount as zero.
+	if f == 0 {
+		f = 0
+	}
+
+	return json.Number(strconv.FormatFloat(f, 'f', -1, 64))
 }
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestInstructionValidate(t *testing.T) {
+	tests := []struct {
+		name        string
+		instruction Instruction
+		want        error
+	}{
+		{"float", Instruction{"alice", -1.5}, nil},
+		{"zero", Instruction{"alice", 0.0}, nil},
+		{"number", Instruction{"alice", json.Number("10")}, nil},
+		{"plus", Instruction{"alice", map[string]any{"account": "bob", "sign": "plus"}}, nil},
+		{"minus", Instruction{"alice", map[string]any{"account": "bob", "sign": "minus"}}, nil},
+
+		{"empty account", Instruction{"", 1.0}, ErrEmptyAccount},
+		{"infinity", Instruction{"alice", math.Inf(1)}, ErrNonFiniteChange},
+		{"NaN", Instruction{"alice", math.NaN()}, ErrNonFiniteChange},
+		{"over-range number", Instruction{"alice", json.Number("-1e400")}, ErrNonFiniteChange},
+		{"malformed number", Instruction{"alice", json.Number("one")}, ErrNonFiniteChange},
+		{"no reference account", Instruction{"alice", map[string]any{"sign": "plus"}}, ErrNoReferenceAccount},
+		{"empty reference account", Instruction{"alice", map[string]any{"account": "", "sign": "plus"}}, ErrNoReferenceAccount},
+		{"reference account not a string", Instruction{"alice", map[string]any{"account": 1.0, "sign": "plus"}}, ErrNoReferenceAccount},
+		{"no sign", Instruction{"alice", map[string]any{"account": "bob"}}, ErrNoSign},
+		{"sign not a string", Instruction{"alice", map[string]any{"account": "bob", "sign": true}}, ErrNoSign},
+		{"unknown sign", Instruction{"alice", map[string]any{"account": "bob", "sign": "times"}}, ErrUnknownSign},
+		{"unknown reference field", Instruction{"alice", map[string]any{"account": "bob", "sign": "plus", "scale": 2.0}}, ErrUnknownReferenceField},
+		{"string", Instruction{"alice", "10"}, ErrUnknownChange},
+		{"null", Instruction{"alice", nil}, ErrUnknownChange},
+		{"array", Instruction{"alice", []any{1.0}}, ErrUnknownChange},
+	}
+	for _, test := range tests {
+		err := test.instruction.Validate()
+		if !errors.Is(err, test.want) {
+			t.Errorf("%s: got error %v, want %v", test.name, err, test.want)
+		}
+	}
+}
+
+func TestInstructionValidateDecoded(t *testing.T) {
+	// Shapes as they're decoded from JSON.
+	tests := map[string]error{
+		`{"account": "alice", "change": 5}`:                                     nil,
+		`{"account": "alice", "change": {"account": "bob", "sign": "minus"}}`:   nil,
+		`{"account": "alice", "change": "5"}`:                                   ErrUnknownChange,
+		`{"account": "alice"}`:                                                  ErrUnknownChange,
+		`{"change": 5}`:                                                         ErrEmptyAccount,
+		`{"account": "alice", "change": {"account": "bob", "sign": "divided"}}`: ErrUnknownSign,
+	}
+	for msg, want := range tests {
+		var instruction Instruction
+		err := json.Unmarshal([]byte(msg), &instruction)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = instruction.Validate()
+		if !errors.Is(err, want) {
+			t.Errorf("%s: got error %v, want %v", msg, err, want)
+		}
+	}
+}
@@ -1,11 +1,97 @@
 package models
 
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+)
+
 type Fee struct {
 	Payer  string  `json:"payer"`
 	Amount float64 `json:"amount"`
 }
 
 type Transaction struct {
+	ID           string        `json:"id,omitempty"`   // Hex encoded content hash as received, see ComputeID.
+	Type         string        `json:"type,omitempty"` // Optional, e.g. "transfer".
 	Fee          Fee           `json:"fee"`
 	Instructions []Instruction `json:"instructions"`
+	Priority     bool          `json:"priority,omitempty"` // Asks to be batched first, only honored from trusted senders.
+	TraceID      string        `json:"traceId,omitempty"`  // W3C traceparent of the sender's span, not part of the content.
+}
+
+// Hash returns the SHA-256 of transaction's canonical JSON encoding.
+// Transactions with the same content have the same hash, regardless of
+// field or key order they were originally received in, or how their
+// numbers are written: 1, 1.0 and 1e0 are the same change. The ID and
+// the trace ID are not part of the content.
+func (transaction *Transaction) Hash() [32]byte {
+	content := *transaction
+	content.ID = ""
+	content.TraceID = ""
+	content.Instructions = make([]Instruction, len(transaction.Instructions))
+	for i, instruction := range transaction.Instructions {
+		content.Instructions[i] = instruction.canonical()
+	}
+
+	// Struct fields are encoded in declaration order and map keys
+	// are sorted, which makes the output canonical.
+	buffer, err := json.Marshal(&content)
+	if err != nil {
+		// Only fails for values that can't come from JSON (e.g. channels).
+		panic(err)
+	}
+
+	return sha256.Sum256(buffer)
+}
+
+// ComputeID returns the ID of the transaction, which is
+// the hex encoded hash of its content.
+func (transaction *Transaction) ComputeID() string {
+	hash := transaction.Hash()
+	return hex.EncodeToString(hash[:])
+}
+
+// Errors of Transaction.Validate, other than the ones of
+// Instruction.Validate.
+var (
+	ErrNonFiniteFee   = errors.New("fee amount is not a finite number")
+	ErrNegativeFee    = errors.New("fee amount is negative")
+	ErrNoInstructions = errors.New("transaction has no instructions")
+)
+
+// Validate checks whether the transaction carries values that can't
+// be executed safely. It doesn't look at balances.
+func (transaction *Transaction) Validate() error {
+	if !isFinite(transaction.Fee.Amount) {
+		return ErrNonFiniteFee
+	}
+
+	// A negative fee would move balance from validator to payer.
+	if transaction.Fee.Amount < 0 {
+		return ErrNegativeFee
+	}
+
+	// A transaction without instructions would only pay its fee,
+	// yet score higher than any transaction doing actual work.
+	if len(transaction.Instructions) == 0 {
+		return ErrNoInstructions
+	}
+
+	for i := range transaction.Instructions {
+		err := transaction.Instructions[i].Validate()
+		if err != nil {
+			return fmt.Errorf("instruction %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// isFinite returns true if f is neither NaN nor infinity.
+func isFinite(f float64) bool {
+	return !math.IsNaN(f) && !math.IsInf(f, 0)
 }
@@ -0,0 +1,129 @@
+package models
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestValidateNonFinite(t *testing.T) {
+	valid := func() Transaction {
+		return Transaction{
+			Fee: Fee{Payer: "alice", Amount: 1},
+			Instructions: []Instruction{
+				{Account: "alice", Change: -1.0},
+				{Account: "bob", Change: json.Number("1")},
+			},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(tx *Transaction)
+		want   error
+	}{
+		{"valid", func(tx *Transaction) {}, nil},
+		{"infinite fee", func(tx *Transaction) { tx.Fee.Amount = math.Inf(1) }, ErrNonFiniteFee},
+		{"NaN fee", func(tx *Transaction) { tx.Fee.Amount = math.NaN() }, ErrNonFiniteFee},
+		{"infinite change", func(tx *Transaction) { tx.Instructions[0].Change = math.Inf(-1) }, ErrNonFiniteChange},
+		{"NaN change", func(tx *Transaction) { tx.Instructions[0].Change = math.NaN() }, ErrNonFiniteChange},
+		{"over-range change", func(tx *Transaction) { tx.Instructions[1].Change = json.Number("1e400") }, ErrNonFiniteChange},
+	}
+	for _, test := range tests {
+		tx := valid()
+		test.modify(&tx)
+
+		err := tx.Validate()
+		if !errors.Is(err, test.want) {
+			t.Errorf("%s: got error %v, want %v", test.name, err, test.want)
+		}
+	}
+}
+
+func TestValidateNoInstructions(t *testing.T) {
+	for _, instructions := range [][]Instruction{nil, {}} {
+		tx := Transaction{Fee: Fee{Payer: "alice", Amount: 100}, Instructions: instructions}
+		if err := tx.Validate(); !errors.Is(err, ErrNoInstructions) {
+			t.Errorf("%#v: got error %v, want %v", instructions, err, ErrNoInstructions)
+		}
+	}
+}
+
+func TestTransactionID(t *testing.T) {
+	// Same content, different field and key order, ID and trace ID.
+	a := `{"fee": {"payer": "alice", "amount": 1}, "instructions": [{"account": "alice", "change": -5}, {"account": "bob", "change": {"account": "carol", "sign": "plus"}}]}`
+	b := `{"traceId": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "instructions": [{"change": -5, "account": "alice"}, {"change": {"sign": "plus", "account": "carol"}, "account": "bob"}], "fee": {"amount": 1, "payer": "alice"}, "id": "whatever"}`
+
+	var txA, txB Transaction
+	if err := json.Unmarshal([]byte(a), &txA); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(b), &txB); err != nil {
+		t.Fatal(err)
+	}
+
+	id := txA.ComputeID()
+	hash := txA.Hash()
+	if id != hex.EncodeToString(hash[:]) {
+		t.Errorf("ID %s isn't the content hash", id)
+	}
+	if other := txB.ComputeID(); other != id {
+		t.Errorf("same content has IDs %s and %s", id, other)
+	}
+
+	// Stable across serialization.
+	txA.ID = id
+	buffer, err := json.Marshal(&txA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Transaction
+	if err := json.Unmarshal(buffer, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.ID != id || decoded.ComputeID() != id {
+		t.Errorf("ID changed once serialized: %s, computed %s, want %s", decoded.ID, decoded.ComputeID(), id)
+	}
+
+	// Content changes, so does the ID.
+	txA.Fee.Amount = 2
+	if txA.ComputeID() == id {
+		t.Error("different content has the same ID")
+	}
+}
+
+func TestTransactionIDCanonicalNumbers(t *testing.T) {
+	// The same amounts, written differently.
+	msgs := []string{
+		`{"fee": {"payer": "alice", "amount": 1}, "instructions": [{"account": "alice", "change": -1}, {"account": "bob", "change": 1}]}`,
+		`{"fee": {"payer": "alice", "amount": 1.0}, "instructions": [{"account": "alice", "change": -1.0}, {"account": "bob", "change": 1.0}]}`,
+		`{"fee": {"payer": "alice", "amount": 1e0}, "instructions": [{"account": "alice", "change": -10e-1}, {"account": "bob", "change": 0.1e1}]}`,
+	}
+
+	var id string
+	for _, msg := range msgs {
+		// Decoded the way the validator does, numbers kept as written.
+		decoder := json.NewDecoder(strings.NewReader(msg))
+		decoder.UseNumber()
+		var tx Transaction
+		if err := decoder.Decode(&tx); err != nil {
+			t.Fatal(err)
+		}
+
+		if id == "" {
+			id = tx.ComputeID()
+		} else if other := tx.ComputeID(); other != id {
+			t.Errorf("%s: ID %s, want %s", msg, other, id)
+		}
+
+		// Decoded as floats, they're still the same.
+		tx.Instructions[0].Change = -1.0
+		tx.Instructions[1].Change = 1.0
+		if other := tx.ComputeID(); other != id {
+			t.Errorf("%s: ID %s with float changes, want %s", msg, other, id)
+		}
+	}
+}
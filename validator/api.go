@@ -0,0 +1,199 @@
+package validator
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+)
+
+// Handler returns the HTTP handler serving the query API.
+//
+//	GET  /health          whether the validator is ok or degraded
+//	GET  /stats           statistics about accounts
+//	GET  /stats.json      every metric as JSON
+//	GET  /metrics         every metric in Prometheus text format
+//	GET  /batches/recent  recently committed batches, newest first
+//	GET  /tx/{id}         whether a transaction is committed, and in which batch
+//	POST /submit          submit a transaction, or an array of them
+func (vali *Validator) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", vali.handleHealth)
+	mux.HandleFunc("GET /stats", vali.handleStats)
+	mux.HandleFunc("GET /stats.json", vali.handleStatsJSON)
+	mux.HandleFunc("GET /metrics", vali.handleMetrics)
+	mux.HandleFunc("GET /batches/recent", vali.handleRecentBatches)
+	mux.HandleFunc("GET /tx/{id}", vali.handleTransaction)
+	mux.HandleFunc("POST /submit", vali.handleSubmit)
+
+	return mux
+}
+
+// ServeQueries serves the query API over given address,
+// until the validator is closed.
+func (vali *Validator) ServeQueries(addr string) {
+	defer vali.wg.Done()
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Printf("query API stopped: %v", err)
+		return
+	}
+	if vali.maxConnections > 0 {
+		ln = &limitListener{Listener: ln, max: int64(vali.maxConnections)}
+	}
+
+	server := &http.Server{Handler: vali.Handler()}
+	go func() {
+		<-vali.done
+		server.Close()
+	}()
+
+	err = server.Serve(ln)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("query API stopped: %v", err)
+	}
+}
+
+type healthResponse struct {
+	Status string `json:"status"` // Either "ok" or "degraded".
+}
+
+// handleHealth tells whether the validator is degraded. Degraded validators
+// still accept transactions, so the status code is 200 either way.
+func (vali *Validator) handleHealth(w http.ResponseWriter, r *http.Request) {
+	status := "ok"
+	if vali.Degraded() {
+		status = "degraded"
+	}
+
+	writeJSON(w, http.StatusOK, healthResponse{Status: status})
+}
+
+type statsResponse struct {
+	Accounts       int     `json:"accounts"` // Excluding the validator account.
+	Paused         bool    `json:"paused"`
+	AcceptanceRate float64 `json:"acceptanceRate"` // See AcceptanceRate.
+}
+
+func (vali *Validator) handleStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, statsResponse{
+		Accounts:       vali.db.AccountCount(false),
+		Paused:         vali.Paused(),
+		AcceptanceRate: vali.AcceptanceRate(),
+	})
+}
+
+// Most transactions a single submission can carry.
+const maxSubmitTransactions = 64
+
+// submitResponse tells a submitter whether its transaction is accepted.
+// Rejections carry a code from the ErrorCode constants along with a
+// human readable message.
+type submitResponse struct {
+	Accepted bool       `json:"accepted"`
+	ID       string     `json:"id,omitempty"`
+	Code     ErrorCode  `json:"code,omitempty"`   // Set if not accepted.
+	Reason   DropReason `json:"reason,omitempty"` // Set if not accepted.
+	Error    string     `json:"error,omitempty"`  // Set if not accepted.
+}
+
+// handleSubmit accepts transactions over HTTP, for clients that can't
+// use UDP. Transactions go through the same checks as the ones received
+// over UDP, and are turned down right away if their payer can't pay the
+// fee. A single transaction gets a single response, an array gets
+// an array of responses in the same order.
+func (vali *Validator) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSubmitTransactions*maxMessageSize))
+	if err != nil {
+		writeJSON(w, http.StatusRequestEntityTooLarge, submitResponse{Code: CodeMessageTooLarge, Reason: ReasonMalformed, Error: err.Error()})
+		return
+	}
+
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || body[0] != '[' {
+		response := vali.submit(r, body)
+		status := http.StatusOK
+		if !response.Accepted {
+			status = http.StatusBadRequest
+		}
+
+		writeJSON(w, status, response)
+		return
+	}
+
+	var messages []json.RawMessage
+	err = json.Unmarshal(body, &messages)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, submitResponse{Code: CodeInvalidJSON, Reason: ReasonMalformed, Error: err.Error()})
+		return
+	}
+
+	if len(messages) > maxSubmitTransactions {
+		writeJSON(w, http.StatusBadRequest, submitResponse{
+			Code:   CodeMessageTooLarge,
+			Reason: ReasonMalformed,
+			Error:  fmt.Sprintf("at most %d transactions can be submitted at once", maxSubmitTransactions),
+		})
+		return
+	}
+
+	responses := make([]submitResponse, 0, len(messages))
+	for _, msg := range messages {
+		responses = append(responses, vali.submit(r, msg))
+	}
+
+	writeJSON(w, http.StatusOK, responses)
+}
+
+// submit decodes a single submitted transaction and passes it to the
+// processor, unless its payer can't pay the fee.
+func (vali *Validator) submit(r *http.Request, msg []byte) submitResponse {
+	tx, err := vali.decodeTransaction(msg)
+	if err != nil {
+		vali.rejectDecoding(msg, err)
+		return rejectionResponse(err)
+	}
+
+	// Checked again once batched, the balance may change meanwhile.
+	if !vali.canPayFee(vali.db, tx) {
+		err := errors.New("payer can't pay the fee")
+		vali.drop(tx, ReasonFeeCheck, err)
+		return submitResponse{Code: CodeInsufficientBalance, Reason: ReasonFeeCheck, ID: tx.ID, Error: err.Error()}
+	}
+
+	select {
+	case vali.txCh <- tx:
+		return submitResponse{Accepted: true, ID: tx.ID}
+	case <-vali.done:
+		return submitResponse{Code: CodeUnavailable, Error: "validator is closed"}
+	case <-r.Context().Done():
+		return submitResponse{Code: CodeUnavailable, Error: r.Context().Err().Error()}
+	}
+}
+
+// rejectionResponse explains why decoding a transaction has failed.
+func rejectionResponse(err error) submitResponse {
+	reason := ReasonMalformed
+	var rejected *rejectError
+	if errors.As(err, &rejected) {
+		reason = rejected.reason
+	}
+
+	return submitResponse{Code: errorCode(reason, err), Reason: reason, Error: err.Error()}
+}
+
+// writeJSON writes v as the JSON response body with given status.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Printf("failed to write response: %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+package validator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// get requests path from the query API of vali, decoding the response
+// into v. Returns the status code.
+func get(t testing.TB, vali *Validator, path string, v any) int {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	vali.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	if v != nil {
+		err := json.Unmarshal(recorder.Body.Bytes(), v)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+	}
+
+	return recorder.Code
+}
+
+func TestStats(t *testing.T) {
+	vali := newTestValidator(t, map[string]float64{"alice": 100, "bob": 0})
+
+	var stats statsResponse
+	if status := get(t, vali, "/stats", &stats); status != http.StatusOK {
+		t.Fatalf("got status %d", status)
+	}
+	if stats.Accounts != 2 {
+		t.Errorf("got %d accounts, want 2", stats.Accounts)
+	}
+
+	// Carol is created by the transfer.
+	receive(t, vali, transfer("alice", "carol", 10, 1))
+	processAll(t, vali)
+	get(t, vali, "/stats", &stats)
+	if stats.Accounts != 3 {
+		t.Errorf("got %d accounts once carol's created, want 3", stats.Accounts)
+	}
+
+	err := vali.db.Delete("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	get(t, vali, "/stats", &stats)
+	if stats.Accounts != 2 {
+		t.Errorf("got %d accounts once bob's deleted, want 2", stats.Accounts)
+	}
+}
+
+// post sends body to path of the query API of vali, decoding the
+// response into v. Returns the status code.
+func post(t testing.TB, vali *Validator, path, body string, v any) int {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	vali.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+	err := json.Unmarshal(recorder.Body.Bytes(), v)
+	if err != nil {
+		t.Fatalf("POST %s: %v", path, err)
+	}
+
+	return recorder.Code
+}
+
+func TestSubmit(t *testing.T) {
+	vali := newTestValidator(t, map[string]float64{"alice": 100, "bob": 0}, WithSink(&recordingSink{}))
+
+	valid := `{"fee": {"payer": "alice", "amount": 1}, "instructions": [{"account": "alice", "change": -10}, {"account": "bob", "change": 10}]}`
+	var response submitResponse
+	if status := post(t, vali, "/submit", valid, &response); status != http.StatusOK {
+		t.Errorf("got status %d for a valid transaction", status)
+	}
+	if !response.Accepted || response.ID == "" {
+		t.Errorf("got %+v for a valid transaction", response)
+	}
+
+	tests := []struct {
+		body string
+		code ErrorCode
+	}{
+		{`{"fee": `, CodeInvalidJSON},
+		{`{"fee": {"payer": "alice", "amount": -1}, "instructions": [{"account": "bob", "change": 1}]}`, CodeNegativeFee},
+		{`{"fee": {"payer": "alice", "amount": 1}, "instructions": []}`, CodeNoInstructions},
+	}
+	for _, test := range tests {
+		var response submitResponse
+		if status := post(t, vali, "/submit", test.body, &response); status != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want %d", test.body, status, http.StatusBadRequest)
+		}
+		if response.Accepted || response.Code != test.code || response.Error == "" {
+			t.Errorf("%s: got %+v, want code %s", test.body, response, test.code)
+		}
+	}
+
+	// Arrays are answered one by one, in order.
+	var responses []submitResponse
+	status := post(t, vali, "/submit", "["+valid+`, {"fee": 1}, `+strings.Replace(valid, "10", "20", 2)+"]", &responses)
+	if status != http.StatusOK {
+		t.Errorf("got status %d for an array", status)
+	}
+	if len(responses) != 3 || !responses[0].Accepted || responses[1].Accepted || !responses[2].Accepted {
+		t.Errorf("got %+v for an array, want accepted, rejected, accepted", responses)
+	}
+
+	// Accepted ones are processed as any received over UDP.
+	batch, err := vali.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batch) != 2 {
+		t.Errorf("committed %d transaction(s), want 2", len(batch))
+	}
+	if balance, _ := vali.db.GetBalance("bob"); balance != 30 {
+		t.Errorf("bob has %v, want 30", balance)
+	}
+}
+
+func TestHealthDegraded(t *testing.T) {
+	const n = 100
+	// Room for about half of the transactions.
+	vali := newTestValidator(t, map[string]float64{"alice": 1000}, WithSink(&recordingSink{}),
+		WithHeapSoftLimit(n/2*(pendingOverhead+len(encode(t, transfer("alice", "bob", 1, 1))))))
+
+	var health healthResponse
+	if code := get(t, vali, "/health", &health); code != http.StatusOK || health.Status != "ok" {
+		t.Fatalf("got %d %+v before any transaction, want ok", code, health)
+	}
+
+	for i := range n {
+		receive(t, vali, transfer("alice", "bob", float64(i+1), 1))
+	}
+	if !vali.Degraded() {
+		t.Fatal("not degraded above the soft limit")
+	}
+	if code := get(t, vali, "/health", &health); code != http.StatusOK || health.Status != "degraded" {
+		t.Errorf("got %d %+v, want degraded", code, health)
+	}
+	// Nothing is rejected for it.
+	if pending := vali.PendingCount(); pending != n {
+		t.Errorf("%d pending, want %d", pending, n)
+	}
+	if metrics := vali.gauges(); metrics["validator_heap_bytes_estimate"] <= 0 || metrics["validator_degraded"] != 1 {
+		t.Errorf("got heap estimate %v, degraded %v", metrics["validator_heap_bytes_estimate"], metrics["validator_degraded"])
+	}
+
+	processAll(t, vali)
+	if vali.Degraded() {
+		t.Error("still degraded with nothing pending")
+	}
+}
@@ -0,0 +1,92 @@
+package validator
+
+import (
+	"errors"
+	"time"
+)
+
+// Batches sent per second at most.
+const sendRate = 100
+
+// heldBatches are committed batches held back while sending is rate
+// limited, to be sent merged. See WithBatchCoalescing.
+type heldBatches struct {
+	batch       []*Transaction
+	deltas      Deltas              // Net changes of batch.
+	ids         map[string]struct{} // Of transactions in batch.
+	first, last uint64              // Indexes of merged batches.
+}
+
+// overlaps returns true if any transaction of the batch is already held.
+// Downstream must never receive the same transaction twice in a batch,
+// such batches can't be merged.
+func (held *heldBatches) overlaps(batch []*Transaction) bool {
+	for _, tx := range batch {
+		if _, ok := held.ids[tx.key()]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (held *heldBatches) add(batch []*Transaction, deltas Deltas, batchIdx uint64) {
+	if len(held.batch) == 0 {
+		held.first = batchIdx
+		held.deltas = make(Deltas)
+		held.ids = make(map[string]struct{})
+	}
+	held.last = batchIdx
+
+	held.batch = append(held.batch, batch...)
+	held.deltas.merge(deltas)
+	for _, tx := range batch {
+		held.ids[tx.key()] = struct{}{}
+	}
+}
+
+// sendLimited returns true if sending now would likely have to wait
+// for the rate limit.
+func (vali *Validator) sendLimited() bool {
+	last := time.Unix(0, vali.lastSend.Load())
+	return vali.clock.Since(last) < time.Second/sendRate
+}
+
+// coalesceBatch holds a committed batch back while sending is rate
+// limited, merging it with the ones already held. Merged batches are sent
+// once they'd exceed the batch size, or sending isn't limited anymore.
+// Must be called with processMu held.
+func (vali *Validator) coalesceBatch(batch []*Transaction, deltas Deltas, batchIdx uint64) error {
+	var err error
+	if len(vali.held.batch)+len(batch) > vali.batchSize || vali.held.overlaps(batch) {
+		err = vali.sendHeld()
+	}
+
+	vali.held.add(batch, deltas, batchIdx)
+	if len(vali.held.batch) >= vali.batchSize || !vali.sendLimited() {
+		err = errors.Join(err, vali.sendHeld())
+	}
+
+	return err
+}
+
+// sendHeld sends the held batches merged, if any.
+// Must be called with processMu held.
+func (vali *Validator) sendHeld() error {
+	if len(vali.held.batch) == 0 {
+		return nil
+	}
+
+	held := vali.held
+	vali.held = heldBatches{}
+
+	return vali.sendBatch(held.batch, held.deltas.compact(), held.first, held.last)
+}
+
+// flushHeld is sendHeld for callers not holding processMu.
+func (vali *Validator) flushHeld() error {
+	vali.processMu.Lock()
+	defer vali.processMu.Unlock()
+
+	return vali.sendHeld()
+}
@@ -0,0 +1,128 @@
+package validator
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/benbjohnson/clock"
+)
+
+func TestBatchCoalescing(t *testing.T) {
+	const batches = 10
+
+	// The mock clock never moves, so every send is within the rate limit
+	// interval of the last one. The limiter itself doesn't wait at the
+	// zero time, sends merely count as limited.
+	sends := make(map[bool]int)
+	for _, coalesce := range []bool{false, true} {
+		sink := &recordingSink{}
+		vali := newTestValidator(t, map[string]float64{"alice": 100}, WithBatchSize(4), WithSink(sink),
+			WithClock(clock.NewMock()), WithBatchCoalescing(coalesce))
+
+		// Batches of one, as if transactions trickle in.
+		for i := range batches {
+			vali.PushTransaction(transfer("alice", "bob", float64(i+1), 1))
+			batch, _, err := vali.processBatch()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(batch) != 1 {
+				t.Fatalf("committed a batch of %d, want 1", len(batch))
+			}
+		}
+		err := vali.flushHeld()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sent := sink.sent()
+		sends[coalesce] = len(sent)
+		total := 0
+		for _, batch := range sent {
+			if len(batch) > 4 {
+				t.Errorf("sent %d transactions at once, above the batch size", len(batch))
+			}
+			total += len(batch)
+		}
+		if total != batches {
+			t.Errorf("coalesce=%v: sent %d transaction(s), want %d", coalesce, total, batches)
+		}
+
+		// Merged or not, each transaction keeps the index of its own batch.
+		var txs []*Transaction
+		for _, batch := range sent {
+			txs = append(txs, batch...)
+		}
+		for i, tx := range txs {
+			if idx, ok := vali.WasCommitted(tx.ID); !ok || idx != uint64(i) {
+				t.Errorf("transaction %d is committed in batch %d, want %d", i, idx, i)
+			}
+		}
+		if balance, _ := vali.db.GetBalance("bob"); balance != 55 {
+			t.Errorf("bob has %v, want 55", balance)
+		}
+	}
+
+	if sends[false] != batches {
+		t.Errorf("sent %d batch(es) without coalescing, want %d", sends[false], batches)
+	}
+	// 4, 4 and the 2 left over.
+	if sends[true] != 3 {
+		t.Errorf("sent %d batch(es) coalescing, want 3", sends[true])
+	}
+}
+
+func TestCoalescedBatchRange(t *testing.T) {
+	var mu sync.Mutex
+	var httpRanges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		httpRanges = append(httpRanges, r.Header.Get("X-Batch-Range"))
+	}))
+	defer server.Close()
+
+	c := &collector{}
+	sinks := map[string]struct {
+		option Option
+		ranges func() []string
+	}{
+		"http": {WithBatchEndpoint(server.URL), func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return httpRanges
+		}},
+		"grpc": {WithSink(newBufconnSink(t, c)), func() []string {
+			var ranges []string
+			for _, batch := range c.batches {
+				ranges = append(ranges, fmt.Sprintf("%d-%d", batch.Range.GetFirst(), batch.Range.GetLast()))
+			}
+			return ranges
+		}},
+	}
+
+	for name, sink := range sinks {
+		vali := newTestValidator(t, map[string]float64{"alice": 100}, WithBatchSize(4), sink.option,
+			WithClock(clock.NewMock()), WithBatchCoalescing(true))
+
+		for i := range 10 {
+			vali.PushTransaction(transfer("alice", "bob", float64(i+1), 1))
+			_, _, err := vali.processBatch()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := vali.flushHeld()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := sink.ranges(), []string{"0-3", "4-7", "8-9"}; !slices.Equal(got, want) {
+			t.Errorf("%s: sent ranges %v, want %v", name, got, want)
+		}
+	}
+}
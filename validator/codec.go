@@ -0,0 +1,216 @@
+package validator
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec encodes what's sent to the sink, see WithBatchCodec.
+// Receiving ends can decode it by the same codec.
+type Codec interface {
+	// ContentType is the media type of encoded values.
+	ContentType() string
+	Marshal(v any) ([]byte, error)
+	// Unmarshal decodes data into the value v points to.
+	Unmarshal(data []byte, v any) error
+}
+
+// JSONCodec encodes values as JSON. It's the default codec.
+type JSONCodec struct{}
+
+func (JSONCodec) ContentType() string {
+	return "application/json"
+}
+
+func (JSONCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// MsgpackCodec encodes values as MessagePack, which is more compact and
+// cheaper to parse than JSON. Structs are encoded as maps keyed by their
+// JSON field names, maps have their keys sorted, and byte slices are
+// encoded as binary rather than base64 strings.
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) ContentType() string {
+	return "application/msgpack"
+}
+
+func (MsgpackCodec) Marshal(v any) ([]byte, error) {
+	// Received instruction changes are json.Number's, encode them as the
+	// floats they stand for rather than strings.
+	value, changed, err := floatNumbers(reflect.ValueOf(&v).Elem())
+	if err != nil {
+		return nil, err
+	}
+	if changed {
+		v = value.Interface()
+	}
+
+	var buffer bytes.Buffer
+	encoder := msgpack.NewEncoder(&buffer)
+	encoder.SetCustomStructTag("json")
+	encoder.SetSortMapKeys(true)
+
+	err = encoder.Encode(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func (MsgpackCodec) Unmarshal(data []byte, v any) error {
+	reader := bytes.NewReader(data)
+	decoder := msgpack.NewDecoder(reader)
+	decoder.SetCustomStructTag("json")
+
+	err := decoder.Decode(v)
+	if err != nil {
+		return err
+	}
+	if reader.Len() > 0 {
+		return errors.New("msgpack: unexpected data after value")
+	}
+
+	return nil
+}
+
+// floatNumbers returns v with every json.Number held in an interface
+// replaced by the float64 it stands for, and true if there was any.
+// Whatever holds one is copied, v itself is never modified. Unexported
+// fields are left as they are.
+func floatNumbers(v reflect.Value) (reflect.Value, bool, error) {
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return v, false, nil
+		}
+
+		elem := v.Elem()
+		if number, ok := elem.Interface().(json.Number); ok {
+			amount, err := number.Float64()
+			if err != nil {
+				return v, false, err
+			}
+			elem = reflect.ValueOf(amount)
+		} else {
+			var changed bool
+			var err error
+			elem, changed, err = floatNumbers(elem)
+			if !changed || err != nil {
+				return v, false, err
+			}
+		}
+
+		out := reflect.New(v.Type()).Elem()
+		out.Set(elem)
+		return out, true, nil
+
+	case reflect.Pointer:
+		if v.IsNil() {
+			return v, false, nil
+		}
+
+		elem, changed, err := floatNumbers(v.Elem())
+		if !changed || err != nil {
+			return v, false, err
+		}
+
+		out := reflect.New(v.Type().Elem())
+		out.Elem().Set(elem)
+		return out, true, nil
+
+	case reflect.Struct:
+		var out reflect.Value
+		for i := range v.NumField() {
+			if !v.Type().Field(i).IsExported() {
+				continue
+			}
+
+			field, changed, err := floatNumbers(v.Field(i))
+			if err != nil {
+				return v, false, err
+			}
+			if !changed {
+				continue
+			}
+
+			if !out.IsValid() {
+				out = reflect.New(v.Type()).Elem()
+				out.Set(v)
+			}
+			out.Field(i).Set(field)
+		}
+
+		if !out.IsValid() {
+			return v, false, nil
+		}
+		return out, true, nil
+
+	case reflect.Slice, reflect.Array:
+		var out reflect.Value
+		for i := range v.Len() {
+			elem, changed, err := floatNumbers(v.Index(i))
+			if err != nil {
+				return v, false, err
+			}
+			if !changed {
+				continue
+			}
+
+			if !out.IsValid() {
+				if v.Kind() == reflect.Slice {
+					out = reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+				} else {
+					out = reflect.New(v.Type()).Elem()
+				}
+				reflect.Copy(out, v)
+			}
+			out.Index(i).Set(elem)
+		}
+
+		if !out.IsValid() {
+			return v, false, nil
+		}
+		return out, true, nil
+
+	case reflect.Map:
+		var out reflect.Value
+		iter := v.MapRange()
+		for iter.Next() {
+			elem, changed, err := floatNumbers(iter.Value())
+			if err != nil {
+				return v, false, err
+			}
+			if !changed {
+				continue
+			}
+
+			if !out.IsValid() {
+				out = reflect.MakeMapWithSize(v.Type(), v.Len())
+				copy := v.MapRange()
+				for copy.Next() {
+					out.SetMapIndex(copy.Key(), copy.Value())
+				}
+			}
+			out.SetMapIndex(iter.Key(), elem)
+		}
+
+		if !out.IsValid() {
+			return v, false, nil
+		}
+		return out, true, nil
+
+	default:
+		return v, false, nil
+	}
+}
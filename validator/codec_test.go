@@ -0,0 +1,146 @@
+package validator
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/vmihailenco/msgpack/v5"
+
+	"transactioner/models"
+)
+
+func codecBatch() []*Transaction {
+	return []*Transaction{
+		{
+			Transaction: models.Transaction{
+				ID:  "abc",
+				Fee: models.Fee{Payer: "alice", Amount: 1.5},
+				Instructions: []models.Instruction{
+					{Account: "alice", Change: -10.0},
+					{Account: "bob", Change: map[string]any{"account": "alice", "sign": "plus"}},
+				},
+			},
+			Raw:       []byte{0, 1, 2, 0xff},
+			Sequences: map[string]uint64{"alice": 3, "bob": 1 << 40},
+		},
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	for _, codec := range []Codec{JSONCodec{}, MsgpackCodec{}} {
+		t.Run(codec.ContentType(), func(t *testing.T) {
+			batch := codecBatch()
+			buffer, err := codec.Marshal(batch)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var decoded []*Transaction
+			err = codec.Unmarshal(buffer, &decoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(decoded, batch) {
+				t.Errorf("decoded %+v, want %+v", decoded[0], batch[0])
+			}
+
+			deltas := Deltas{"alice": -11.5, "bob": 10, "validator": 1.5}
+			buffer, err = codec.Marshal(deltas)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var decodedDeltas Deltas
+			err = codec.Unmarshal(buffer, &decodedDeltas)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(decodedDeltas, deltas) {
+				t.Errorf("decoded %v, want %v", decodedDeltas, deltas)
+			}
+		})
+	}
+}
+
+func TestMsgpackEncoding(t *testing.T) {
+	tests := []struct {
+		value any
+		want  []byte
+	}{
+		{"hi", []byte{0xa2, 'h', 'i'}},
+		{[]byte{1, 2}, []byte{0xc4, 0x02, 1, 2}},
+		{map[string]bool{"b": true, "a": false}, []byte{0x82, 0xa1, 'a', 0xc2, 0xa1, 'b', 0xc3}},
+		{json.Number("-5"), []byte{0xcb, 0xc0, 0x14, 0, 0, 0, 0, 0, 0}},
+		{models.Fee{Payer: "a", Amount: 1.5}, []byte{0x82, 0xa5, 'p', 'a', 'y', 'e', 'r', 0xa1, 'a', 0xa6, 'a', 'm', 'o', 'u', 'n', 't', 0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+	}
+
+	for _, test := range tests {
+		got, err := MsgpackCodec{}.Marshal(test.value)
+		if err != nil {
+			t.Fatalf("%v: %v", test.value, err)
+		}
+		if !bytes.Equal(got, test.want) {
+			t.Errorf("%v encoded to % x, want % x", test.value, got, test.want)
+		}
+	}
+}
+
+func TestCodecNumberChange(t *testing.T) {
+	for _, codec := range []Codec{JSONCodec{}, MsgpackCodec{}} {
+		t.Run(codec.ContentType(), func(t *testing.T) {
+			batch := codecBatch()
+			batch[0].Instructions[0].Change = json.Number("-5")
+			buffer, err := codec.Marshal(batch)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var decoded []*Transaction
+			err = codec.Unmarshal(buffer, &decoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			change := decoded[0].Instructions[0].Change
+			if change != -5.0 {
+				t.Errorf("decoded change %#v, want -5.0", change)
+			}
+
+			// The batch is encoded as it is, not changed.
+			if change := batch[0].Instructions[0].Change; change != json.Number("-5") {
+				t.Errorf("encoding changed the batch to %#v", change)
+			}
+		})
+	}
+
+	// Numbers are converted by the codec alone, not by every msgpack user.
+	buffer, err := msgpack.Marshal(json.Number("-5"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0xa2, '-', '5'}; !bytes.Equal(buffer, want) {
+		t.Errorf("msgpack encoded json.Number as %x, want %x", buffer, want)
+	}
+}
+
+func TestMsgpackUnmarshalErrors(t *testing.T) {
+	var v any
+	for _, data := range [][]byte{
+		{},
+		{0xa5, 'a'},         // String shorter than its header.
+		{0xdd, 0, 0, 0, 10}, // Array shorter than its header.
+		{0x01, 0x02},        // Trailing data.
+		{0xc1},              // Never used prefix.
+	} {
+		err := MsgpackCodec{}.Unmarshal(data, &v)
+		if err == nil {
+			t.Errorf("% x decoded to %v, want an error", data, v)
+		}
+	}
+
+	err := MsgpackCodec{}.Unmarshal([]byte{0xc0}, v)
+	if err == nil {
+		t.Error("decoded into a non-pointer")
+	}
+}
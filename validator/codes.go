@@ -0,0 +1,93 @@
+package validator
+
+import (
+	"errors"
+	"transactioner/models"
+)
+
+// ErrorCode tells submitters why their transaction is rejected,
+// in a form that's stable for machines to act on.
+type ErrorCode string
+
+const (
+	CodeInvalidJSON         ErrorCode = "INVALID_JSON"
+	CodeMessageTooLarge     ErrorCode = "MESSAGE_TOO_LARGE"
+	CodeInvalidFee          ErrorCode = "INVALID_FEE"
+	CodeNegativeFee         ErrorCode = "NEGATIVE_FEE"
+	CodeNoInstructions      ErrorCode = "NO_INSTRUCTIONS"
+	CodeEmptyAccount        ErrorCode = "EMPTY_ACCOUNT"
+	CodeInvalidChange       ErrorCode = "INVALID_CHANGE"
+	CodeInvalidReference    ErrorCode = "INVALID_REFERENCE"
+	CodeInvalidAccount      ErrorCode = "INVALID_ACCOUNT"
+	CodeUnknownSign         ErrorCode = "UNKNOWN_SIGN"
+	CodeIDMismatch          ErrorCode = "ID_MISMATCH"
+	CodeInvalid             ErrorCode = "INVALID_TRANSACTION"
+	CodeFeeTooLow           ErrorCode = "FEE_TOO_LOW"
+	CodeFeeTooHigh          ErrorCode = "FEE_TOO_HIGH"
+	CodeSelfTransfer        ErrorCode = "SELF_TRANSFER"
+	CodeMiddleware          ErrorCode = "REJECTED_BY_MIDDLEWARE"
+	CodeVetoed              ErrorCode = "BATCH_VETOED"
+	CodeTooComplex          ErrorCode = "TOO_COMPLEX"
+	CodeUntrustedPriority   ErrorCode = "UNTRUSTED_PRIORITY"
+	CodeTooManyAccounts     ErrorCode = "TOO_MANY_ACCOUNTS"
+	CodeInsufficientBalance ErrorCode = "INSUFFICIENT_BALANCE"
+	CodeExecutionFailed     ErrorCode = "EXECUTION_FAILED"
+	CodeFrozenAccount       ErrorCode = "FROZEN_ACCOUNT"
+	CodeOverloaded          ErrorCode = "OVERLOADED"
+	CodeUnavailable         ErrorCode = "UNAVAILABLE"
+)
+
+// Codes of validation errors, more specific than their drop reason.
+var validationCodes = []struct {
+	err  error
+	code ErrorCode
+}{
+	{errMessageTooLarge, CodeMessageTooLarge},
+	{errIDMismatch, CodeIDMismatch},
+	{models.ErrNonFiniteFee, CodeInvalidFee},
+	{models.ErrNegativeFee, CodeNegativeFee},
+	{models.ErrNoInstructions, CodeNoInstructions},
+	{models.ErrEmptyAccount, CodeEmptyAccount},
+	{models.ErrNonFiniteChange, CodeInvalidChange},
+	{models.ErrUnknownChange, CodeInvalidChange},
+	{models.ErrNoReferenceAccount, CodeInvalidReference},
+	{models.ErrNoSign, CodeInvalidReference},
+	{models.ErrUnknownSign, CodeUnknownSign},
+	{models.ErrUnknownReferenceField, CodeInvalidReference},
+}
+
+// Codes of drop reasons.
+var reasonCodes = map[DropReason]ErrorCode{
+	ReasonMalformed:         CodeInvalidJSON,
+	ReasonInvalid:           CodeInvalid,
+	ReasonMinFee:            CodeFeeTooLow,
+	ReasonMaxFee:            CodeFeeTooHigh,
+	ReasonSelfTransfer:      CodeSelfTransfer,
+	ReasonAccountName:       CodeInvalidAccount,
+	ReasonVetoed:            CodeVetoed,
+	ReasonTooComplex:        CodeTooComplex,
+	ReasonUntrustedPriority: CodeUntrustedPriority,
+	ReasonTooManyAccounts:   CodeTooManyAccounts,
+	ReasonMiddleware:        CodeMiddleware,
+	ReasonFeeCheck:          CodeInsufficientBalance,
+	ReasonExecution:         CodeExecutionFailed,
+	ReasonFrozen:            CodeFrozenAccount,
+	ReasonPendingFull:       CodeOverloaded,
+	ReasonPendingBytes:      CodeOverloaded,
+}
+
+// errorCode returns the code of a transaction dropped for given reason
+// with given error, which may be nil.
+func errorCode(reason DropReason, err error) ErrorCode {
+	for _, known := range validationCodes {
+		if errors.Is(err, known.err) {
+			return known.code
+		}
+	}
+
+	if code, ok := reasonCodes[reason]; ok {
+		return code
+	}
+
+	return CodeInvalid
+}
@@ -0,0 +1,76 @@
+package validator
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSubmitErrorCodes(t *testing.T) {
+	vali := newTestValidator(t, map[string]float64{"alice": 100, "carol": 0},
+		WithMaxFee(50), WithRejectSelfTransfers(true), WithMaxAccountsPerTransaction(3),
+		WithTypeConfig(map[string]TypeConfig{"swap": {MinFee: 5}}),
+		WithTransactionMiddleware(func(tx *Transaction) (*Transaction, error) {
+			if tx.Fee.Payer == "mallory" {
+				return nil, errors.New("payer is blocked")
+			}
+			return tx, nil
+		}))
+
+	// message is a transaction paying fee with given instructions.
+	message := func(fee, instructions string) string {
+		return fmt.Sprintf(`{"fee": %s, "instructions": [%s]}`, fee, instructions)
+	}
+	const fee = `{"payer": "alice", "amount": 1}`
+	const transfer = `{"account": "alice", "change": -1}, {"account": "bob", "change": 1}`
+
+	tests := []struct {
+		msg  string
+		code ErrorCode
+	}{
+		{`{"fee": `, CodeInvalidJSON},
+		{message(fee, transfer+strings.Repeat(" ", maxMessageSize)), CodeMessageTooLarge},
+		{`{"id": "abc", "fee": {"payer": "alice", "amount": 1}, "instructions": [` + transfer + `]}`, CodeIDMismatch},
+		{message(`{"payer": "alice", "amount": -1}`, transfer), CodeNegativeFee},
+		{message(fee, ``), CodeNoInstructions},
+		{message(fee, `{"account": "", "change": 1}`), CodeEmptyAccount},
+		{message(fee, `{"account": "bob", "change": "ten"}`), CodeInvalidChange},
+		{message(fee, `{"account": "bob", "change": {"sign": "plus"}}`), CodeInvalidReference},
+		{message(fee, `{"account": "bob", "change": {"account": "carol"}}`), CodeInvalidReference},
+		{message(fee, `{"account": "bob", "change": {"account": "carol", "sign": "times"}}`), CodeUnknownSign},
+		{message(fee, `{"account": "bob", "change": {"account": "carol", "sign": "plus", "scale": 2}}`), CodeInvalidReference},
+		{`{"type": "swap", "fee": {"payer": "alice", "amount": 1}, "instructions": [` + transfer + `]}`, CodeFeeTooLow},
+		{message(`{"payer": "alice", "amount": 51}`, transfer), CodeFeeTooHigh},
+		{message(fee, `{"account": "bob", "change": 1}, {"account": "bob", "change": -1}`), CodeSelfTransfer},
+		{message(fee, transfer+`, {"account": "carol", "change": 1}, {"account": "dave", "change": -1}`), CodeTooManyAccounts},
+		{message(`{"payer": "mallory", "amount": 1}`, `{"account": "mallory", "change": -1}, {"account": "bob", "change": 1}`), CodeMiddleware},
+		{message(`{"payer": "carol", "amount": 1}`, `{"account": "carol", "change": -1}, {"account": "bob", "change": 1}`), CodeInsufficientBalance},
+		{message(`{"payer": "nobody", "amount": 1}`, transfer), CodeInsufficientBalance},
+	}
+	for _, test := range tests {
+		var response submitResponse
+		status := post(t, vali, "/submit", test.msg, &response)
+		if status != http.StatusBadRequest || response.Accepted {
+			t.Errorf("%.60s: got status %d, %+v", test.msg, status, response)
+		}
+		if response.Code != test.code {
+			t.Errorf("%.60s: got code %s, want %s (%s)", test.msg, response.Code, test.code, response.Error)
+		}
+	}
+
+	// Reasons of drops past submission.
+	for reason, code := range map[DropReason]ErrorCode{
+		ReasonFeeCheck:    CodeInsufficientBalance,
+		ReasonExecution:   CodeExecutionFailed,
+		ReasonFrozen:      CodeFrozenAccount,
+		ReasonPendingFull: CodeOverloaded,
+		ReasonVetoed:      CodeVetoed,
+		"unknown":         CodeInvalid,
+	} {
+		if got := errorCode(reason, nil); got != code {
+			t.Errorf("%s: got code %s, want %s", reason, got, code)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package validator
+
+import (
+	"net/http"
+	"sync"
+)
+
+// Number of committed transaction IDs remembered, see WasCommitted.
+const committedIDsSize = 1 << 16
+
+// committedIDs remembers the batch of recently committed transactions,
+// forgetting the oldest ones once full.
+type committedIDs struct {
+	mu      sync.Mutex
+	batches map[string]committedID
+	order   [committedIDsSize]string // IDs in commit order, by seq modulo size.
+	count   uint64                   // IDs added so far.
+}
+
+type committedID struct {
+	batchIdx uint64
+	seq      uint64 // Latest place in order, an ID may be committed again.
+}
+
+// add remembers the transactions of a committed batch.
+func (ids *committedIDs) add(batchIdx uint64, batch []*Transaction) {
+	ids.mu.Lock()
+	defer ids.mu.Unlock()
+
+	if ids.batches == nil {
+		ids.batches = make(map[string]committedID)
+	}
+
+	for _, tx := range batch {
+		ids.addID(tx.ID, batchIdx)
+	}
+}
+
+// addID remembers a committed transaction ID. Must be called with the
+// lock held.
+func (ids *committedIDs) addID(id string, batchIdx uint64) {
+	slot := &ids.order[ids.count%committedIDsSize]
+	// Forget the oldest ID, unless it's been committed again since.
+	if ids.count >= committedIDsSize && ids.batches[*slot].seq == ids.count-committedIDsSize {
+		delete(ids.batches, *slot)
+	}
+
+	*slot = id
+	ids.batches[id] = committedID{batchIdx: batchIdx, seq: ids.count}
+	ids.count++
+}
+
+// committedState is a remembered transaction ID, see SaveState.
+type committedState struct {
+	ID       string `json:"id"`
+	BatchIdx uint64 `json:"batchIdx"`
+}
+
+// save returns every remembered ID, oldest first.
+func (ids *committedIDs) save() []committedState {
+	ids.mu.Lock()
+	defer ids.mu.Unlock()
+
+	first := ids.count - min(ids.count, committedIDsSize)
+	saved := make([]committedState, 0, len(ids.batches))
+	for seq := first; seq < ids.count; seq++ {
+		id := ids.order[seq%committedIDsSize]
+		// Only the latest commit of an ID counts.
+		if committed := ids.batches[id]; committed.seq == seq {
+			saved = append(saved, committedState{ID: id, BatchIdx: committed.batchIdx})
+		}
+	}
+
+	return saved
+}
+
+// restore replaces the remembered IDs by saved ones, oldest first.
+func (ids *committedIDs) restore(saved []committedState) {
+	ids.mu.Lock()
+	defer ids.mu.Unlock()
+
+	ids.batches = make(map[string]committedID, len(saved))
+	ids.order = [committedIDsSize]string{}
+	ids.count = 0
+	for _, committed := range saved {
+		ids.addID(committed.ID, committed.BatchIdx)
+	}
+}
+
+// get returns the batch index the transaction is committed in.
+func (ids *committedIDs) get(id string) (uint64, bool) {
+	ids.mu.Lock()
+	defer ids.mu.Unlock()
+
+	committed, ok := ids.batches[id]
+	return committed.batchIdx, ok
+}
+
+// WasCommitted returns the index of the batch the transaction with given
+// ID is committed in. Only the most recent transactions are remembered,
+// ok is false if the transaction is too old or hasn't been committed.
+func (vali *Validator) WasCommitted(id string) (batchIdx uint64, ok bool) {
+	return vali.committedIDs.get(id)
+}
+
+type txResponse struct {
+	ID        string  `json:"id"`
+	Committed bool    `json:"committed"`
+	BatchIdx  *uint64 `json:"batchIdx,omitempty"` // Set if committed.
+}
+
+// handleTransaction tells whether a transaction is committed, and in
+// which batch. Not found means not committed as far as the validator
+// remembers.
+func (vali *Validator) handleTransaction(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	batchIdx, ok := vali.WasCommitted(id)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, txResponse{ID: id})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, txResponse{ID: id, Committed: true, BatchIdx: &batchIdx})
+}
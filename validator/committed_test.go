@@ -0,0 +1,63 @@
+package validator
+
+import (
+	"net/http"
+	"net/netip"
+	"strconv"
+	"testing"
+)
+
+func TestTransactionInclusion(t *testing.T) {
+	vali := newTestValidator(t, map[string]float64{"alice": 100}, WithSink(&recordingSink{}))
+
+	// An earlier batch, so it's not committed in the first one.
+	receive(t, vali, transfer("alice", "bob", 1, 1))
+	_, err := vali.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx := transfer("alice", "bob", 10, 1)
+	vali.handleMessage(encode(t, tx), netip.AddrPort{})
+
+	var response txResponse
+	if code := get(t, vali, "/tx/"+tx.ID, &response); code != http.StatusNotFound || response.Committed {
+		t.Errorf("got %d %+v before processing, want 404", code, response)
+	}
+
+	vali.drainIncoming()
+	processAll(t, vali)
+
+	if idx, ok := vali.WasCommitted(tx.ID); !ok || idx != 1 {
+		t.Errorf("got batch %d (%v), want 1", idx, ok)
+	}
+	code := get(t, vali, "/tx/"+tx.ID, &response)
+	if code != http.StatusOK || !response.Committed || response.ID != tx.ID || response.BatchIdx == nil || *response.BatchIdx != 1 {
+		t.Errorf("got %d %+v, want committed in batch 1", code, response)
+	}
+}
+
+func TestCommittedIDsBounded(t *testing.T) {
+	var ids committedIDs
+
+	batch := make([]*Transaction, committedIDsSize+1)
+	for i := range batch {
+		batch[i] = &Transaction{}
+		batch[i].ID = strconv.Itoa(i)
+	}
+	ids.add(0, batch[:1])
+	ids.add(1, batch[1:2])
+	// Committed again, so it outlives the first one.
+	ids.add(2, batch[1:2])
+	ids.add(3, batch[2:committedIDsSize])
+
+	if _, ok := ids.get("0"); ok {
+		t.Error("the oldest ID is still remembered")
+	}
+	if idx, ok := ids.get("1"); !ok || idx != 2 {
+		t.Errorf("got batch %d (%v) for an ID committed again, want 2", idx, ok)
+	}
+	if len(ids.batches) > committedIDsSize {
+		t.Errorf("remembering %d IDs, want at most %d", len(ids.batches), committedIDsSize)
+	}
+}
@@ -0,0 +1,81 @@
+package validator
+
+import (
+	"slices"
+	adb "transactioner/accountsdb"
+)
+
+// ConflictDetector decides which transactions can't share a batch,
+// see WithConflictDetector.
+//
+// Only transactions that can execute on their own against the balances
+// at the start of the batch are given to the detector. It's up to the
+// detector to keep transactions from overdrawing an account together,
+// that's what keeps a batch executable in any order.
+type ConflictDetector interface {
+	// Conflicts returns true if tx can't join the batch being built.
+	// Account names are normalized.
+	Conflicts(tx *Transaction, batch Batch) bool
+}
+
+// Batch is the batch being built, as a ConflictDetector sees it.
+type Batch struct {
+	Transactions []*Transaction // Already in the batch.
+	// Balances as of the batch start, less what the transactions
+	// already in the batch take. Must not be modified.
+	Balances *adb.AccountsDb
+}
+
+// BalanceConflictDetector is the default detector: a transaction
+// conflicts with the batch if it takes an account below zero, or below
+// its overdraft allowance, once the transactions already in the batch
+// have taken their share.
+type BalanceConflictDetector struct{}
+
+func (BalanceConflictDetector) Conflicts(tx *Transaction, batch Batch) bool {
+	for account, debit := range tx.Debits() {
+		available, err := batch.Balances.Available(account)
+		if err == nil && available+debit < 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AccountConflicts is a strict detector where any two transactions
+// touching the same account conflict, whether as the payer, an
+// instruction account, a referenced account or the validator account
+// paying for a mint. Batches then never depend on the order of their
+// transactions, whatever the balances.
+type AccountConflicts struct{}
+
+func (AccountConflicts) Conflicts(tx *Transaction, batch Batch) bool {
+	touched := make(map[string]struct{})
+	for _, account := range touchedAccounts(tx) {
+		touched[account] = struct{}{}
+	}
+
+	for _, other := range batch.Transactions {
+		for _, account := range touchedAccounts(other) {
+			if _, ok := touched[account]; ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// touchedAccounts returns every account the transaction touches,
+// including the ones only debited. May contain duplicates.
+func touchedAccounts(tx *Transaction) []string {
+	accounts := tx.accounts()
+	for account := range tx.Debits() {
+		if !slices.Contains(accounts, account) {
+			accounts = append(accounts, account)
+		}
+	}
+
+	return accounts
+}
@@ -0,0 +1,84 @@
+package validator
+
+import (
+	"slices"
+	"testing"
+)
+
+// payerConflicts rejects transactions of a payer from every batch.
+type payerConflicts struct {
+	payer string
+	calls int
+}
+
+func (detector *payerConflicts) Conflicts(tx *Transaction, _ Batch) bool {
+	detector.calls++
+	return tx.Fee.Payer == detector.payer
+}
+
+func TestConflictDetectors(t *testing.T) {
+	// ids returns IDs of the batch, sorted.
+	ids := func(batch []*Transaction) []string {
+		var ids []string
+		for _, tx := range batch {
+			ids = append(ids, tx.ComputeID())
+		}
+		slices.Sort(ids)
+		return ids
+	}
+
+	aliceToBob := transfer("alice", "bob", 10, 1)
+	bobToCarol := transfer("bob", "carol", 10, 1)
+	daveToErin := transfer("dave", "erin", 10, 1)
+	balances := map[string]float64{"alice": 100, "bob": 100, "dave": 100}
+
+	tests := []struct {
+		name     string
+		detector ConflictDetector
+		batches  [][]*Transaction
+	}{
+		// Everyone can afford their transfer, whatever the order.
+		{"balance", nil, [][]*Transaction{{aliceToBob, bobToCarol, daveToErin}}},
+		// Bob is shared, he has to wait for the next batch.
+		{"account", AccountConflicts{}, [][]*Transaction{{aliceToBob, daveToErin}, {bobToCarol}}},
+	}
+	for _, test := range tests {
+		opts := []Option{WithSink(&recordingSink{})}
+		if test.detector != nil {
+			opts = append(opts, WithConflictDetector(test.detector))
+		}
+		vali := newTestValidator(t, balances, opts...)
+		for _, tx := range []*Transaction{aliceToBob, bobToCarol, daveToErin} {
+			vali.PushTransaction(transfer(tx.Fee.Payer, tx.Instructions[1].Account, 10, 1))
+		}
+
+		batches := processAll(t, vali)
+		if len(batches) != len(test.batches) {
+			t.Fatalf("%s: committed %d batch(es), want %d", test.name, len(batches), len(test.batches))
+		}
+		for i, batch := range batches {
+			if got, want := ids(batch), ids(test.batches[i]); !slices.Equal(got, want) {
+				t.Errorf("%s: batch %d is %v, want %v", test.name, i, got, want)
+			}
+		}
+	}
+
+	// Custom detectors decide alone.
+	detector := &payerConflicts{payer: "dave"}
+	vali := newTestValidator(t, balances, WithConflictDetector(detector), WithSink(&recordingSink{}))
+	vali.PushTransaction(transfer("alice", "bob", 10, 1))
+	vali.PushTransaction(transfer("dave", "erin", 10, 1))
+	batch, err := vali.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batch) != 1 || batch[0].Fee.Payer != "alice" {
+		t.Errorf("committed %v, want alice's transfer only", batch)
+	}
+	if detector.calls != 2 {
+		t.Errorf("detector called %d time(s), want 2", detector.calls)
+	}
+	if n := vali.PendingCount(); n != 1 {
+		t.Errorf("%d transaction(s) left pending, want dave's", n)
+	}
+}
@@ -0,0 +1,88 @@
+package validator
+
+import (
+	"fmt"
+	"log"
+	"math"
+)
+
+// ConservationAction decides what happens when a commit
+// changes total supply under strict conservation.
+type ConservationAction int
+
+const (
+	// ConservationPanic panics, halting the validator.
+	ConservationPanic ConservationAction = iota
+	// ConservationLog logs the violation and carries on.
+	ConservationLog
+)
+
+// Counter of commits that changed total supply.
+const conservationViolationsSeries = "conservation_violations_total"
+
+// ConservationViolations returns how many commits changed total supply.
+// Only counted under strict conservation.
+func (vali *Validator) ConservationViolations() uint64 {
+	return vali.metrics.counter(conservationViolationsSeries)
+}
+
+// conserve runs fn, which must leave total supply unchanged. Under
+// strict conservation, a change is handled per conservation action.
+func (vali *Validator) conserve(what string, fn func()) {
+	if !vali.strictConservation {
+		fn()
+		return
+	}
+
+	before := vali.db.TotalSupply()
+	fn()
+	after := vali.db.TotalSupply()
+
+	if supplyEqual(before, after) {
+		return
+	}
+
+	vali.metrics.inc(conservationViolationsSeries)
+
+	msg := fmt.Sprintf("%s changed total supply by %v after batch %d", what, after-before, vali.batchIdx.Load())
+	switch vali.conservationAction {
+	case ConservationLog:
+		log.Print(msg)
+	default:
+		panic(msg)
+	}
+}
+
+// Counter of snapshots finding total supply drifted from the initial one.
+const supplyDriftsSeries = "supply_drifts_total"
+
+// SupplyDrifts returns how many times total supply is found to have
+// drifted from the initial one, see WithSupplyCheck.
+func (vali *Validator) SupplyDrifts() uint64 {
+	return vali.metrics.counter(supplyDriftsSeries)
+}
+
+// checkSupply compares total supply to the one the db is loaded with,
+// which transactions can't change: fees and mints go through the
+// validator account. Drift is logged and counted. It waits for a batch
+// being settled to finish, as balances may be halfway through changing.
+func (vali *Validator) checkSupply() {
+	vali.processMu.Lock()
+	expected, current := vali.expectedSupply, vali.db.TotalSupply()
+	// Read along with the supply, a batch may be committed once unlocked.
+	batchIdx := vali.batchIdx.Load()
+	vali.processMu.Unlock()
+
+	if supplyEqual(expected, current) {
+		return
+	}
+
+	vali.metrics.inc(supplyDriftsSeries)
+	log.Printf("total supply drifted by %v from %v after batch %d", current-expected, expected, batchIdx)
+}
+
+// supplyEqual compares total supplies, tolerating rounding errors of
+// summing the same balances in a different order.
+func supplyEqual(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-9*max(1, math.Abs(a), math.Abs(b))
+}
@@ -0,0 +1,105 @@
+package validator
+
+import (
+	"testing"
+
+	adb "transactioner/accountsdb"
+	"transactioner/models"
+)
+
+func TestFailedButPayableConservesSupply(t *testing.T) {
+	// Panics on any violation.
+	vali := newTestValidator(t, map[string]float64{"alice": 100, "bob": 0},
+		WithStrictConservation(true))
+
+	// Alice can pay the fee, but the transaction fails to execute:
+	// its instructions don't sum up to zero.
+	failing := &Transaction{Transaction: models.Transaction{
+		Fee: models.Fee{Payer: "alice", Amount: 2},
+		Instructions: []models.Instruction{
+			{Account: "alice", Change: -5.0},
+			{Account: "bob", Change: 10.0},
+		},
+	}}
+	vali.PushTransaction(failing)
+	vali.PushTransaction(transfer("alice", "bob", 10, 1))
+
+	batches := processAll(t, vali)
+	if len(batches) != 1 || len(batches[0]) != 1 {
+		t.Fatalf("committed %v, want a single transaction", batches)
+	}
+
+	// Fee of the failed one is charged, once.
+	want := map[string]float64{"alice": 87, "bob": 10, adb.ValidatorAccount: 3}
+	for account, amount := range want {
+		if balance, _ := vali.db.GetBalance(account); balance != amount {
+			t.Errorf("balance of %s is %v, want %v", account, balance, amount)
+		}
+	}
+	if supply := vali.db.TotalSupply(); supply != 100 {
+		t.Errorf("total supply is %v, want 100", supply)
+	}
+	if n := vali.ConservationViolations(); n != 0 {
+		t.Errorf("%d conservation violation(s)", n)
+	}
+}
+
+func TestConservationViolation(t *testing.T) {
+	leak := func(vali *Validator) {
+		vali.conserve("leak", func() {
+			vali.db.UpdateBy("alice", 1)
+		})
+	}
+
+	vali := newTestValidator(t, map[string]float64{"alice": 100},
+		WithStrictConservation(true), WithConservationAction(ConservationLog))
+	leak(vali)
+	if n := vali.ConservationViolations(); n != 1 {
+		t.Errorf("%d conservation violation(s), want 1", n)
+	}
+	vali.Close()
+
+	vali = newTestValidator(t, map[string]float64{"alice": 100}, WithStrictConservation(true))
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("violation didn't panic")
+			}
+		}()
+		leak(vali)
+	}()
+	vali.Close()
+
+	// Not checked unless strict.
+	vali = newTestValidator(t, map[string]float64{"alice": 100})
+	leak(vali)
+	if n := vali.ConservationViolations(); n != 0 {
+		t.Errorf("%d conservation violation(s) counted while not strict", n)
+	}
+}
+
+func TestSupplyCheck(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	vali := newTestValidator(t, map[string]float64{"alice": 100, "bob": 0},
+		WithSupplyCheck(true), WithSink(&recordingSink{}))
+
+	// Fees move to the validator account, supply is the same.
+	receive(t, vali, transfer("alice", "bob", 10, 1))
+	processAll(t, vali)
+	vali.checkSupply()
+	if n := vali.SupplyDrifts(); n != 0 {
+		t.Fatalf("%d drift(s) after a regular commit, want 0", n)
+	}
+
+	// A leaky commit, crediting bob out of nowhere.
+	vali.db.Apply(map[string]float64{"bob": 5}, nil, 1)
+
+	vali.wg.Add(1)
+	go vali.TakeSnapshots()
+	defer func() {
+		vali.Close()
+		vali.wg.Wait()
+	}()
+	waitFor(t, func() bool { return vali.SupplyDrifts() == 1 })
+}
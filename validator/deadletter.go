@@ -0,0 +1,143 @@
+package validator
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// deadLetter is a record of the dead-letter file, see WithDeadLetterFile.
+type deadLetter struct {
+	Time   time.Time  `json:"time"`
+	Reason DropReason `json:"reason"`
+	Error  string     `json:"error,omitempty"`
+	// Transaction as decoded, absent if it couldn't be decoded.
+	Transaction json.RawMessage `json:"transaction,omitempty"`
+	// Message as received, only if it couldn't be decoded.
+	Message string `json:"message,omitempty"`
+	// Batch the transaction is committed in, only if it couldn't be sent.
+	BatchIdx *uint64 `json:"batchIdx,omitempty"`
+}
+
+// deadLetters appends dropped transactions to a file as NDJSON.
+type deadLetters struct {
+	mu      sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+	closed  bool
+}
+
+func openDeadLetters(path string) (*deadLetters, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &deadLetters{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+func (letters *deadLetters) write(letter deadLetter) {
+	letters.mu.Lock()
+	defer letters.mu.Unlock()
+
+	if letters.closed {
+		log.Printf("dead-letter file is closed, dropping %s transaction", letter.Reason)
+		return
+	}
+
+	err := letters.encoder.Encode(letter)
+	if err != nil {
+		log.Printf("failed to write dead letter: %v", err)
+	}
+}
+
+func (letters *deadLetters) close() error {
+	letters.mu.Lock()
+	defer letters.mu.Unlock()
+
+	if letters.closed {
+		return nil
+	}
+
+	letters.closed = true
+	return letters.file.Close()
+}
+
+// drop counts a transaction dropped for good, and writes it
+// to the dead-letter file if there's one. Err may be nil.
+func (vali *Validator) drop(tx *Transaction, reason DropReason, err error) {
+	vali.reject(reason)
+	if tx.span != nil {
+		vali.endTrace(tx, dropError(reason, err))
+	}
+
+	record := RejectionRecord{Time: vali.clock.Now(), Reason: reason, ID: tx.ID, Payer: tx.Fee.Payer}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	vali.sampleRejection(record)
+
+	if vali.deadLetters == nil {
+		return
+	}
+
+	letter := deadLetter{Time: record.Time, Reason: reason, Error: record.Error}
+
+	letter.Transaction, err = json.Marshal(&tx.Transaction)
+	if err != nil {
+		log.Printf("failed to encode dead letter: %v", err)
+		return
+	}
+
+	vali.deadLetters.write(letter)
+}
+
+// dropMessage is drop for messages that couldn't be decoded.
+func (vali *Validator) dropMessage(msg []byte, reason DropReason, err error) {
+	vali.reject(reason)
+
+	now := vali.clock.Now()
+	vali.sampleRejection(RejectionRecord{Time: now, Reason: reason, Error: err.Error()})
+
+	if vali.deadLetters == nil {
+		return
+	}
+
+	vali.deadLetters.write(deadLetter{
+		Time:    now,
+		Reason:  reason,
+		Error:   err.Error(),
+		Message: string(msg),
+	})
+}
+
+// deadLetterBatch writes the transactions of a committed batch that
+// couldn't be sent on shutdown to the dead-letter file, see
+// ShutdownDeadLetter. Name describes the batch, first is its index.
+func (vali *Validator) deadLetterBatch(batch []*Transaction, name string, first uint64, err error) {
+	log.Printf("warning: %s is committed but not sent, reconcile %d transaction(s) with the collector", name, len(batch))
+	if vali.deadLetters == nil {
+		return
+	}
+
+	for _, tx := range batch {
+		// Coalesced batches span several indexes, the transaction knows its own.
+		batchIdx, ok := vali.WasCommitted(tx.ID)
+		if !ok {
+			batchIdx = first
+		}
+
+		letter := deadLetter{Time: vali.clock.Now(), Reason: ReasonUnsent, Error: err.Error(), BatchIdx: &batchIdx}
+
+		var encodeErr error
+		letter.Transaction, encodeErr = json.Marshal(&tx.Transaction)
+		if encodeErr != nil {
+			log.Printf("failed to encode dead letter: %v", encodeErr)
+			continue
+		}
+
+		vali.deadLetters.write(letter)
+	}
+}
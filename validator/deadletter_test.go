@@ -0,0 +1,245 @@
+package validator
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+)
+
+// readDeadLetters returns the dead letters written to path.
+func readDeadLetters(t *testing.T, path string) []deadLetter {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var letters []deadLetter
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var letter deadLetter
+		err := json.Unmarshal(scanner.Bytes(), &letter)
+		if err != nil {
+			t.Fatalf("line %q: %v", scanner.Text(), err)
+		}
+		letters = append(letters, letter)
+	}
+
+	return letters
+}
+
+func TestDeadLetterFile(t *testing.T) {
+	mock := clock.NewMock()
+	mock.Set(time.Now())
+	path := filepath.Join(t.TempDir(), "dead.ndjson")
+	vali := newTestValidator(t, map[string]float64{"alice": 100, "carol": 0, "dave": 10},
+		WithDeadLetterFile(path), WithSink(&recordingSink{}), WithClock(mock), WithMaxPendingAge(time.Minute))
+
+	// A malformed message and a payer that can't afford the fee are
+	// dropped for good, so is a transfer dave can never afford once
+	// it's been pending for too long.
+	vali.handleMessage([]byte(`{"fee": `), netip.AddrPort{})
+	broke := transfer("carol", "bob", 1, 1)
+	receive(t, vali, broke)
+	receive(t, vali, transfer("alice", "bob", 1, 1))
+	stuck := transfer("dave", "bob", 20, 1)
+	receive(t, vali, stuck)
+	_, err := vali.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := vali.PendingCount(); n != 1 {
+		t.Fatalf("%d transaction(s) pending, want the deferred one", n)
+	}
+
+	mock.Add(time.Minute)
+	_, err = vali.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := vali.PendingCount(); n != 0 {
+		t.Errorf("%d transaction(s) pending after expiry, want 0", n)
+	}
+	err = vali.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	letters := readDeadLetters(t, path)
+	if len(letters) != 3 {
+		t.Fatalf("got %d dead letter(s), want 3", len(letters))
+	}
+
+	malformed := letters[0]
+	if malformed.Reason != ReasonMalformed || malformed.Message != `{"fee": ` || malformed.Error == "" || malformed.Time.IsZero() {
+		t.Errorf("got %+v for the malformed message", malformed)
+	}
+
+	unpaid := letters[1]
+	if unpaid.Reason != ReasonFeeCheck || unpaid.Message != "" || unpaid.Time.IsZero() {
+		t.Errorf("got %+v for the unpaid transaction", unpaid)
+	}
+	// Written as received, so it can be replayed.
+	replayed, err := vali.decodeTransaction(unpaid.Transaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := broke.ComputeID(); replayed.ID != want {
+		t.Errorf("dead letter decodes to %s, want %s", replayed.ID, want)
+	}
+
+	expired := letters[2]
+	if expired.Reason != ReasonExpired || expired.Error == "" || !expired.Time.Equal(mock.Now()) {
+		t.Errorf("got %+v for the expired transaction", expired)
+	}
+	replayed, err = vali.decodeTransaction(expired.Transaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed.ID != stuck.ID {
+		t.Errorf("dead letter decodes to %s, want %s", replayed.ID, stuck.ID)
+	}
+	if n := vali.Rejections(ReasonExpired); n != 1 {
+		t.Errorf("%d transaction(s) expired, want 1", n)
+	}
+}
+
+func TestDeadLetterRetriesExhausted(t *testing.T) {
+	const retries = 3
+
+	path := filepath.Join(t.TempDir(), "dead.ndjson")
+	vali := newTestValidator(t, map[string]float64{"dave": 10},
+		WithDeadLetterFile(path), WithSink(&recordingSink{}), WithMaxDeferrals(retries))
+
+	// Dave can pay the fee but never the transfer, so it's deferred
+	// as non-commutative every time it's tried.
+	stuck := transfer("dave", "bob", 20, 1)
+	receive(t, vali, stuck)
+	for i := range retries {
+		if n := vali.PendingCount(); n != 1 {
+			t.Fatalf("%d transaction(s) pending after %d deferral(s), want 1", n, i)
+		}
+
+		_, err := vali.Flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := vali.PendingCount(); n != 0 {
+		t.Errorf("%d transaction(s) pending after %d deferrals, want 0", n, retries)
+	}
+	err := vali.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	letters := readDeadLetters(t, path)
+	if len(letters) != 1 {
+		t.Fatalf("got %d dead letter(s), want 1", len(letters))
+	}
+	letter := letters[0]
+	if letter.Reason != ReasonRetriesExhausted || letter.Error == "" {
+		t.Errorf("got %+v for the exhausted transaction", letter)
+	}
+	replayed, err := vali.decodeTransaction(letter.Transaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed.ID != stuck.ID {
+		t.Errorf("dead letter decodes to %s, want %s", replayed.ID, stuck.ID)
+	}
+	if n := vali.Rejections(ReasonNonCommutative); n != retries {
+		t.Errorf("deferred %d time(s), want %d", n, retries)
+	}
+}
+
+// downSink accepts batches until the collector goes down.
+type downSink struct {
+	recordingSink
+	down atomic.Bool
+}
+
+func (sink *downSink) Send(batch []*Transaction) (int, error) {
+	if sink.down.Load() {
+		return 0, errors.New("collector is down")
+	}
+	return sink.recordingSink.Send(batch)
+}
+
+func TestDeadLetterUnsentBatches(t *testing.T) {
+	const batches = 5
+	t.Chdir(t.TempDir())
+
+	// Sends past the first one wait for the rate limit,
+	// until the validator is shut down.
+	mock := clock.NewMock()
+	mock.Set(time.Now())
+	path := filepath.Join(t.TempDir(), "dead.ndjson")
+	sink := &downSink{}
+	vali := newTestValidator(t, map[string]float64{"alice": 100}, WithBatchSize(1), WithSink(sink),
+		WithClock(mock), WithDeadLetterFile(path), WithShutdownSendPolicy(ShutdownDeadLetter))
+	for i := range batches {
+		vali.PushTransaction(transfer("alice", "bob", float64(i+1), 1))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		vali.RunContext(ctx)
+		close(done)
+	}()
+
+	waitFor(t, func() bool { return len(sink.sent()) > 0 })
+	sink.down.Store(true)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown is blocked")
+	}
+
+	// Every transaction is either sent or dead-lettered.
+	unsent := make(map[string]bool)
+	for _, batch := range vali.RecentBatches(batches) {
+		unsent[batch[0].ID] = true
+	}
+	for _, batch := range sink.sent() {
+		delete(unsent, batch[0].ID)
+	}
+
+	letters := readDeadLetters(t, path)
+	if len(letters) != batches-1 {
+		t.Fatalf("got %d dead letter(s), want %d", len(letters), batches-1)
+	}
+	for i, letter := range letters {
+		if letter.Reason != ReasonUnsent || letter.Error == "" {
+			t.Errorf("got %+v for an unsent transaction", letter)
+		}
+		// Committed though, in a batch of its own.
+		if letter.BatchIdx == nil || *letter.BatchIdx != uint64(i+1) {
+			t.Errorf("dead letter %d has batch index %v, want %d", i, letter.BatchIdx, i+1)
+		}
+		replayed, err := vali.decodeTransaction(letter.Transaction)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !unsent[replayed.ID] {
+			t.Errorf("dead letter %d is %s, which isn't unsent", i, replayed.ID)
+		}
+		delete(unsent, replayed.ID)
+	}
+	if len(unsent) != 0 {
+		t.Errorf("%d unsent transaction(s) not dead-lettered", len(unsent))
+	}
+}
@@ -0,0 +1,130 @@
+package validator
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// metricsSnapshot is a consistent copy of every metric, gauges included.
+type metricsSnapshot struct {
+	Counters   map[string]uint64       `json:"counters"`
+	Gauges     map[string]float64      `json:"gauges"`
+	Histograms map[string]histogramSum `json:"histograms"`
+}
+
+// histogramSum is how a histogram is summarized in JSON.
+type histogramSum struct {
+	Count   uint64         `json:"count"`
+	Sum     float64        `json:"sum"`
+	Buckets []bucketSample `json:"buckets"`
+}
+
+type bucketSample struct {
+	LE    float64 `json:"le"`
+	Count uint64  `json:"count"`
+}
+
+// snapshot copies every counter and histogram of the registry.
+func (m *metrics) snapshot() (map[string]uint64, map[string]Histogram) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	histograms := make(map[string]Histogram, len(m.histograms))
+	for name, h := range m.histograms {
+		histograms[name] = h.clone()
+	}
+
+	return maps.Clone(m.counters), histograms
+}
+
+// gauges returns the current values of state that goes up and down.
+func (vali *Validator) gauges() map[string]float64 {
+	vali.pendingMu.Lock()
+	pending, pendingBytes, heapBytes := vali.pending.Len(), vali.pendingBytes, vali.heapBytesEstimate()
+	vali.pendingMu.Unlock()
+
+	paused := 0.0
+	if vali.Paused() {
+		paused = 1
+	}
+
+	degraded := 0.0
+	if vali.Degraded() {
+		degraded = 1
+	}
+
+	return map[string]float64{
+		"validator_pending_transactions": float64(pending),
+		"validator_pending_bytes":        float64(pendingBytes),
+		"validator_heap_bytes_estimate":  float64(heapBytes),
+		"validator_accounts":             float64(vali.db.AccountCount(false)),
+		"validator_paused":               paused,
+		"validator_degraded":             degraded,
+		"validator_acceptance_rate":      vali.AcceptanceRate(),
+		"validator_fee_rejection_ratio":  vali.FeeRejectionRatio(),
+	}
+}
+
+// handleStatsJSON serves every metric as a JSON document.
+func (vali *Validator) handleStatsJSON(w http.ResponseWriter, r *http.Request) {
+	counters, histograms := vali.metrics.snapshot()
+
+	snapshot := metricsSnapshot{
+		Counters:   counters,
+		Gauges:     vali.gauges(),
+		Histograms: make(map[string]histogramSum, len(histograms)),
+	}
+	for name, h := range histograms {
+		sum := histogramSum{Count: h.Count, Sum: h.Sum, Buckets: make([]bucketSample, len(h.Bounds))}
+		for i, bound := range h.Bounds {
+			sum.Buckets[i] = bucketSample{LE: bound, Count: h.Counts[i]}
+		}
+		snapshot.Histograms[name] = sum
+	}
+
+	writeJSON(w, http.StatusOK, snapshot)
+}
+
+// handleMetrics serves every metric in Prometheus text format.
+func (vali *Validator) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	counters, histograms := vali.metrics.snapshot()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeSeries(w, "counter", counters)
+	writeSeries(w, "gauge", vali.gauges())
+
+	for _, name := range slices.Sorted(maps.Keys(histograms)) {
+		h := histograms[name]
+		fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+		for i, bound := range h.Bounds {
+			fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, formatFloat(bound), h.Counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.Count)
+		fmt.Fprintf(w, "%s_sum %s\n", name, formatFloat(h.Sum))
+		fmt.Fprintf(w, "%s_count %d\n", name, h.Count)
+	}
+}
+
+// writeSeries writes samples of given type sorted by name, with a TYPE
+// line once per metric, whatever labels its series have.
+func writeSeries[V uint64 | float64](w io.Writer, kind string, samples map[string]V) {
+	var last string
+	for _, series := range slices.Sorted(maps.Keys(samples)) {
+		name, _, _ := strings.Cut(series, "{")
+		if name != last {
+			fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+			last = name
+		}
+
+		fmt.Fprintf(w, "%s %s\n", series, formatFloat(float64(samples[series])))
+	}
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
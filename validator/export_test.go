@@ -0,0 +1,53 @@
+package validator
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStatsJSON(t *testing.T) {
+	vali := newTestValidator(t, map[string]float64{"alice": 100, "carol": 0}, WithSink(&recordingSink{}))
+
+	receive(t, vali, transfer("alice", "bob", 10, 1))
+	receive(t, vali, transfer("alice", "bob", 20, 1))
+	receive(t, vali, transfer("carol", "bob", 1, 1))
+	_, err := vali.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	vali.PushTransaction(transfer("alice", "bob", 5, 1))
+
+	var snapshot metricsSnapshot
+	if status := get(t, vali, "/stats.json", &snapshot); status != http.StatusOK {
+		t.Fatalf("got status %d", status)
+	}
+
+	if n := snapshot.Counters[rejectedSeries(ReasonFeeCheck)]; n != 1 {
+		t.Errorf("got %d fee check rejection(s), want 1", n)
+	}
+	for name, want := range map[string]float64{
+		"validator_pending_transactions": 1,
+		"validator_accounts":             3,
+		"validator_paused":               0,
+	} {
+		if got, ok := snapshot.Gauges[name]; !ok || got != want {
+			t.Errorf("gauge %s is %v (%v), want %v", name, got, ok, want)
+		}
+	}
+	sizes, ok := snapshot.Histograms[batchSizeSeries]
+	if !ok || sizes.Count != 1 || sizes.Sum != 2 || len(sizes.Buckets) == 0 {
+		t.Errorf("got batch size histogram %+v (%v), want a batch of 2", sizes, ok)
+	}
+
+	// Same metrics as served to Prometheus.
+	recorder := httptest.NewRecorder()
+	vali.handleMetrics(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for name, value := range snapshot.Counters {
+		if line := fmt.Sprintf("%s %d\n", name, value); !strings.Contains(recorder.Body.String(), line) {
+			t.Errorf("metrics lack %q", line)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package validator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	"transactioner/collectorpb"
+)
+
+// GRPCSink sends batches to a BatchCollector service over gRPC, see
+// collectorpb/collector.proto. Batches are accepted if Submit succeeds,
+// rejections are reported by gRPC status codes and turned into their
+// HTTP counterparts, e.g. RESOURCE_EXHAUSTED into 413 so too large
+// batches are split. Codes telling the collector couldn't be reached
+// are returned as errors.
+type GRPCSink struct {
+	Client  collectorpb.BatchCollectorClient
+	Timeout time.Duration // Of a single Submit, unlimited if 0.
+
+	conn *grpc.ClientConn // Created by NewGRPCSink, nil if given a client.
+}
+
+// NewGRPCSink creates a sink submitting batches to the collector at given
+// address, e.g. "localhost:2003". The connection is made in plain text
+// unless dial options tell otherwise.
+func NewGRPCSink(addr string, opts ...grpc.DialOption) (*GRPCSink, error) {
+	opts = append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, opts...)
+	conn, err := grpc.NewClient(addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GRPCSink{Client: collectorpb.NewBatchCollectorClient(conn), Timeout: 30 * time.Second, conn: conn}, nil
+}
+
+// Close closes the connection made by NewGRPCSink.
+func (sink *GRPCSink) Close() error {
+	if sink.conn == nil {
+		return nil
+	}
+
+	return sink.conn.Close()
+}
+
+func (sink *GRPCSink) Send(batch []*Transaction) (int, error) {
+	msg, err := batchMessage(batch)
+	if err != nil {
+		return 0, err
+	}
+
+	return sink.submit(msg)
+}
+
+// SendRange is Send with the range of the batch set.
+func (sink *GRPCSink) SendRange(batch []*Transaction, first, last uint64) (int, error) {
+	msg, err := batchMessage(batch)
+	if err != nil {
+		return 0, err
+	}
+	msg.Range = &collectorpb.BatchRange{First: first, Last: last}
+
+	return sink.submit(msg)
+}
+
+// SendDeltas sends a compacted batch, carrying only deltas.
+func (sink *GRPCSink) SendDeltas(deltas Deltas) (int, error) {
+	return sink.submit(&collectorpb.Batch{Deltas: deltas, Compacted: true})
+}
+
+// SendDeltasRange is SendDeltas with the range of the batch set.
+func (sink *GRPCSink) SendDeltasRange(deltas Deltas, first, last uint64) (int, error) {
+	return sink.submit(&collectorpb.Batch{
+		Deltas:    deltas,
+		Compacted: true,
+		Range:     &collectorpb.BatchRange{First: first, Last: last},
+	})
+}
+
+func (sink *GRPCSink) submit(msg *collectorpb.Batch) (int, error) {
+	ctx := context.Background()
+	if sink.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, sink.Timeout)
+		defer cancel()
+	}
+
+	_, err := sink.Client.Submit(ctx, msg)
+	if err == nil {
+		return http.StatusOK, nil
+	}
+
+	code := status.Code(err)
+	switch code {
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest, nil
+	case codes.ResourceExhausted:
+		return http.StatusRequestEntityTooLarge, nil
+	case codes.AlreadyExists:
+		return http.StatusConflict, nil
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized, nil
+	case codes.PermissionDenied:
+		return http.StatusForbidden, nil
+	case codes.Unimplemented:
+		return http.StatusNotImplemented, nil
+	default:
+		return 0, err
+	}
+}
+
+// batchMessage maps a batch to its proto message.
+func batchMessage(batch []*Transaction) (*collectorpb.Batch, error) {
+	msg := &collectorpb.Batch{Transactions: make([]*collectorpb.Transaction, 0, len(batch))}
+	for _, tx := range batch {
+		txMsg, err := transactionMessage(tx)
+		if err != nil {
+			return nil, err
+		}
+		msg.Transactions = append(msg.Transactions, txMsg)
+	}
+	root := BatchRoot(batch)
+	msg.Root = root[:]
+
+	return msg, nil
+}
+
+// transactionMessage maps a transaction to its proto message.
+func transactionMessage(tx *Transaction) (*collectorpb.Transaction, error) {
+	msg := &collectorpb.Transaction{
+		Id:           tx.ID,
+		Type:         tx.Type,
+		Fee:          &collectorpb.Fee{Payer: tx.Fee.Payer, Amount: tx.Fee.Amount},
+		Instructions: make([]*collectorpb.Instruction, 0, len(tx.Instructions)),
+		Raw:          tx.Raw,
+		Priority:     tx.Priority,
+		Sequences:    tx.Sequences,
+		TraceId:      tx.TraceID,
+	}
+
+	for _, instr := range tx.Instructions {
+		instrMsg := &collectorpb.Instruction{Account: instr.Account}
+		switch change := instr.Change.(type) {
+		case float64:
+			instrMsg.Change = &collectorpb.Instruction_Amount{Amount: change}
+		case json.Number:
+			amount, err := change.Float64()
+			if err != nil {
+				return nil, err
+			}
+			instrMsg.Change = &collectorpb.Instruction_Amount{Amount: amount}
+		case map[string]any:
+			account, _ := change["account"].(string)
+			sign := collectorpb.Reference_PLUS
+			if change["sign"] == "minus" {
+				sign = collectorpb.Reference_MINUS
+			}
+			instrMsg.Change = &collectorpb.Instruction_Reference{Reference: &collectorpb.Reference{Account: account, Sign: sign}}
+		default:
+			return nil, fmt.Errorf("unexpected change of type %T", change)
+		}
+		msg.Instructions = append(msg.Instructions, instrMsg)
+	}
+
+	return msg, nil
+}
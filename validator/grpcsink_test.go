@@ -0,0 +1,153 @@
+package validator
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"transactioner/collectorpb"
+	"transactioner/models"
+)
+
+// collector records the batches submitted to it, or rejects them with
+// the code set.
+type collector struct {
+	collectorpb.UnimplementedBatchCollectorServer
+
+	mu      sync.Mutex
+	batches []*collectorpb.Batch
+	reject  codes.Code
+}
+
+func (c *collector) Submit(_ context.Context, batch *collectorpb.Batch) (*collectorpb.SubmitResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.reject != codes.OK {
+		return nil, status.Error(c.reject, "rejected")
+	}
+	c.batches = append(c.batches, batch)
+
+	return &collectorpb.SubmitResult{Accepted: uint32(len(batch.Transactions))}, nil
+}
+
+// newBufconnSink serves c over an in-memory connection and returns a
+// sink submitting to it.
+func newBufconnSink(t *testing.T, c *collector) *GRPCSink {
+	t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	collectorpb.RegisterBatchCollectorServer(server, c)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	dialer := func(ctx context.Context, _ string) (net.Conn, error) {
+		return listener.DialContext(ctx)
+	}
+	sink, err := NewGRPCSink("passthrough:///bufnet", grpc.WithContextDialer(dialer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sink.Close() })
+
+	return sink
+}
+
+func TestGRPCSinkSubmitsBatch(t *testing.T) {
+	c := &collector{}
+	vali := newTestValidator(t, map[string]float64{"alice": 100, "bob": 50}, WithSink(newBufconnSink(t, c)))
+
+	reference := &Transaction{Transaction: models.Transaction{
+		Fee: models.Fee{Payer: "bob", Amount: 1},
+		Instructions: []models.Instruction{
+			{Account: "bob", Change: map[string]any{"account": "bob", "sign": "minus"}},
+			{Account: "alice", Change: map[string]any{"account": "bob", "sign": "plus"}},
+		},
+	}}
+	batch := []*Transaction{transfer("alice", "bob", 10, 1), reference}
+
+	status, err := vali.SendBatch(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusOK {
+		t.Fatalf("status %d, want 200", status)
+	}
+
+	if len(c.batches) != 1 {
+		t.Fatalf("collector got %d batches, want 1", len(c.batches))
+	}
+	got := c.batches[0]
+	root := BatchRoot(batch)
+	if !bytes.Equal(got.Root, root[:]) {
+		t.Errorf("root %x, want %x", got.Root, root)
+	}
+	if len(got.Transactions) != 2 {
+		t.Fatalf("got %d transactions, want 2", len(got.Transactions))
+	}
+
+	first := got.Transactions[0]
+	if first.Id != batch[0].ID || first.Fee.GetPayer() != "alice" || first.Fee.GetAmount() != 1 {
+		t.Errorf("first transaction %v doesn't match %+v", first, batch[0])
+	}
+	if amount := first.Instructions[0].GetAmount(); amount != -10 {
+		t.Errorf("first change %v, want -10", amount)
+	}
+
+	ref := got.Transactions[1].Instructions[0].GetReference()
+	if ref.GetAccount() != "bob" || ref.GetSign() != collectorpb.Reference_MINUS {
+		t.Errorf("reference %v, want minus bob", ref)
+	}
+}
+
+func TestGRPCSinkSendsDeltas(t *testing.T) {
+	c := &collector{}
+	sink := newBufconnSink(t, c)
+
+	deltas := Deltas{"alice": -11, "bob": 10, "validator": 1}
+	status, err := sink.SendDeltas(deltas)
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("status %d, error %v", status, err)
+	}
+
+	got := c.batches[0]
+	if !got.Compacted || len(got.Transactions) != 0 {
+		t.Errorf("batch %v isn't compacted", got)
+	}
+	for account, delta := range deltas {
+		if got.Deltas[account] != delta {
+			t.Errorf("delta of %s is %v, want %v", account, got.Deltas[account], delta)
+		}
+	}
+}
+
+func TestGRPCSinkRejections(t *testing.T) {
+	tests := []struct {
+		code   codes.Code
+		status int
+		err    bool
+	}{
+		{codes.ResourceExhausted, http.StatusRequestEntityTooLarge, false},
+		{codes.InvalidArgument, http.StatusBadRequest, false},
+		{codes.Unavailable, 0, true},
+	}
+
+	for _, test := range tests {
+		c := &collector{reject: test.code}
+		sink := newBufconnSink(t, c)
+
+		status, err := sink.Send([]*Transaction{transfer("alice", "bob", 1, 1)})
+		if status != test.status || (err != nil) != test.err {
+			t.Errorf("%v: status %d, error %v; want status %d", test.code, status, err, test.status)
+		}
+	}
+}
@@ -34,3 +34,24 @@ func (heap *TransactionHeap) Pop() any {
 	*heap = old[0 : n-1]
 	return item
 }
+
+// removeFunc removes every item fn returns true for, keeping the indexes
+// of the rest right. The heap has to be re-initialized afterwards.
+func (heap *TransactionHeap) removeFunc(fn func(tx *Transaction) bool) []*Transaction {
+	var removed []*Transaction
+	kept := (*heap)[:0]
+	for _, item := range *heap {
+		if fn(item) {
+			item.index = -1 // for safety
+			removed = append(removed, item)
+			continue
+		}
+		item.index = len(kept)
+		kept = append(kept, item)
+	}
+
+	clear((*heap)[len(kept):]) // don't stop the GC from reclaiming removed items
+	*heap = kept
+
+	return removed
+}
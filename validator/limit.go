@@ -0,0 +1,46 @@
+package validator
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// limitListener refuses connections beyond a limit of open ones,
+// by closing them as soon as they're accepted. See WithMaxConnections.
+type limitListener struct {
+	net.Listener
+	max    int64
+	active atomic.Int64
+}
+
+func (ln *limitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := ln.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		if ln.active.Add(1) > ln.max {
+			ln.active.Add(-1)
+			conn.Close()
+			continue
+		}
+
+		return &limitConn{Conn: conn, release: func() { ln.active.Add(-1) }}, nil
+	}
+}
+
+// limitConn gives its slot back to the listener once closed.
+type limitConn struct {
+	net.Conn
+	release   func()
+	closeOnce sync.Once
+}
+
+func (conn *limitConn) Close() error {
+	err := conn.Conn.Close()
+	conn.closeOnce.Do(conn.release)
+
+	return err
+}
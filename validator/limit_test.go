@@ -0,0 +1,76 @@
+package validator
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMaxConnections(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := &limitListener{Listener: inner, max: 2}
+	defer ln.Close()
+
+	accepted := make(chan net.Conn)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				close(accepted)
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	// refused returns true if the listener closes conn right away.
+	refused := func(conn net.Conn) bool {
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		_, err := conn.Read(make([]byte, 1))
+		return errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) || isReset(err)
+	}
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", inner.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+
+	var open []net.Conn
+	for range 2 {
+		dial()
+		open = append(open, <-accepted)
+	}
+
+	// Beyond the limit.
+	if excess := dial(); !refused(excess) {
+		t.Error("connection beyond the limit is kept open")
+	}
+
+	// Closing one frees a slot.
+	open[0].Close()
+	conn := dial()
+	select {
+	case server := <-accepted:
+		defer server.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection within the limit isn't accepted")
+	}
+	if refused(conn) {
+		t.Error("connection within the limit is refused")
+	}
+	open[1].Close()
+}
+
+// isReset returns true if err is the peer resetting the connection.
+func isReset(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && !opErr.Timeout()
+}
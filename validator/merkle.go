@@ -0,0 +1,111 @@
+package validator
+
+import (
+	"crypto/sha256"
+)
+
+// Leaves and inner nodes are hashed with different prefixes,
+// so that an inner node can't be passed off as a transaction.
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+)
+
+// ProofStep is a sibling hash on the path from a transaction
+// up to the Merkle root of its batch.
+type ProofStep struct {
+	Hash [32]byte
+	Left bool // Sibling is on the left of the path.
+}
+
+// BatchRoot returns the Merkle root over the hashes of transactions
+// in the batch, in order. Unpaired nodes are carried up a level as is.
+// The root of an empty batch is all zeros.
+func BatchRoot(batch []*Transaction) [32]byte {
+	if len(batch) == 0 {
+		return [32]byte{}
+	}
+
+	level := merkleLeaves(batch)
+	for len(level) > 1 {
+		level = merkleLevel(level)
+	}
+
+	return level[0]
+}
+
+// InclusionProof returns the proof that the transaction at given index
+// is in the batch, see VerifyInclusion. Returns false if the index is
+// out of range.
+func InclusionProof(batch []*Transaction, index int) ([]ProofStep, bool) {
+	if index < 0 || index >= len(batch) {
+		return nil, false
+	}
+
+	var proof []ProofStep
+	level := merkleLeaves(batch)
+	for len(level) > 1 {
+		sibling := index ^ 1
+		if sibling < len(level) {
+			proof = append(proof, ProofStep{Hash: level[sibling], Left: sibling < index})
+		}
+
+		level = merkleLevel(level)
+		index /= 2
+	}
+
+	return proof, true
+}
+
+// VerifyInclusion returns true if the proof shows that
+// the transaction is in the batch with given root.
+func VerifyInclusion(root [32]byte, tx *Transaction, proof []ProofStep) bool {
+	hash := merkleLeaf(tx)
+	for _, step := range proof {
+		if step.Left {
+			hash = merkleNode(step.Hash, hash)
+		} else {
+			hash = merkleNode(hash, step.Hash)
+		}
+	}
+
+	return hash == root
+}
+
+func merkleLeaves(batch []*Transaction) [][32]byte {
+	leaves := make([][32]byte, 0, len(batch))
+	for _, tx := range batch {
+		leaves = append(leaves, merkleLeaf(tx))
+	}
+
+	return leaves
+}
+
+// merkleLevel hashes the nodes of a level pairwise into the next one.
+func merkleLevel(level [][32]byte) [][32]byte {
+	next := make([][32]byte, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			next = append(next, level[i])
+			break
+		}
+
+		next = append(next, merkleNode(level[i], level[i+1]))
+	}
+
+	return next
+}
+
+func merkleLeaf(tx *Transaction) [32]byte {
+	hash := tx.Hash()
+	return sha256.Sum256(append([]byte{merkleLeafPrefix}, hash[:]...))
+}
+
+func merkleNode(left, right [32]byte) [32]byte {
+	buffer := make([]byte, 0, 1+2*sha256.Size)
+	buffer = append(buffer, merkleNodePrefix)
+	buffer = append(buffer, left[:]...)
+	buffer = append(buffer, right[:]...)
+
+	return sha256.Sum256(buffer)
+}
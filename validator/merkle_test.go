@@ -0,0 +1,70 @@
+package validator
+
+import "testing"
+
+func TestInclusionProof(t *testing.T) {
+	outsider := transfer("mallory", "bob", 1, 1)
+
+	// Odd sizes leave nodes unpaired on some levels.
+	for size := 1; size <= 9; size++ {
+		batch := make([]*Transaction, size)
+		for i := range batch {
+			batch[i] = transfer("alice", "bob", float64(i+1), 1)
+		}
+		root := BatchRoot(batch)
+
+		for i, tx := range batch {
+			proof, ok := InclusionProof(batch, i)
+			if !ok {
+				t.Fatalf("size %d: no proof for index %d", size, i)
+			}
+			if !VerifyInclusion(root, tx, proof) {
+				t.Errorf("size %d: proof for index %d doesn't verify", size, i)
+			}
+			if VerifyInclusion(root, outsider, proof) {
+				t.Errorf("size %d: proof for index %d verifies a transaction not in the batch", size, i)
+			}
+			if size > 1 && VerifyInclusion(root, batch[(i+1)%size], proof) {
+				t.Errorf("size %d: proof for index %d verifies another transaction", size, i)
+			}
+			if VerifyInclusion(BatchRoot(batch[:size-1]), tx, proof) {
+				t.Errorf("size %d: proof for index %d verifies against another root", size, i)
+			}
+		}
+
+		if _, ok := InclusionProof(batch, size); ok {
+			t.Errorf("size %d: got a proof for an index out of range", size)
+		}
+	}
+
+	if root := BatchRoot(nil); root != [32]byte{} {
+		t.Errorf("root of an empty batch is %x", root)
+	}
+}
+
+func TestCommitHookRoot(t *testing.T) {
+	var roots [][32]byte
+	var batches [][]*Transaction
+	vali := newTestValidator(t, map[string]float64{"alice": 100}, WithSink(&recordingSink{}),
+		WithCommitHook(func(_ uint64, batch []*Transaction, root [32]byte) {
+			batches = append(batches, batch)
+			roots = append(roots, root)
+		}))
+
+	vali.PushTransaction(transfer("alice", "bob", 1, 1))
+	vali.PushTransaction(transfer("alice", "carol", 2, 1))
+	_, err := vali.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(roots) != 1 || len(batches[0]) != 2 {
+		t.Fatalf("hook called with %d batch(es)", len(roots))
+	}
+	for i, tx := range batches[0] {
+		proof, _ := InclusionProof(batches[0], i)
+		if !VerifyInclusion(roots[0], tx, proof) {
+			t.Errorf("transaction %d isn't in the batch by the hook's root", i)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package validator
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// metrics is a tiny registry of named counters and histograms.
+// Names follow Prometheus conventions, labels included,
+// so they can be exported as they are.
+type metrics struct {
+	mu         sync.Mutex
+	counters   map[string]uint64
+	histograms map[string]*Histogram
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		counters:   make(map[string]uint64),
+		histograms: make(map[string]*Histogram),
+	}
+}
+
+// inc increments the named counter by one.
+func (m *metrics) inc(name string) {
+	m.add(name, 1)
+}
+
+// add increments the named counter by n.
+func (m *metrics) add(name string, n uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.counters[name] += n
+}
+
+// counter returns the current value of the named counter.
+func (m *metrics) counter(name string) uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.counters[name]
+}
+
+// Histogram is a distribution of observed values, bucketed
+// the way Prometheus does.
+type Histogram struct {
+	Bounds []float64 // Upper bounds of buckets, ascending.
+	Counts []uint64  // Observations less than or equal to each bound.
+	Count  uint64    // Number of observations.
+	Sum    float64   // Sum of observations.
+}
+
+// observe records a value in the named histogram. Bounds are only
+// used the first time, creating the histogram.
+func (m *metrics) observe(name string, bounds []float64, value float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.histograms[name]
+	if !ok {
+		h = &Histogram{Bounds: bounds, Counts: make([]uint64, len(bounds))}
+		m.histograms[name] = h
+	}
+
+	for i, bound := range h.Bounds {
+		if value <= bound {
+			h.Counts[i]++
+		}
+	}
+	h.Count++
+	h.Sum += value
+}
+
+// histogram returns a copy of the named histogram,
+// the zero value if nothing's been observed yet.
+func (m *metrics) histogram(name string) Histogram {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.histograms[name]
+	if !ok {
+		return Histogram{}
+	}
+
+	return h.clone()
+}
+
+func (h *Histogram) clone() Histogram {
+	return Histogram{
+		Bounds: slices.Clone(h.Bounds),
+		Counts: slices.Clone(h.Counts),
+		Count:  h.Count,
+		Sum:    h.Sum,
+	}
+}
+
+// Histogram of the number of transactions in committed batches.
+const batchSizeSeries = "validator_batch_size"
+
+// batchSizeBounds returns bucket bounds for batch sizes,
+// powers of two up to the max batch size.
+func (vali *Validator) batchSizeBounds() []float64 {
+	var bounds []float64
+	for bound := 1; bound < vali.batchSize; bound *= 2 {
+		bounds = append(bounds, float64(bound))
+	}
+
+	return append(bounds, float64(vali.batchSize))
+}
+
+// BatchSizes returns the distribution of committed batch sizes.
+// Batches well below the max size point at either low load or
+// transactions conflicting with each other.
+func (vali *Validator) BatchSizes() Histogram {
+	return vali.metrics.histogram(batchSizeSeries)
+}
+
+// Counter of failed reads from the UDP socket, other than the ones
+// caused by closing the validator.
+const udpReadErrorsSeries = "udp_read_errors_total"
+
+// UDPReadErrors returns how many reads from the UDP socket have failed.
+func (vali *Validator) UDPReadErrors() uint64 {
+	return vali.metrics.counter(udpReadErrorsSeries)
+}
+
+// Counter of batches split in halves for being too large for the sink.
+const batchSplitsSeries = "batch_splits_total"
+
+// BatchSplits returns how many times a batch the sink found too large
+// was split in halves to be sent again.
+func (vali *Validator) BatchSplits() uint64 {
+	return vali.metrics.counter(batchSplitsSeries)
+}
+
+// Counter of batches vetoed by the batch validator.
+const vetoedBatchesSeries = "vetoed_batches_total"
+
+// VetoedBatches returns how many batches the batch validator has vetoed.
+func (vali *Validator) VetoedBatches() uint64 {
+	return vali.metrics.counter(vetoedBatchesSeries)
+}
+
+// Counter of snapshots that failed to be written.
+const snapshotFailuresSeries = "snapshot_failures_total"
+
+// SnapshotFailures returns how many snapshots have failed to be written.
+func (vali *Validator) SnapshotFailures() uint64 {
+	return vali.metrics.counter(snapshotFailuresSeries)
+}
+
+// Counter of batches built but not committed in dry run.
+const dryRunBatchesSeries = "dry_run_batches_total"
+
+// DropReason describes why a transaction was left out of a batch.
+type DropReason string
+
+const (
+	// ReasonMalformed: transaction couldn't be decoded.
+	ReasonMalformed DropReason = "malformed"
+	// ReasonInvalid: transaction is decoded but carries invalid values.
+	ReasonInvalid DropReason = "invalid"
+	// ReasonMinFee: transaction pays less than its type requires.
+	ReasonMinFee DropReason = "min_fee"
+	// ReasonMaxFee: transaction pays more than allowed.
+	ReasonMaxFee DropReason = "max_fee"
+	// ReasonFeeCheck: payer doesn't exist or can't afford the fee.
+	ReasonFeeCheck DropReason = "fee_check"
+	// ReasonExecution: transaction fails to execute, fee is charged anyway
+	// unless disabled by WithChargeFeeOnFailure.
+	ReasonExecution DropReason = "execution_failed"
+	// ReasonNonCommutative: transaction conflicts with the batch being built.
+	// Such transactions are deferred to a later batch rather than dropped.
+	ReasonNonCommutative DropReason = "non_commutative"
+	// ReasonDuplicate: an identical transaction is already in the batch.
+	// Such transactions are deferred to a later batch rather than dropped.
+	ReasonDuplicate DropReason = "duplicate"
+	// ReasonPayerQuota: payer already has as many transactions in the batch
+	// as allowed. Such transactions are deferred to a later batch.
+	ReasonPayerQuota DropReason = "payer_quota"
+	// ReasonPendingFull: there are already as many pending transactions as allowed.
+	ReasonPendingFull DropReason = "pending_full"
+	// ReasonPendingBytes: pending transactions already take as many bytes as allowed.
+	ReasonPendingBytes DropReason = "pending_bytes"
+	// ReasonMiddleware: transaction middleware returned an error.
+	ReasonMiddleware DropReason = "middleware"
+	// ReasonFrozen: transaction debits a frozen account.
+	ReasonFrozen DropReason = "frozen"
+	// ReasonSelfTransfer: transaction only moves balance from an account to itself.
+	ReasonSelfTransfer DropReason = "self_transfer"
+	// ReasonAccountName: transaction names an account the account validator rejects.
+	ReasonAccountName DropReason = "account_name"
+	// ReasonTooComplex: transaction costs more to evaluate than the budget.
+	ReasonTooComplex DropReason = "too_complex"
+	// ReasonVetoed: batch validator vetoed the batch of the transaction.
+	// Such transactions are pending again unless the veto policy is VetoDrop.
+	ReasonVetoed DropReason = "vetoed"
+	// ReasonUntrustedPriority: transaction asks for priority but isn't verified to be trusted.
+	ReasonUntrustedPriority DropReason = "untrusted_priority"
+	// ReasonTooManyAccounts: transaction touches more distinct accounts than allowed.
+	ReasonTooManyAccounts DropReason = "too_many_accounts"
+	// ReasonDropped: transaction was pending when its payer's were dropped,
+	// see DropPending.
+	ReasonDropped DropReason = "dropped"
+	// ReasonUnsent: transaction is committed, but its batch couldn't be sent
+	// on shutdown. Only used for dead letters, see ShutdownDeadLetter.
+	ReasonUnsent DropReason = "unsent"
+	// ReasonExpired: transaction was still deferred when it got older than
+	// allowed, see WithMaxPendingAge.
+	ReasonExpired DropReason = "expired"
+	// ReasonRetriesExhausted: transaction was deferred as not commutative
+	// more times than allowed, see WithMaxDeferrals.
+	ReasonRetriesExhausted DropReason = "retries_exhausted"
+)
+
+// rejectedSeries returns the counter name for given reason.
+func rejectedSeries(reason DropReason) string {
+	return fmt.Sprintf(`validator_rejected_total{reason="%s"}`, reason)
+}
+
+// reject counts a transaction rejected for given reason.
+func (vali *Validator) reject(reason DropReason) {
+	vali.metrics.inc(rejectedSeries(reason))
+}
+
+// Rejections returns how many times transactions were
+// rejected for given reason.
+func (vali *Validator) Rejections(reason DropReason) uint64 {
+	return vali.metrics.counter(rejectedSeries(reason))
+}
+
+// FeeRejectionRatio returns the share of fee check rejections among
+// fee check and commutativity rejections, in range [0, 1].
+//
+// Values close to 1 indicate payers are underfunded, values close to 0
+// indicate transactions conflict with each other too much.
+// Returns 0 if nothing has been rejected for either reason yet.
+func (vali *Validator) FeeRejectionRatio() float64 {
+	fee := vali.Rejections(ReasonFeeCheck)
+	conflict := vali.Rejections(ReasonNonCommutative)
+	if fee+conflict == 0 {
+		return 0
+	}
+
+	return float64(fee) / float64(fee+conflict)
+}
+
+// Number of recent batches fees are remembered for.
+const feeHistorySize = 1024
+
+// batchFees is the total fee of a committed batch.
+type batchFees struct {
+	batchIdx uint64
+	fees     float64
+	ok       bool
+}
+
+// recordFees remembers the total fee of a committed batch.
+func (vali *Validator) recordFees(batchIdx uint64, batch []*Transaction) {
+	var fees float64
+	for _, tx := range batch {
+		fees += tx.Fee.Amount
+	}
+
+	vali.feesMu.Lock()
+	defer vali.feesMu.Unlock()
+
+	vali.fees[batchIdx%feeHistorySize] = batchFees{batchIdx: batchIdx, fees: fees, ok: true}
+}
+
+// FeesForBatch returns the total fee earned from transactions of the
+// batch with given index. Fees charged for failed transactions aren't
+// included. Only the most recent batches are remembered, ok is false
+// if the batch is too old or hasn't been committed.
+func (vali *Validator) FeesForBatch(batchIdx uint64) (float64, bool) {
+	vali.feesMu.Lock()
+	defer vali.feesMu.Unlock()
+
+	record := vali.fees[batchIdx%feeHistorySize]
+	if !record.ok || record.batchIdx != batchIdx {
+		return 0, false
+	}
+
+	return record.fees, true
+}
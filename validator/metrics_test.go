@@ -0,0 +1,134 @@
+package validator
+
+import (
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/benbjohnson/clock"
+)
+
+func TestFeeCheckAndCommutativityRejections(t *testing.T) {
+	// Carol has nothing to pay fees with, bob can pay for only one of
+	// his transfers per batch.
+	vali := newTestValidator(t, map[string]float64{"alice": 0, "bob": 11, "carol": 0})
+
+	receive(t, vali, transfer("carol", "alice", 1, 1))
+	receive(t, vali, transfer("bob", "alice", 5, 1))
+	receive(t, vali, transfer("bob", "alice", 5, 2))
+	receive(t, vali, transfer("bob", "alice", 5, 3))
+
+	vali.buildBatch()
+
+	if n := vali.Rejections(ReasonFeeCheck); n != 1 {
+		t.Errorf("%d fee check rejection(s), want 1", n)
+	}
+	if n := vali.Rejections(ReasonNonCommutative); n != 2 {
+		t.Errorf("%d commutativity rejection(s), want 2", n)
+	}
+	if ratio := vali.FeeRejectionRatio(); ratio != 1.0/3 {
+		t.Errorf("fee rejection ratio is %v, want 1/3", ratio)
+	}
+
+	recorder := httptest.NewRecorder()
+	vali.handleMetrics(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body := recorder.Body.String()
+	for _, line := range []string{
+		`validator_rejected_total{reason="fee_check"} 1`,
+		`validator_rejected_total{reason="non_commutative"} 2`,
+		"validator_fee_rejection_ratio 0.3333333333333333",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics lack %q", line)
+		}
+	}
+}
+
+func TestFeeRejectionRatioWithoutRejections(t *testing.T) {
+	vali := newTestValidator(t, map[string]float64{})
+	if ratio := vali.FeeRejectionRatio(); ratio != 0 {
+		t.Errorf("fee rejection ratio is %v, want 0", ratio)
+	}
+}
+
+func TestBatchSizes(t *testing.T) {
+	vali := newTestValidator(t, map[string]float64{"alice": 1000}, WithBatchSize(8), WithSink(&recordingSink{}))
+
+	for _, size := range []int{1, 3, 8} {
+		for i := range size {
+			vali.PushTransaction(transfer("alice", "bob", float64(i+1), 1))
+		}
+		batch, err := vali.Flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(batch) != size {
+			t.Fatalf("committed a batch of %d, want %d", len(batch), size)
+		}
+	}
+
+	h := vali.BatchSizes()
+	want := Histogram{Bounds: []float64{1, 2, 4, 8}, Counts: []uint64{1, 1, 2, 3}, Count: 3, Sum: 12}
+	if !slices.Equal(h.Bounds, want.Bounds) || !slices.Equal(h.Counts, want.Counts) || h.Count != want.Count || h.Sum != want.Sum {
+		t.Errorf("got %+v, want %+v", h, want)
+	}
+
+	recorder := httptest.NewRecorder()
+	vali.handleMetrics(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	for _, line := range []string{
+		`validator_batch_size_bucket{le="4"} 2`,
+		`validator_batch_size_bucket{le="+Inf"} 3`,
+		`validator_batch_size_sum 12`,
+		`validator_batch_size_count 3`,
+	} {
+		if !strings.Contains(recorder.Body.String(), line+"\n") {
+			t.Errorf("metrics lack %q", line)
+		}
+	}
+}
+
+func TestFeesForBatch(t *testing.T) {
+	// The mock clock stays at the zero time, where sends aren't rate limited.
+	vali := newTestValidator(t, map[string]float64{"alice": 1000, "bob": 100},
+		WithClock(clock.NewMock()), WithSink(&recordingSink{}))
+
+	var batches [][]*Transaction
+	for i := range 3 {
+		vali.PushTransaction(transfer("alice", "carol", 1, float64(i+1)))
+		vali.PushTransaction(transfer("bob", "carol", 1, 0.5))
+		batch, err := vali.Flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+		batches = append(batches, batch)
+	}
+
+	for i, batch := range batches {
+		var want float64
+		for _, tx := range batch {
+			want += tx.Fee.Amount
+		}
+
+		fees, ok := vali.FeesForBatch(uint64(i))
+		if !ok || fees != want {
+			t.Errorf("batch %d: got fees %v (%v), want %v", i, fees, ok, want)
+		}
+	}
+
+	if _, ok := vali.FeesForBatch(3); ok {
+		t.Error("got fees of a batch that isn't committed")
+	}
+
+	// Old batches are forgotten.
+	for i := range feeHistorySize {
+		vali.PushTransaction(transfer("alice", "carol", 0.01, float64(i)/1000))
+		batch, err := vali.Flush()
+		if err != nil || len(batch) != 1 {
+			t.Fatalf("committed %d transaction(s), error %v", len(batch), err)
+		}
+	}
+	if _, ok := vali.FeesForBatch(0); ok {
+		t.Error("got fees of a batch too old to be remembered")
+	}
+}
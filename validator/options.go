@@ -0,0 +1,729 @@
+package validator
+
+import (
+	"math/rand/v2"
+	"time"
+	adb "transactioner/accountsdb"
+
+	"github.com/benbjohnson/clock"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Option configures optional behaviour of a validator.
+type Option func(*Validator)
+
+// SelectionPolicy decides in which order pending transactions
+// are considered while building a batch.
+type SelectionPolicy int
+
+const (
+	// ScorePriority picks transactions with the highest score first.
+	ScorePriority SelectionPolicy = iota
+	// FIFO picks transactions in the order they've arrived,
+	// so low-fee transactions can't be starved.
+	FIFO
+)
+
+// WithSelectionPolicy sets the order transactions are batched in.
+// Defaults to ScorePriority.
+func WithSelectionPolicy(policy SelectionPolicy) Option {
+	return func(vali *Validator) {
+		vali.policy = policy
+	}
+}
+
+// WithAccountNormalizer sets a function applied on every account name,
+// both in the db and in received transactions. Defaults to identity.
+// Transaction IDs are derived from names as received, see
+// models.Transaction.ID. See accountsdb.TrimLower for a common normalizer.
+func WithAccountNormalizer(normalize func(string) string) Option {
+	return func(vali *Validator) {
+		vali.normalize = normalize
+	}
+}
+
+// WithStrictDecoding makes the validator reject transactions carrying
+// unknown fields (e.g. a misspelled "fees"), instead of silently
+// decoding them with zero values. Disabled by default.
+func WithStrictDecoding(strict bool) Option {
+	return func(vali *Validator) {
+		vali.strict = strict
+	}
+}
+
+// WithMaxPerPayerPerBatch caps how many transactions of a single payer
+// can be included in one batch, so that a payer flooding the validator
+// with high-fee transactions can't starve others. The rest is deferred
+// to later batches. Zero means no limit, which is the default.
+func WithMaxPerPayerPerBatch(n int) Option {
+	return func(vali *Validator) {
+		vali.maxPerPayer = n
+	}
+}
+
+// WithIngestBuffer sets how many received transactions can wait for
+// the processor before the receiver blocks. Defaults to 256.
+func WithIngestBuffer(n int) Option {
+	return func(vali *Validator) {
+		vali.ingestBuffer = n
+	}
+}
+
+// WithMaxPending caps the number of transactions waiting to be batched,
+// transactions received beyond that are dropped. Zero means no limit,
+// which is the default.
+//
+// Pending transactions are pre-allocated for either this or the ingest
+// buffer size, whichever is set, so that steady state ingest doesn't
+// keep reallocating.
+func WithMaxPending(n int) Option {
+	return func(vali *Validator) {
+		vali.maxPending = n
+	}
+}
+
+// WithQueryAddr makes Run serve the query API over given address
+// (e.g. ":2003"). The query API is disabled by default.
+func WithQueryAddr(addr string) Option {
+	return func(vali *Validator) {
+		vali.queryAddr = addr
+	}
+}
+
+// WithPrettySnapshots makes snapshots human-readable by putting each
+// account on its own line. Snapshots are compact by default.
+func WithPrettySnapshots(pretty bool) Option {
+	return func(vali *Validator) {
+		vali.prettySnapshots = pretty
+	}
+}
+
+// WithIdleBackoff sets how long the processor waits before retrying when
+// none of the pending transactions could be put in a batch, unless a new
+// transaction arrives meanwhile. Defaults to 10ms.
+func WithIdleBackoff(d time.Duration) Option {
+	return func(vali *Validator) {
+		vali.idleBackoff = d
+	}
+}
+
+// WithClock sets the source of time used for timers, rate limiting and
+// snapshot names. Mostly useful to control time in tests.
+func WithClock(clock clock.Clock) Option {
+	return func(vali *Validator) {
+		vali.clock = clock
+	}
+}
+
+// WithSnapshotRetention makes the validator keep only the n latest
+// snapshot files in the working directory, removing older ones once a
+// new one is written. Snapshots are ordered by the time and batch index
+// they're named after, see ParseSnapshotName. All are kept by default.
+func WithSnapshotRetention(n int) Option {
+	return func(vali *Validator) {
+		vali.snapshotRetention = n
+	}
+}
+
+// WithSnapshotJitter randomizes each snapshot interval within ±jitter,
+// so that validators started together don't hit the disk at the same
+// moment. No jitter by default.
+func WithSnapshotJitter(jitter time.Duration) Option {
+	return func(vali *Validator) {
+		vali.snapshotJitter = jitter
+	}
+}
+
+// TypeConfig overrides how transactions of a type are handled.
+type TypeConfig struct {
+	MinFee float64   // Transactions paying less are dropped.
+	Score  ScoreFunc // Scores transactions of the type, default scorer if nil.
+}
+
+// WithTypeConfig sets per-type handling of transactions, keyed by
+// transaction type. Transactions of other types, or without a type,
+// have no min fee and are scored by the default scorer (see SetScoreFunc).
+func WithTypeConfig(types map[string]TypeConfig) Option {
+	return func(vali *Validator) {
+		vali.types = types
+	}
+}
+
+// WithSink sets where batches are sent to. Defaults to an HTTPSink
+// configured by WithBatchEndpoint and WithBatchMethod.
+func WithSink(sink Sink) Option {
+	return func(vali *Validator) {
+		vali.sink = sink
+	}
+}
+
+// WithGRPCEndpoint makes the validator send batches to a BatchCollector
+// service at given address over gRPC, e.g. "collector:2003", rather than
+// over HTTP. See GRPCSink. Ignored if a sink is set by WithSink.
+func WithGRPCEndpoint(addr string) Option {
+	return func(vali *Validator) {
+		vali.grpcEndpoint = addr
+	}
+}
+
+// WithAllowUnbalanced relaxes the rule that a transaction's instruction
+// changes must sum to zero. With mint, transactions adding more than they
+// take are accepted; with burn, transactions taking more than they add are.
+// Either way the validator account absorbs the difference: it pays for
+// what's minted and receives what's burnt. Both are rejected by default.
+func WithAllowUnbalanced(mint bool, burn bool) Option {
+	return func(vali *Validator) {
+		vali.allowMint = mint
+		vali.allowBurn = burn
+	}
+}
+
+// WithMaxPendingBytes caps the total size of transactions waiting to be
+// batched, estimated by their size as received. Transactions received
+// beyond that are dropped, however many are pending. Zero means no limit,
+// which is the default.
+func WithMaxPendingBytes(n int) Option {
+	return func(vali *Validator) {
+		vali.maxPendingBytes = n
+	}
+}
+
+// WithBatchEndpoint sets the URL batches are sent to, path included
+// (e.g. "http://collector:2002/v1/batches").
+// Defaults to http://localhost:2002/.
+func WithBatchEndpoint(url string) Option {
+	return func(vali *Validator) {
+		vali.batchEndpoint = url
+	}
+}
+
+// WithBatchMethod sets the HTTP method batches are sent with, which must
+// be a standard HTTP method. Defaults to POST.
+func WithBatchMethod(method string) Option {
+	return func(vali *Validator) {
+		vali.batchMethod = method
+	}
+}
+
+// WithStrictConservation makes the validator check that committing a
+// batch, or charging fees of failed transactions, leaves total supply
+// unchanged. Violations are handled per WithConservationAction.
+// Disabled by default since it sums every balance on each commit.
+func WithStrictConservation(strict bool) Option {
+	return func(vali *Validator) {
+		vali.strictConservation = strict
+	}
+}
+
+// WithConservationAction sets what happens when strict conservation
+// is violated. Defaults to ConservationPanic.
+func WithConservationAction(action ConservationAction) Option {
+	return func(vali *Validator) {
+		vali.conservationAction = action
+	}
+}
+
+// WithSnapshotFetchTimeout sets how long fetching the initial snapshot
+// may take when it's given as a URL. Defaults to 30 seconds.
+func WithSnapshotFetchTimeout(timeout time.Duration) Option {
+	return func(vali *Validator) {
+		vali.snapshotFetchTimeout = timeout
+	}
+}
+
+// WithDryRun makes the validator receive and batch transactions as
+// usual, but only log what it would commit rather than committing or
+// sending anything; the db is never changed by processing. Useful for
+// shadow deployments. Disabled by default.
+func WithDryRun(dryRun bool) Option {
+	return func(vali *Validator) {
+		vali.dryRun = dryRun
+	}
+}
+
+// WithPartitionedProcessing builds up to lanes batches at once rather
+// than one at a time. Pending transactions are split into lanes by the
+// accounts they touch, transactions sharing an account always going to
+// the same lane, and a batch is built out of every lane concurrently.
+// Batches are still committed and sent one after another, in lane order.
+// With minting allowed, every transaction may take from the validator
+// account, which ends up putting them all in one lane. Ignored in
+// sequential mode. Custom conflict detectors must be safe for concurrent
+// use. Disabled by default.
+func WithPartitionedProcessing(lanes int) Option {
+	return func(vali *Validator) {
+		vali.lanes = lanes
+	}
+}
+
+// WithBatchSize sets the max number of transactions in a batch.
+// Defaults to 100.
+func WithBatchSize(n int) Option {
+	return func(vali *Validator) {
+		vali.batchSize = n
+	}
+}
+
+// WithCandidateWindow makes the validator collect up to n commutative
+// candidates per batch, of which only the highest scored ones, up to
+// the batch size, are committed and sent; under FIFO, the earliest
+// arrived ones are. The rest is deferred to later batches. Windows
+// smaller than the batch size have no effect, which is the default.
+func WithCandidateWindow(n int) Option {
+	return func(vali *Validator) {
+		vali.candidateWindow = n
+	}
+}
+
+// WithBatchedReads makes the validator drain every datagram that's ready
+// on the socket per wakeup, instead of going through the runtime poller
+// for each one. This saves a good deal of overhead under heavy ingest.
+// Only effective on Linux, elsewhere it's the same as the default,
+// which is reading one datagram at a time.
+func WithBatchedReads(batched bool) Option {
+	return func(vali *Validator) {
+		vali.batchedReads = batched
+	}
+}
+
+// Middleware transforms a decoded and validated transaction before it's
+// scored, e.g. to attach metadata or rewrite accounts. It either returns
+// the transaction to carry on with, which may be a different one, or
+// an error to drop it.
+type Middleware func(*Transaction) (*Transaction, error)
+
+// WithTransactionMiddleware sets a middleware every received transaction
+// goes through. Transactions it rejects are counted with ReasonMiddleware.
+// The ID of the returned transaction is derived again from its content.
+func WithTransactionMiddleware(middleware Middleware) Option {
+	return func(vali *Validator) {
+		vali.middleware = middleware
+	}
+}
+
+// WithRand sets the source every randomized behaviour draws from,
+// e.g. snapshot jitter. Two validators given sources with the same seed
+// behave the same, which makes runs reproducible. Defaults to a source
+// seeded by the current time.
+func WithRand(r *rand.Rand) Option {
+	return func(vali *Validator) {
+		vali.rand = r
+	}
+}
+
+// WithListenAddr sets the UDP address transactions are received over,
+// e.g. "127.0.0.1:0" to let the system pick a free port, see Addr.
+// Defaults to ":2001".
+func WithListenAddr(addr string) Option {
+	return func(vali *Validator) {
+		vali.listenAddr = addr
+	}
+}
+
+// WithRejectSelfTransfers makes the validator reject transactions moving
+// balance from an account to itself, e.g. an instruction referencing the
+// payer's balance or +5 and -5 on the same account. They're no-ops that
+// take up batch slots anyway. Disabled by default.
+func WithRejectSelfTransfers(reject bool) Option {
+	return func(vali *Validator) {
+		vali.rejectSelfTransfers = reject
+	}
+}
+
+// WithUDPAck makes the validator answer every transaction received over
+// UDP with a datagram to its sender, telling whether it's accepted along
+// with its ID, or why it's rejected. It's the same JSON POST /submit
+// responds with. Disabled by default.
+func WithUDPAck(ack bool) Option {
+	return func(vali *Validator) {
+		vali.udpAck = ack
+	}
+}
+
+// CommitHook is called after a batch is committed, with the index of the
+// batch, its transactions and their Merkle root, see BatchRoot.
+type CommitHook func(batchIdx uint64, batch []*Transaction, root [32]byte)
+
+// WithCommitHook sets a function called after each batch commit, e.g. to
+// publish Merkle roots for auditing. It's called before the batch is sent.
+func WithCommitHook(hook CommitHook) Option {
+	return func(vali *Validator) {
+		vali.commitHook = hook
+	}
+}
+
+// ShutdownSendPolicy decides what happens to batches that are to be sent
+// after the validator is closed, rather than waiting for the rate limit.
+type ShutdownSendPolicy int
+
+const (
+	// ShutdownFlush sends the remaining batches right away,
+	// ignoring the rate limit.
+	ShutdownFlush ShutdownSendPolicy = iota
+	// ShutdownAbandon stops processing, batches not sent yet are dropped.
+	ShutdownAbandon
+	// ShutdownDeadLetter sends the remaining batches like ShutdownFlush,
+	// but transactions of batches that fail to be sent are written to the
+	// dead-letter file, with the index of the batch they're committed in,
+	// so they can be reconciled with the collector. See WithDeadLetterFile.
+	ShutdownDeadLetter
+)
+
+// WithShutdownSendPolicy sets what's done with sends once the validator is
+// closed, e.g. by cancelling the context given to RunContext. Either way,
+// shutdown never waits for the rate limit. Defaults to ShutdownFlush.
+func WithShutdownSendPolicy(policy ShutdownSendPolicy) Option {
+	return func(vali *Validator) {
+		vali.shutdownSendPolicy = policy
+	}
+}
+
+// WithDeadLetterFile makes the validator append transactions it drops for
+// good to given file, one JSON object per line with the drop reason and
+// time, so they can be inspected and replayed. Messages that couldn't be
+// decoded are written as received. Deferred transactions only end up
+// there once they expire or run out of retries, see WithMaxPendingAge and
+// WithMaxDeferrals. Transactions dropped after the validator is closed
+// aren't written, other than the ones of batches failing to be sent under
+// ShutdownDeadLetter. Disabled by default.
+func WithDeadLetterFile(path string) Option {
+	return func(vali *Validator) {
+		vali.deadLetterFile = path
+	}
+}
+
+// WithMaxPendingAge drops transactions that are still deferred to a later
+// batch this long after they were first made pending, as expired. Zero
+// means they're kept pending however long it takes, which is the default.
+func WithMaxPendingAge(age time.Duration) Option {
+	return func(vali *Validator) {
+		vali.maxPendingAge = age
+	}
+}
+
+// WithMaxDeferrals drops transactions once they've been deferred as not
+// commutative with the batch being built n times, rather than retrying
+// them in later batches forever. Zero means no limit, which is the default.
+func WithMaxDeferrals(n int) Option {
+	return func(vali *Validator) {
+		vali.maxDeferrals = n
+	}
+}
+
+// WithSequentialMode makes the validator commit transactions one at a
+// time, strictly in the selection order: every batch carries a single
+// transaction, and one that can't execute against the current state
+// fails rather than being deferred. It's much slower, but trivially correct,
+// which makes it a good oracle to compare the batched path against.
+// Disabled by default.
+func WithSequentialMode(sequential bool) Option {
+	return func(vali *Validator) {
+		vali.sequential = sequential
+	}
+}
+
+// WithAccountsStore makes the db keep balances in given store, e.g. one
+// persisting them across restarts, see accountsdb/boltstore. If the store
+// already has accounts they're used as they are and the snapshot is
+// ignored, otherwise the store is seeded from the snapshot.
+func WithAccountsStore(store adb.Store) Option {
+	return func(vali *Validator) {
+		vali.store = store
+	}
+}
+
+// WithBatchCoalescing makes the validator hold committed batches back
+// while sending is rate limited, merging them up to the batch size, so
+// fewer and larger batches are sent. Batches with a transaction in common
+// are never merged. Held batches are sent once the validator is idle.
+// A RangeSink is told the indexes of the batches merged.
+// Disabled by default.
+func WithBatchCoalescing(coalesce bool) Option {
+	return func(vali *Validator) {
+		vali.coalesce = coalesce
+	}
+}
+
+// WithMaxFee sets the highest fee a transaction can pay, so that one with
+// an absurd fee, likely a client bug, is rejected rather than draining
+// its payer. Zero means no limit, which is the default.
+func WithMaxFee(amount float64) Option {
+	return func(vali *Validator) {
+		vali.maxFee = amount
+	}
+}
+
+// WithChargeFeeOnFailure sets whether the fee is taken from transactions
+// that fail to execute, e.g. ones with a non-zero instruction sum, as
+// long as the payer can afford it. If not, such transactions are dropped
+// without any charge. Enabled by default.
+func WithChargeFeeOnFailure(charge bool) Option {
+	return func(vali *Validator) {
+		vali.chargeFeeOnFailure = charge
+	}
+}
+
+// WithBatchCompaction makes the validator send only the net change of
+// every account a batch touches, rather than its transactions, which is
+// far less for downstream to process. Transactions are still committed
+// one by one locally. This changes what's sent: the sink must be a
+// DeltaSink, HTTPSink sends {"account": change, ...} objects with the
+// X-Batch-Compacted header set. Disabled by default.
+func WithBatchCompaction(compact bool) Option {
+	return func(vali *Validator) {
+		vali.compaction = compact
+	}
+}
+
+// WithRescoreInterval makes the validator re-evaluate pending transactions
+// against the current balances every given duration, dropping the ones
+// whose payer can't pay the fee anymore before they reach batching.
+// Transactions queued for long are otherwise only checked once they're
+// popped for a batch. Zero disables it, which is the default.
+func WithRescoreInterval(d time.Duration) Option {
+	return func(vali *Validator) {
+		vali.rescoreInterval = d
+	}
+}
+
+// WithConflictDetector sets what decides which transactions can't share
+// a batch, replacing the default BalanceConflictDetector, e.g. with
+// AccountConflicts for batches that never share an account. Conflicting
+// transactions are deferred to a later batch. A detector must keep
+// transactions from overdrawing an account together, each is only
+// checked to execute on its own, see ConflictDetector.
+func WithConflictDetector(detector ConflictDetector) Option {
+	return func(vali *Validator) {
+		vali.conflicts = detector
+	}
+}
+
+// WithMaxConnections sets how many connections the query API, which
+// takes submissions too, keeps open at most. Connections beyond the
+// limit are closed right after they're accepted. Zero means no limit,
+// which is the default.
+func WithMaxConnections(n int) Option {
+	return func(vali *Validator) {
+		vali.maxConnections = n
+	}
+}
+
+// WithMaxReferenceAmount sets the largest referenced balance an
+// instruction can move, so a reference to an account with a huge balance
+// can't cause a huge swing. Transactions referencing a larger balance
+// fail to execute. Zero means no limit, which is the default.
+func WithMaxReferenceAmount(amount float64) Option {
+	return func(vali *Validator) {
+		vali.maxReferenceAmount = amount
+	}
+}
+
+// WithAccountValidator sets a function checking every account name a
+// transaction refers to, as the payer, an instruction account or a
+// referenced account, e.g. for a format or a max length. Names are
+// checked as received, before normalization. Transactions with a name it
+// returns an error for are dropped with ReasonAccountName. Any name is
+// accepted by default.
+func WithAccountValidator(validate func(account string) error) Option {
+	return func(vali *Validator) {
+		vali.accountValidator = validate
+	}
+}
+
+// BatchValidator is a final check of a batch before it's committed, e.g.
+// against an external allowlist. Returning an error vetoes the batch.
+type BatchValidator func(batch []*Transaction) error
+
+// VetoPolicy decides what happens to transactions of a vetoed batch.
+type VetoPolicy int
+
+const (
+	// VetoRequeue makes them pending again, to be tried in later batches.
+	VetoRequeue VetoPolicy = iota
+	// VetoDrop drops them for good.
+	VetoDrop
+)
+
+// WithBatchValidator sets a function every built batch goes through
+// before it's committed. A vetoed batch isn't committed at all, its
+// transactions are handled by the veto policy and counted with
+// ReasonVetoed. Requeued transactions may well end up in the same batch
+// again, a validator vetoing them for good should go with VetoDrop.
+func WithBatchValidator(validate BatchValidator, policy VetoPolicy) Option {
+	return func(vali *Validator) {
+		vali.batchValidator = validate
+		vali.vetoPolicy = policy
+	}
+}
+
+// WithComplexityBudget sets the evaluation budget of a transaction,
+// bounding the work an adversarial one can cause. Every instruction
+// costs 1, reference instructions cost 2 as they read another account.
+// Transactions over the budget are rejected on receipt with
+// ReasonTooComplex. Zero means no budget, which is the default.
+func WithComplexityBudget(cost int) Option {
+	return func(vali *Validator) {
+		vali.complexityBudget = cost
+	}
+}
+
+// WithBatchCodec sets how the default HTTP sink encodes batches, e.g.
+// MsgpackCodec for lower overhead. The Content-Type header tells the
+// collector which one's in use. Custom sinks encode batches themselves.
+// Defaults to JSONCodec.
+func WithBatchCodec(codec Codec) Option {
+	return func(vali *Validator) {
+		vali.batchCodec = codec
+	}
+}
+
+// WithIncludeRawBytes makes the validator keep every transaction exactly
+// as received and send it along in batches, base64 encoded in the "raw"
+// field, e.g. for downstream signature checks that can't rely on a
+// re-encoded form. Transactions rewritten by middleware still carry
+// what's received. Disabled by default.
+func WithIncludeRawBytes(include bool) Option {
+	return func(vali *Validator) {
+		vali.includeRawBytes = include
+	}
+}
+
+// WithReservedAccountPolicy sets what's done when the validator account
+// isn't in the snapshot: it's either created, with no or a given balance,
+// or reported as an error. See adb.ReservedAccountPolicy. Defaults to
+// creating it with no balance.
+func WithReservedAccountPolicy(policy adb.ReservedAccountPolicy) Option {
+	return func(vali *Validator) {
+		vali.reservedPolicy = policy
+	}
+}
+
+// WithHeapSoftLimit sets roughly how much memory pending transactions
+// can take before the validator reports itself degraded, on the health
+// endpoint and with a warning log. Unlike WithMaxPendingBytes, nothing
+// is rejected for crossing it. Disabled by default.
+func WithHeapSoftLimit(bytes int) Option {
+	return func(vali *Validator) {
+		vali.heapSoftLimit = bytes
+	}
+}
+
+// PriorityVerifier checks that a transaction asking for priority comes
+// from a trusted sender, e.g. by verifying a signature over its ID.
+// Returning an error drops the transaction.
+type PriorityVerifier func(*Transaction) error
+
+// WithPriorityVerifier lets transactions with the "priority" field set
+// skip scoring and go ahead of every other pending transaction, as long
+// as verify accepts them. Without a verifier such transactions are
+// rejected with ReasonUntrustedPriority, as anyone could set the field.
+// Priority has no effect on the order with the FIFO policy.
+func WithPriorityVerifier(verify PriorityVerifier) Option {
+	return func(vali *Validator) {
+		vali.priorityVerifier = verify
+	}
+}
+
+// WithBatchWindow makes the validator gather transactions for up to d
+// before building a batch, rather than building one as soon as anything
+// is pending. Whatever is gathered by then is batched, even if it's
+// fewer than the batch size; a full batch is built right away. This
+// trades latency for fuller batches and smoother downstream load.
+// Disabled by default.
+func WithBatchWindow(d time.Duration) Option {
+	return func(vali *Validator) {
+		vali.batchWindow = d
+	}
+}
+
+// WithStatusAddr makes Run answer datagrams received over given UDP
+// address (e.g. ":2004") with the current load of the validator and a
+// suggested share of transactions to send, so that cooperative senders
+// can back off before their transactions are dropped. Load is only
+// known relative to WithMaxPending and WithMaxPendingBytes. Disabled
+// by default.
+func WithStatusAddr(addr string) Option {
+	return func(vali *Validator) {
+		vali.statusAddr = addr
+	}
+}
+
+// WithSnapshotFailureHandler sets a function called with the error every
+// time a periodic snapshot fails to be written, e.g. to alert on a full
+// disk. Failures are logged and counted either way, and the snapshot is
+// retried on the next tick.
+func WithSnapshotFailureHandler(fn func(error)) Option {
+	return func(vali *Validator) {
+		vali.onSnapshotFailure = fn
+	}
+}
+
+// WithSupplyCheck makes the validator compare total supply to the one it
+// started with before every snapshot, logging and counting any drift,
+// see SupplyDrifts. Unlike WithStrictConservation, it also catches
+// leaks outside of commits, at the cost of summing every balance once
+// per snapshot. Disabled by default.
+func WithSupplyCheck(check bool) Option {
+	return func(vali *Validator) {
+		vali.supplyCheck = check
+	}
+}
+
+// WithMaxAccountsPerTransaction sets how many distinct accounts a single
+// transaction may touch, counting the payer, instruction accounts and
+// referenced accounts after normalization. Transactions touching more
+// are rejected with ReasonTooManyAccounts. Unlimited by default.
+func WithMaxAccountsPerTransaction(n int) Option {
+	return func(vali *Validator) {
+		vali.maxAccounts = n
+	}
+}
+
+// WithTracerProvider traces every received transaction through the
+// stages it goes through with OpenTelemetry. Every transaction gets a
+// "transaction" span lasting until it's settled in a batch or dropped,
+// with child spans:
+//
+//	receive   decoding the message it's received in
+//	validate  checks made on receive, after decoding
+//	enqueue   making it pending
+//	batch     settling the batch it's included in, with children
+//	          commit  applying the batch to balances
+//	          send    delivering the batch to the sink
+//
+// A transaction may have several enqueue and batch spans if it's deferred
+// to a later batch. Transactions carrying a "traceId", a W3C traceparent
+// (e.g. "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"),
+// continue the sender's trace. Defaults to a no-op provider.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(vali *Validator) {
+		vali.tracer = provider.Tracer(tracerName)
+	}
+}
+
+// PayerPolicy decides how the fee of a transaction interacts with
+// instructions changing the balance of its own payer. Either way, a
+// committed transaction changes the payer's balance by its instruction
+// changes minus the fee; the policy only decides what the payer must
+// afford for the transaction to be accepted.
+type PayerPolicy int
+
+const (
+	// PayerPaysUpfront takes the fee before instructions run: the payer's
+	// balance before the transaction must cover the fee along with every
+	// debit of the payer, credits to the payer can't fund either.
+	PayerPaysUpfront PayerPolicy = iota
+	// PayerNetChange nets the fee with the numeric instruction changes of
+	// the payer, so credits the transaction makes to its payer can fund
+	// its fee and debits. Credits of referenced balances don't count,
+	// as they depend on balances at the time of the batch.
+	PayerNetChange
+)
+
+// WithPayerPolicy sets how fees interact with instructions changing the
+// payer's balance. Defaults to PayerPaysUpfront.
+func WithPayerPolicy(policy PayerPolicy) Option {
+	return func(vali *Validator) {
+		vali.payerPolicy = policy
+	}
+}
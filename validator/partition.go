@@ -0,0 +1,160 @@
+package validator
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+	"sync"
+)
+
+// laneBatch is a batch built by a lane, see processPartitioned.
+type laneBatch struct {
+	batch, failed, deferred []*Transaction
+}
+
+// processPartitioned is processBatch in partitioned mode: pending
+// transactions are split into lanes by the accounts they touch, and a
+// batch is built out of every lane concurrently. Lanes share no account
+// that's debited, so their batches are commutative with each other and
+// can be built against the same starting balances. Batches are then
+// committed and sent one after another, batch indexes being sequential.
+// Must be called with processMu held.
+func (vali *Validator) processPartitioned() (batch, deferred []*Transaction, err error) {
+	// Every lane gets up to a window of candidates.
+	window := max(vali.candidateWindow, vali.batchSize)
+	var candidates []*Transaction
+	for len(candidates) < vali.lanes*window {
+		tx := vali.NextTransaction()
+		if tx == nil {
+			break
+		}
+		candidates = append(candidates, tx)
+	}
+
+	lanes := vali.partition(candidates)
+	built := make([]laneBatch, len(lanes))
+	var wg sync.WaitGroup
+	for i, lane := range lanes {
+		wg.Go(func() {
+			taken := 0
+			next := func() *Transaction {
+				if taken == len(lane) {
+					return nil
+				}
+				taken++
+				return lane[taken-1]
+			}
+
+			b := &built[i]
+			b.batch, b.failed, b.deferred = vali.buildBatchFrom(next, false)
+			// Whatever didn't fit the window waits for the next batch.
+			b.deferred = append(b.deferred, lane[taken:]...)
+		})
+	}
+	wg.Wait()
+
+	for _, b := range built {
+		vali.setCurrentBatch(b.batch)
+		settled, requeued, settleErr := vali.settleBatch(b.batch, b.failed)
+
+		batch = append(batch, settled...)
+		deferred = append(deferred, b.deferred...)
+		deferred = append(deferred, requeued...)
+		err = errors.Join(err, settleErr)
+	}
+
+	// See processBatch.
+	deferred = vali.expire(deferred)
+	vali.requeue(deferred)
+
+	return batch, deferred, err
+}
+
+// partition splits transactions into at most as many lanes as set by
+// WithPartitionedProcessing, so that transactions sharing an account are
+// in the same lane. Accounts are merged into sets by union-find over the
+// accounts of every transaction, largest sets are given to the lanes
+// first, each to the lane with the fewest transactions so far.
+// Transactions keep their order within a lane.
+func (vali *Validator) partition(txs []*Transaction) [][]*Transaction {
+	parent := make(map[string]string)
+	var find func(account string) string
+	find = func(account string) string {
+		root, ok := parent[account]
+		if !ok {
+			parent[account] = account
+			return account
+		}
+		if root == account {
+			return root
+		}
+
+		root = find(root)
+		parent[account] = root
+		return root
+	}
+
+	keys := make([]string, len(txs))
+	for i, tx := range txs {
+		accounts := vali.partitionAccounts(tx)
+		root := find(accounts[0])
+		for _, account := range accounts[1:] {
+			if other := find(account); other != root {
+				parent[other] = root
+			}
+		}
+		keys[i] = accounts[0]
+	}
+
+	// Transactions of every set, in order of first appearance.
+	sizes := make(map[string]int)
+	var roots []string
+	for i, key := range keys {
+		root := find(key)
+		keys[i] = root
+		if sizes[root] == 0 {
+			roots = append(roots, root)
+		}
+		sizes[root]++
+	}
+	slices.SortStableFunc(roots, func(a, b string) int {
+		return cmp.Compare(sizes[b], sizes[a])
+	})
+
+	loads := make([]int, min(vali.lanes, len(roots)))
+	laneOf := make(map[string]int, len(roots))
+	for _, root := range roots {
+		lane := slices.Index(loads, slices.Min(loads))
+		laneOf[root] = lane
+		loads[lane] += sizes[root]
+	}
+
+	lanes := make([][]*Transaction, len(loads))
+	for i, tx := range txs {
+		lane := laneOf[keys[i]]
+		lanes[lane] = append(lanes[lane], tx)
+	}
+
+	return lanes
+}
+
+// partitionAccounts returns the accounts a transaction is partitioned by:
+// every account it touches, but the validator account if it's only
+// credited. Fees and burns credit it from every lane, which commutes;
+// it's only shared between lanes if it may be debited, e.g. for minting.
+func (vali *Validator) partitionAccounts(tx *Transaction) []string {
+	validator := vali.validatorAccount
+	accounts := tx.accounts()
+	if vali.allowMint || slices.Contains(tx.debited(validator), validator) {
+		return accounts
+	}
+
+	accounts = slices.DeleteFunc(accounts, func(account string) bool {
+		return account == validator
+	})
+	if len(accounts) == 0 {
+		return []string{validator}
+	}
+
+	return accounts
+}
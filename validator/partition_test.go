@@ -0,0 +1,213 @@
+package validator
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPartitionKeepsSharedAccountsTogether(t *testing.T) {
+	vali := newTestValidator(t, map[string]float64{}, WithPartitionedProcessing(3))
+
+	txs := []*Transaction{
+		transfer("alice", "bob", 1, 1),
+		transfer("carol", "dave", 1, 1),
+		transfer("erin", "frank", 1, 1),
+		transfer("bob", "grace", 1, 1), // Joins alice and bob.
+		transfer("heidi", "ivan", 1, 1),
+		transfer("grace", "carol", 1, 1), // Joins alice and carol.
+		transfer("judy", "validator", 1, 1),
+	}
+	lanes := vali.partition(txs)
+	if len(lanes) != 3 {
+		t.Fatalf("got %d lanes, want 3", len(lanes))
+	}
+
+	laneOf := make(map[string]int)
+	count := 0
+	for i, lane := range lanes {
+		count += len(lane)
+		for j, tx := range lane {
+			// Order is kept within a lane.
+			if j > 0 && indexOf(txs, tx) < indexOf(txs, lane[j-1]) {
+				t.Errorf("lane %d is out of order", i)
+			}
+
+			for _, account := range tx.accounts() {
+				// Credited only, validator is shared by every lane.
+				if account == "validator" {
+					continue
+				}
+				if lane, ok := laneOf[account]; ok && lane != i {
+					t.Errorf("%s is in lanes %d and %d", account, lane, i)
+				}
+				laneOf[account] = i
+			}
+		}
+	}
+	if count != len(txs) {
+		t.Errorf("lanes have %d transactions, want %d", count, len(txs))
+	}
+
+	// Largest set goes to the first lane.
+	if len(lanes[0]) != 4 {
+		t.Errorf("first lane has %d transactions, want 4", len(lanes[0]))
+	}
+}
+
+func indexOf(txs []*Transaction, tx *Transaction) int {
+	for i, other := range txs {
+		if other == tx {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func TestPartitionWithMintingSharesValidator(t *testing.T) {
+	vali := newTestValidator(t, map[string]float64{}, WithPartitionedProcessing(2), WithAllowUnbalanced(true, false))
+
+	lanes := vali.partition([]*Transaction{
+		transfer("alice", "validator", 1, 1),
+		transfer("bob", "validator", 1, 1),
+	})
+	if len(lanes) != 1 {
+		t.Errorf("got %d lanes, want 1", len(lanes))
+	}
+}
+
+// settleAll flushes until nothing is pending, returning the batches
+// committed. Unlike processAll, batches are sent.
+func settleAll(t testing.TB, vali *Validator) [][]*Transaction {
+	t.Helper()
+
+	var batches [][]*Transaction
+	for vali.PendingCount() > 0 {
+		batch, err := vali.Flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(batch) == 0 {
+			break
+		}
+		batches = append(batches, batch)
+	}
+
+	return batches
+}
+
+func TestPartitionedProcessingDisjoint(t *testing.T) {
+	balances := make(map[string]float64)
+	for i := range 8 {
+		balances[fmt.Sprintf("from%d", i)] = 100
+		balances[fmt.Sprintf("to%d", i)] = 0
+	}
+	vali := newTestValidator(t, balances, WithPartitionedProcessing(4), WithSink(&recordingSink{}))
+
+	for i := range 8 {
+		for range 5 {
+			vali.PushTransaction(transfer(fmt.Sprintf("from%d", i), fmt.Sprintf("to%d", i), 10, 1))
+		}
+	}
+	settleAll(t, vali)
+
+	if n := vali.PendingCount(); n != 0 {
+		t.Errorf("%d transaction(s) left pending", n)
+	}
+	for i := range 8 {
+		from, _ := vali.db.GetBalance(fmt.Sprintf("from%d", i))
+		to, _ := vali.db.GetBalance(fmt.Sprintf("to%d", i))
+		if from != 45 || to != 50 {
+			t.Errorf("lane %d ended with %v and %v, want 45 and 50", i, from, to)
+		}
+	}
+	if fees, _ := vali.db.GetBalance("validator"); fees != 40 {
+		t.Errorf("validator earned %v, want 40", fees)
+	}
+}
+
+func TestPartitionedProcessingOverlapping(t *testing.T) {
+	// Everyone pays alice out of little, only some of it can go through.
+	balances := map[string]float64{"alice": 0, "bob": 25, "carol": 25, "dave": 25, "erin": 100}
+	vali := newTestValidator(t, balances, WithPartitionedProcessing(4), WithSink(&recordingSink{}))
+
+	for range 4 {
+		for _, from := range []string{"bob", "carol", "dave"} {
+			vali.PushTransaction(transfer(from, "alice", 10, 1))
+		}
+		vali.PushTransaction(transfer("erin", "frank", 10, 1))
+	}
+	batches := settleAll(t, vali)
+
+	// Nobody overdraws, whatever lane their transactions went to.
+	var supply float64
+	for _, account := range []string{"alice", "bob", "carol", "dave", "erin", "frank", "validator"} {
+		balance, _ := vali.db.GetBalance(account)
+		if balance < 0 {
+			t.Errorf("%s went negative: %v", account, balance)
+		}
+		supply += balance
+	}
+	if supply != 175 {
+		t.Errorf("total supply is %v, want 175", supply)
+	}
+
+	// Each of bob, carol and dave can afford two transfers.
+	if alice, _ := vali.db.GetBalance("alice"); alice != 60 {
+		t.Errorf("alice got %v, want 60", alice)
+	}
+	if frank, _ := vali.db.GetBalance("frank"); frank != 40 {
+		t.Errorf("frank got %v, want 40", frank)
+	}
+
+	// Transactions sharing an account never share a batch with
+	// one overdrawing it.
+	for _, batch := range batches {
+		for _, account := range []string{"bob", "carol", "dave"} {
+			n := 0
+			for _, tx := range batch {
+				if tx.Fee.Payer == account {
+					n++
+				}
+			}
+			if n > 2 {
+				t.Errorf("%s pays for %d transactions of a batch", account, n)
+			}
+		}
+	}
+}
+
+// BenchmarkProcessBatch measures building batches of disjoint transfers,
+// in dry run so that committing and the send rate limit are left out.
+func BenchmarkProcessBatch(b *testing.B) {
+	for _, lanes := range []int{1, 4} {
+		b.Run(fmt.Sprintf("lanes=%d", lanes), func(b *testing.B) {
+			const accounts = 1000
+
+			balances := make(map[string]float64, 2*accounts)
+			for i := range accounts {
+				balances[fmt.Sprintf("from%d", i)] = 1e12
+				balances[fmt.Sprintf("to%d", i)] = 0
+			}
+			vali := newTestValidator(b, balances, WithPartitionedProcessing(lanes), WithDryRun(true),
+				WithBatchSize(256))
+
+			txs := make([]*Transaction, accounts)
+			for i := range txs {
+				txs[i] = transfer(fmt.Sprintf("from%d", i), fmt.Sprintf("to%d", i), 1, 1)
+			}
+
+			b.ResetTimer()
+			for range b.N {
+				b.StopTimer()
+				for _, tx := range txs {
+					copy := *tx
+					vali.PushTransaction(&copy)
+				}
+				b.StartTimer()
+
+				settleAll(b, vali)
+			}
+		})
+	}
+}
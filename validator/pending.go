@@ -0,0 +1,222 @@
+package validator
+
+import (
+	"cmp"
+	"container/heap"
+	"slices"
+)
+
+// pendingSet holds the transactions waiting to be put in a batch.
+// The order transactions are popped in is up to the implementation.
+//
+// Implementations aren't safe for concurrent use, the validator
+// guards them; see PushTransaction, NextTransaction and PeekTransaction.
+type pendingSet interface {
+	Push(tx *Transaction)
+	// Requeue puts popped transactions back, in the place they'd be
+	// had they never been popped.
+	Requeue(txs []*Transaction)
+	Pop() *Transaction
+	Peek() *Transaction
+	Len() int
+	// Update sets the priority of a transaction. Returns false
+	// if the transaction isn't in the set.
+	Update(tx *Transaction, prio int) bool
+	// ScoreRange returns the lowest and highest priorities in the set,
+	// ok is false if the set is empty.
+	ScoreRange() (min, max int, ok bool)
+	// RemoveFunc removes every transaction fn returns true for,
+	// and returns them.
+	RemoveFunc(fn func(tx *Transaction) bool) []*Transaction
+	// Transactions returns every transaction in the set, in no
+	// particular order.
+	Transactions() []*Transaction
+}
+
+// newPendingSet creates the pending set for given selection policy.
+// Capacity is a hint of how many transactions are expected to be pending
+// at once, so the backing array doesn't need to grow under load.
+func newPendingSet(policy SelectionPolicy, capacity int) pendingSet {
+	switch policy {
+	case FIFO:
+		return &fifoQueue{txs: make([]*Transaction, 0, capacity)}
+	default:
+		return newPriorityQueue(capacity)
+	}
+}
+
+// priorityQueue pops transactions with the highest priority first.
+type priorityQueue struct {
+	heap TransactionHeap
+}
+
+func newPriorityQueue(capacity int) *priorityQueue {
+	queue := &priorityQueue{heap: make(TransactionHeap, 0, capacity)}
+	heap.Init(&queue.heap)
+
+	return queue
+}
+
+func (queue *priorityQueue) Push(tx *Transaction) {
+	heap.Push(&queue.heap, tx)
+}
+
+func (queue *priorityQueue) Requeue(txs []*Transaction) {
+	for _, tx := range txs {
+		heap.Push(&queue.heap, tx)
+	}
+}
+
+func (queue *priorityQueue) Pop() *Transaction {
+	return heap.Pop(&queue.heap).(*Transaction)
+}
+
+func (queue *priorityQueue) Peek() *Transaction {
+	return queue.heap[0]
+}
+
+func (queue *priorityQueue) Len() int {
+	return queue.heap.Len()
+}
+
+func (queue *priorityQueue) Update(tx *Transaction, prio int) bool {
+	tx.prio = prio
+
+	// Index is only trusted if it actually points at the transaction;
+	// popped transactions have -1, and a stale one could belong to
+	// another transaction by now.
+	if tx.index < 0 || tx.index >= len(queue.heap) || queue.heap[tx.index] != tx {
+		return false
+	}
+
+	heap.Fix(&queue.heap, tx.index)
+	return true
+}
+
+func (queue *priorityQueue) ScoreRange() (min, max int, ok bool) {
+	n := len(queue.heap)
+	if n == 0 {
+		return 0, 0, false
+	}
+
+	// Highest priority is the root, the lowest has to be one of the leaves.
+	min, max = queue.heap[0].prio, queue.heap[0].prio
+	for _, tx := range queue.heap[n/2:] {
+		if tx.prio < min {
+			min = tx.prio
+		}
+	}
+
+	return min, max, true
+}
+
+func (queue *priorityQueue) RemoveFunc(fn func(tx *Transaction) bool) []*Transaction {
+	removed := queue.heap.removeFunc(fn)
+	if len(removed) > 0 {
+		heap.Init(&queue.heap)
+	}
+
+	return removed
+}
+
+func (queue *priorityQueue) Transactions() []*Transaction {
+	return slices.Clone(queue.heap)
+}
+
+// fifoQueue pops transactions in the order they've arrived in, see
+// Transaction.arrival.
+type fifoQueue struct {
+	txs []*Transaction
+}
+
+func (queue *fifoQueue) Push(tx *Transaction) {
+	queue.txs = append(queue.txs, tx)
+}
+
+// Requeue merges transactions back by their arrival, so that ones
+// deferred from a batch stay ahead of the ones that arrived after them.
+func (queue *fifoQueue) Requeue(txs []*Transaction) {
+	txs = slices.Clone(txs)
+	slices.SortFunc(txs, func(a, b *Transaction) int {
+		return cmp.Compare(a.arrival, b.arrival)
+	})
+
+	merged := make([]*Transaction, 0, max(len(queue.txs)+len(txs), cap(queue.txs)))
+	rest := queue.txs
+	for len(txs) > 0 && len(rest) > 0 {
+		if txs[0].arrival <= rest[0].arrival {
+			merged, txs = append(merged, txs[0]), txs[1:]
+		} else {
+			merged, rest = append(merged, rest[0]), rest[1:]
+		}
+	}
+	merged = append(merged, txs...)
+	merged = append(merged, rest...)
+
+	clear(queue.txs) // don't stop the GC from reclaiming the old array
+	queue.txs = merged
+}
+
+func (queue *fifoQueue) Pop() *Transaction {
+	tx := queue.txs[0]
+	queue.txs[0] = nil // don't stop the GC from reclaiming the item eventually
+	queue.txs = queue.txs[1:]
+	tx.index = -1 // for safety
+
+	return tx
+}
+
+func (queue *fifoQueue) Peek() *Transaction {
+	return queue.txs[0]
+}
+
+func (queue *fifoQueue) Len() int {
+	return len(queue.txs)
+}
+
+func (queue *fifoQueue) Update(tx *Transaction, prio int) bool {
+	// Priority doesn't affect the order, only record it.
+	tx.prio = prio
+
+	return slices.Contains(queue.txs, tx)
+}
+
+func (queue *fifoQueue) ScoreRange() (min, max int, ok bool) {
+	if len(queue.txs) == 0 {
+		return 0, 0, false
+	}
+
+	min, max = queue.txs[0].prio, queue.txs[0].prio
+	for _, tx := range queue.txs[1:] {
+		if tx.prio < min {
+			min = tx.prio
+		}
+		if tx.prio > max {
+			max = tx.prio
+		}
+	}
+
+	return min, max, true
+}
+
+func (queue *fifoQueue) RemoveFunc(fn func(tx *Transaction) bool) []*Transaction {
+	var removed []*Transaction
+	kept := queue.txs[:0]
+	for _, tx := range queue.txs {
+		if fn(tx) {
+			tx.index = -1
+			removed = append(removed, tx)
+			continue
+		}
+		kept = append(kept, tx)
+	}
+
+	clear(queue.txs[len(kept):]) // don't stop the GC from reclaiming removed items
+	queue.txs = kept
+
+	return removed
+}
+
+func (queue *fifoQueue) Transactions() []*Transaction {
+	return slices.Clone(queue.txs)
+}
@@ -0,0 +1,418 @@
+package validator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+	"runtime"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	adb "transactioner/accountsdb"
+
+	"github.com/benbjohnson/clock"
+)
+
+// encode encodes a transaction the way it's sent to the validator.
+func encode(t testing.TB, tx *Transaction) []byte {
+	t.Helper()
+
+	msg, err := json.Marshal(tx.Transaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return msg
+}
+
+// receive hands a transaction to the validator as if it was received
+// over the network, so that it's decoded and scored as usual, and makes
+// it pending.
+func receive(t testing.TB, vali *Validator, tx *Transaction) {
+	t.Helper()
+
+	vali.handleMessage(encode(t, tx), netip.AddrPort{})
+	vali.drainIncoming()
+}
+
+func TestSelectionPolicy(t *testing.T) {
+	balances := map[string]float64{"alice": 100, "bob": 100, "carol": 100, "dave": 100}
+	// Lowest fees arrive first.
+	txs := []*Transaction{
+		transfer("alice", "erin", 1, 1),
+		transfer("bob", "erin", 1, 2),
+		transfer("carol", "erin", 1, 3),
+		transfer("dave", "erin", 1, 4),
+	}
+
+	tests := []struct {
+		policy SelectionPolicy
+		// Payers of the batch, in order.
+		want []string
+	}{
+		{ScorePriority, []string{"dave", "carol", "bob", "alice"}},
+		{FIFO, []string{"alice", "bob", "carol", "dave"}},
+	}
+	for _, test := range tests {
+		vali := newTestValidator(t, balances, WithSelectionPolicy(test.policy))
+		for _, tx := range txs {
+			receive(t, vali, tx)
+		}
+
+		batch, _, deferred := vali.buildBatch()
+		var payers []string
+		for _, tx := range batch {
+			payers = append(payers, tx.Fee.Payer)
+		}
+		if len(deferred) != 0 || !slices.Equal(payers, test.want) {
+			t.Errorf("policy %d: batch paid by %v, %d deferred, want %v", test.policy, payers,
+				len(deferred), test.want)
+		}
+		vali.Close()
+	}
+}
+
+func TestFIFOKeepsDeferredAhead(t *testing.T) {
+	vali := newTestValidator(t, map[string]float64{}, WithSelectionPolicy(FIFO))
+
+	txs := []*Transaction{
+		transfer("alice", "bob", 1, 1),
+		transfer("bob", "carol", 1, 1),
+		transfer("carol", "dave", 1, 1),
+		transfer("dave", "erin", 1, 1),
+	}
+	for _, tx := range txs[:3] {
+		vali.PushTransaction(tx)
+	}
+
+	// The first two are deferred out of a batch, while the last arrives.
+	popped := []*Transaction{vali.NextTransaction(), vali.NextTransaction()}
+	vali.PushTransaction(txs[3])
+	vali.requeue([]*Transaction{popped[1], popped[0]})
+
+	for i, want := range txs {
+		if tx := vali.NextTransaction(); tx != want {
+			t.Errorf("transaction %d is paid by %s, want %s", i, tx.Fee.Payer, want.Fee.Payer)
+		}
+	}
+}
+
+// BenchmarkPendingPush measures a burst of pushes into a pending set
+// that's sized for it upfront, and into one that grows as it goes.
+func BenchmarkPendingPush(b *testing.B) {
+	const burst = 10000
+
+	txs := make([]*Transaction, burst)
+	for i := range txs {
+		txs[i] = transfer("alice", "bob", 1, float64(i%100))
+		txs[i].prio = txs[i].CalcScore()
+	}
+
+	for name, policy := range map[string]SelectionPolicy{"score": ScorePriority, "fifo": FIFO} {
+		for _, capacity := range []int{0, burst} {
+			b.Run(fmt.Sprintf("%s/capacity=%d", name, capacity), func(b *testing.B) {
+				b.ReportAllocs()
+				for range b.N {
+					pending := newPendingSet(policy, capacity)
+					for _, tx := range txs {
+						pending.Push(tx)
+					}
+				}
+			})
+		}
+	}
+}
+
+// Run with -race.
+func TestConcurrentPushers(t *testing.T) {
+	const pushers, perPusher = 8, 200
+
+	for name, policy := range map[string]SelectionPolicy{"score": ScorePriority, "fifo": FIFO} {
+		vali := newTestValidator(t, map[string]float64{}, WithSelectionPolicy(policy))
+
+		var wg sync.WaitGroup
+		for i := range pushers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := range perPusher {
+					tx := transfer(fmt.Sprintf("payer%d", i), "bob", float64(j+1), 1)
+					tx.prio = j
+					vali.PushTransaction(tx)
+				}
+			}()
+		}
+
+		// Single popper, taking transactions as they come.
+		popped := make(map[*Transaction]int)
+		for len(popped) < pushers*perPusher {
+			if vali.PeekTransaction() == nil {
+				runtime.Gosched()
+				continue
+			}
+
+			tx := vali.NextTransaction()
+			if tx == nil {
+				t.Fatalf("%s: peeked a transaction, but none was popped", name)
+			}
+			popped[tx]++
+			if popped[tx] > 1 {
+				t.Fatalf("%s: transaction popped twice", name)
+			}
+		}
+		wg.Wait()
+
+		if n := vali.PendingCount(); n != 0 {
+			t.Errorf("%s: %d transaction(s) left pending", name, n)
+		}
+		if tx := vali.NextTransaction(); tx != nil {
+			t.Errorf("%s: popped a transaction out of an empty set", name)
+		}
+		vali.Close()
+	}
+}
+
+func TestUpdatePriorityAfterPop(t *testing.T) {
+	vali := newTestValidator(t, map[string]float64{})
+
+	txs := make([]*Transaction, 4)
+	for i := range txs {
+		txs[i] = transfer("alice", "bob", float64(i+1), 1)
+		txs[i].prio = i
+		vali.PushTransaction(txs[i])
+	}
+
+	// Highest first, it's no longer in the heap.
+	tx := vali.NextTransaction()
+	if tx != txs[3] {
+		t.Fatalf("popped priority %d, want 3", tx.prio)
+	}
+	if vali.UpdatePriority(tx, 10) {
+		t.Error("updated the priority of a popped transaction")
+	}
+
+	// A stale index pointing at another transaction is no use either.
+	tx.index = 0
+	if vali.UpdatePriority(tx, 10) {
+		t.Error("updated the priority of a transaction by a stale index")
+	}
+
+	// Re-pushed, it gets a fresh index.
+	vali.PushTransaction(tx)
+	if !vali.UpdatePriority(tx, -1) {
+		t.Fatal("couldn't update the priority of a re-pushed transaction")
+	}
+
+	var order []int
+	for vali.PendingCount() > 0 {
+		order = append(order, vali.NextTransaction().prio)
+	}
+	if !slices.Equal(order, []int{2, 1, 0, -1}) {
+		t.Errorf("popped priorities %v, want [2 1 0 -1]", order)
+	}
+}
+
+func TestCandidateWindow(t *testing.T) {
+	balances := make(map[string]float64)
+	for i := range 10 {
+		balances[fmt.Sprintf("payer%d", i)] = 100
+	}
+
+	for name, policy := range map[string]SelectionPolicy{"score": ScorePriority, "fifo": FIFO} {
+		vali := newTestValidator(t, balances, WithSelectionPolicy(policy), WithBatchSize(3),
+			WithCandidateWindow(10), WithSink(&recordingSink{}))
+
+		// Fees go up and down, so that the best ones aren't the first.
+		fees := []float64{3, 9, 1, 7, 5, 10, 2, 8, 4, 6}
+		for i, fee := range fees {
+			receive(t, vali, transfer(fmt.Sprintf("payer%d", i), "bob", 1, fee))
+		}
+
+		batch, err := vali.Flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []float64
+		for _, tx := range batch {
+			got = append(got, tx.Fee.Amount)
+		}
+		slices.Sort(got)
+
+		// Best of the whole window, or the earliest under FIFO.
+		want := []float64{8, 9, 10}
+		if policy == FIFO {
+			want = []float64{1, 3, 9}
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("%s: committed fees %v, want %v", name, got, want)
+		}
+		if n := vali.PendingCount(); n != 7 {
+			t.Errorf("%s: %d transaction(s) pending, want 7", name, n)
+		}
+		vali.Close()
+	}
+}
+
+func TestScoreRange(t *testing.T) {
+	for name, policy := range map[string]SelectionPolicy{"score": ScorePriority, "fifo": FIFO} {
+		vali := newTestValidator(t, map[string]float64{"alice": 100}, WithSelectionPolicy(policy))
+
+		if _, _, ok := vali.ScoreRange(); ok {
+			t.Errorf("%s: score range of an empty set", name)
+		}
+
+		// Scores of pending transactions, as given by the default scorer.
+		var scores []int
+		for _, fee := range []float64{3, 9, 1, 7} {
+			tx := transfer("alice", "bob", fee, fee)
+			receive(t, vali, tx)
+			scores = append(scores, tx.CalcScore())
+		}
+
+		for vali.PendingCount() > 0 {
+			lowest, highest, ok := vali.ScoreRange()
+			if !ok {
+				t.Fatalf("%s: no score range with transactions pending", name)
+			}
+
+			if lowest != slices.Min(scores) || highest != slices.Max(scores) {
+				t.Errorf("%s: score range [%d, %d] of scores %v", name, lowest, highest, scores)
+			}
+
+			// The highest is the next one to be batched, unless FIFO.
+			next := vali.NextTransaction()
+			if policy == ScorePriority && next.prio != highest {
+				t.Errorf("%s: next transaction scored %d, highest is %d", name, next.prio, highest)
+			}
+			scores = slices.Delete(scores, slices.Index(scores, next.prio), slices.Index(scores, next.prio)+1)
+		}
+		vali.Close()
+	}
+}
+
+// Run with -race.
+func TestRescorePending(t *testing.T) {
+	mock := clock.NewMock()
+	vali := newTestValidator(t, map[string]float64{"alice": 100, "bob": 100},
+		WithClock(mock), WithRescoreInterval(time.Second))
+	vali.wg.Add(1)
+	go vali.RescorePending()
+	t.Cleanup(func() {
+		vali.Close()
+		vali.wg.Wait()
+	})
+
+	receive(t, vali, transfer("alice", "carol", 1, 5))
+	receive(t, vali, transfer("bob", "carol", 1, 5))
+
+	// Alice can't pay the fee anymore while queued.
+	vali.db.SetBalance("alice", 1)
+	waitFor(t, func() bool {
+		mock.Add(time.Second)
+		return vali.PendingCount() == 1
+	})
+
+	if n := vali.Rejections(ReasonFeeCheck); n != 1 {
+		t.Errorf("%d fee check rejection(s), want 1", n)
+	}
+	if tx := vali.PeekTransaction(); tx == nil || tx.Fee.Payer != "bob" {
+		t.Errorf("%v is left pending, want bob's transaction", tx)
+	}
+}
+
+func TestDropPending(t *testing.T) {
+	vali := newTestValidator(t, map[string]float64{"alice": 100, "bob": 100}, WithAccountNormalizer(adb.TrimLower))
+
+	// pendingBytes returns the estimated size of pending transactions.
+	pendingBytes := func() int {
+		vali.pendingMu.Lock()
+		defer vali.pendingMu.Unlock()
+		return vali.pendingBytes
+	}
+
+	for i := range 5 {
+		receive(t, vali, transfer("bob", "carol", 1, float64(5-i)))
+	}
+	bobs := pendingBytes()
+	for i := range 5 {
+		receive(t, vali, transfer("alice", "carol", 1, float64(i+1)))
+	}
+
+	if n := vali.DropPending(" Alice"); n != 5 {
+		t.Errorf("dropped %d transaction(s), want 5", n)
+	}
+	if n := vali.DropPending("nobody"); n != 0 {
+		t.Errorf("dropped %d transaction(s) of nobody", n)
+	}
+	if n := vali.Rejections(ReasonDropped); n != 5 {
+		t.Errorf("%d transaction(s) rejected as dropped, want 5", n)
+	}
+
+	if n := pendingBytes(); n != bobs {
+		t.Errorf("%d pending bytes, want %d", n, bobs)
+	}
+
+	// Bob's are left, still in order.
+	prio := math.MaxInt
+	for range 5 {
+		tx := vali.NextTransaction()
+		if tx == nil || tx.Fee.Payer != "bob" {
+			t.Fatalf("got %v, want bob's transaction", tx)
+		}
+		if tx.prio > prio {
+			t.Errorf("got priority %d after %d", tx.prio, prio)
+		}
+		prio = tx.prio
+	}
+	if tx := vali.NextTransaction(); tx != nil {
+		t.Errorf("got %v, want nothing left", tx)
+	}
+}
+
+func TestPriorityTransactions(t *testing.T) {
+	// Only the system is trusted with priority.
+	verify := func(tx *Transaction) error {
+		if tx.Fee.Payer != "system" {
+			return errors.New("untrusted")
+		}
+		return nil
+	}
+	balances := map[string]float64{"alice": 100, "bob": 100, "system": 100}
+	vali := newTestValidator(t, balances, WithPriorityVerifier(verify))
+	untrusting := newTestValidator(t, balances)
+
+	// prioritized asks for priority, paying the lowest fee.
+	prioritized := func(payer string) *Transaction {
+		tx := transfer(payer, "carol", 1, 0.1)
+		tx.Priority = true
+		tx.ID = tx.ComputeID()
+		return tx
+	}
+
+	receive(t, vali, transfer("alice", "carol", 1, 5))
+	receive(t, vali, transfer("bob", "carol", 1, 10))
+	system := prioritized("system")
+	receive(t, vali, system)
+	receive(t, vali, prioritized("alice"))
+	if n := vali.Rejections(ReasonUntrustedPriority); n != 1 {
+		t.Errorf("%d untrusted priority rejection(s), want 1", n)
+	}
+
+	var payers []string
+	for tx := vali.NextTransaction(); tx != nil; tx = vali.NextTransaction() {
+		payers = append(payers, tx.Fee.Payer)
+	}
+	if want := []string{"system", "bob", "alice"}; !slices.Equal(payers, want) {
+		t.Errorf("popped transactions of %v, want %v", payers, want)
+	}
+
+	// Rejected without a verifier, as anyone can ask.
+	receive(t, untrusting, system)
+	if n := untrusting.Rejections(ReasonUntrustedPriority); n != 1 || untrusting.PendingCount() != 0 {
+		t.Errorf("%d untrusted priority rejection(s) without a verifier, want 1", n)
+	}
+}
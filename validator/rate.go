@@ -0,0 +1,68 @@
+package validator
+
+import (
+	"sync"
+	"time"
+)
+
+// Seconds the acceptance rate is computed over, see AcceptanceRate.
+const acceptanceWindow = 60
+
+// rateWindow counts received and committed transactions per second
+// over the last acceptanceWindow seconds.
+type rateWindow struct {
+	mu      sync.Mutex
+	buckets [acceptanceWindow]rateBucket
+}
+
+type rateBucket struct {
+	second    int64 // Unix second the counts are for.
+	received  uint64
+	committed uint64
+}
+
+// add counts transactions at given time.
+func (window *rateWindow) add(now time.Time, received, committed uint64) {
+	second := now.Unix()
+
+	window.mu.Lock()
+	defer window.mu.Unlock()
+
+	bucket := &window.buckets[second%acceptanceWindow]
+	if bucket.second != second {
+		*bucket = rateBucket{second: second}
+	}
+	bucket.received += received
+	bucket.committed += committed
+}
+
+// rate returns committed over received transactions within the window
+// ending at given time, 0 if nothing's been received.
+func (window *rateWindow) rate(now time.Time) float64 {
+	second := now.Unix()
+
+	window.mu.Lock()
+	defer window.mu.Unlock()
+
+	var received, committed uint64
+	for _, bucket := range window.buckets {
+		if bucket.second > second-acceptanceWindow && bucket.second <= second {
+			received += bucket.received
+			committed += bucket.committed
+		}
+	}
+
+	if received == 0 {
+		return 0
+	}
+
+	// Transactions received before the window may be committed within it.
+	return min(float64(committed)/float64(received), 1)
+}
+
+// AcceptanceRate returns the share of transactions received over the last
+// minute that are committed, in range [0, 1]. Transactions still pending
+// count as not committed yet. Returns 0 if nothing's been received.
+func (vali *Validator) AcceptanceRate() float64 {
+	return vali.acceptance.rate(vali.clock.Now())
+}
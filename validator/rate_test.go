@@ -0,0 +1,51 @@
+package validator
+
+import (
+	"math"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+)
+
+func TestAcceptanceRate(t *testing.T) {
+	mock := clock.NewMock()
+	mock.Set(time.Unix(1000, 0))
+	vali := newTestValidator(t, map[string]float64{"alice": 100, "carol": 0},
+		WithClock(mock), WithSink(&recordingSink{}))
+
+	if rate := vali.AcceptanceRate(); rate != 0 {
+		t.Errorf("got rate %v before anything's received, want 0", rate)
+	}
+
+	// 3 in 4 committed, carol can't pay the fee.
+	for i := range 12 {
+		mock.Add(time.Second)
+		payer := "alice"
+		if i%4 == 3 {
+			payer = "carol"
+		}
+		vali.handleMessage(encode(t, transfer(payer, "bob", float64(i+1), 1)), netip.AddrPort{})
+	}
+	vali.drainIncoming()
+	_, err := vali.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rate := vali.AcceptanceRate(); math.Abs(rate-0.75) > 0.01 {
+		t.Errorf("got rate %v, want 0.75", rate)
+	}
+	var stats statsResponse
+	get(t, vali, "/stats", &stats)
+	if math.Abs(stats.AcceptanceRate-0.75) > 0.01 {
+		t.Errorf("got rate %v in stats, want 0.75", stats.AcceptanceRate)
+	}
+
+	// Forgotten once out of the window.
+	mock.Add(acceptanceWindow * time.Second)
+	if rate := vali.AcceptanceRate(); rate != 0 {
+		t.Errorf("got rate %v a minute later, want 0", rate)
+	}
+}
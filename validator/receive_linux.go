@@ -0,0 +1,72 @@
+//go:build linux
+
+package validator
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/netip"
+	"syscall"
+)
+
+// receiveBatched reads datagrams straight from the non-blocking socket
+// until it'd block, only then waiting for the poller to wake us up again.
+func (vali *Validator) receiveBatched() {
+	raw, err := vali.conn.SyscallConn()
+	if err != nil {
+		log.Printf("batched reads unavailable, falling back: %v", err)
+		vali.receive()
+		return
+	}
+
+	var buffer [maxMessageSize]byte
+	for {
+		closed := false
+		err := raw.Read(func(fd uintptr) bool {
+			for {
+				n, from, err := syscall.Recvfrom(int(fd), buffer[0:], 0)
+				switch {
+				case err == syscall.EINTR:
+					continue
+				case err == syscall.EAGAIN || err == syscall.EWOULDBLOCK:
+					// Drained, wait until it's readable again.
+					return false
+				case err != nil:
+					vali.readFailed(err)
+					return true
+				}
+
+				if !vali.handleMessage(buffer[0:n], addrPort(from)) {
+					closed = true
+					return true
+				}
+			}
+		})
+		if closed {
+			return
+		}
+
+		if err != nil {
+			// Read is interrupted by Close, we're done.
+			if vali.isClosed() || errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			vali.readFailed(err)
+		}
+	}
+}
+
+// addrPort converts a socket address to a netip one,
+// the zero value is returned for non-IP addresses.
+func addrPort(sa syscall.Sockaddr) netip.AddrPort {
+	switch sa := sa.(type) {
+	case *syscall.SockaddrInet4:
+		return netip.AddrPortFrom(netip.AddrFrom4(sa.Addr), uint16(sa.Port))
+	case *syscall.SockaddrInet6:
+		return netip.AddrPortFrom(netip.AddrFrom16(sa.Addr), uint16(sa.Port))
+	default:
+		return netip.AddrPort{}
+	}
+}
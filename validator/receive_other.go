@@ -0,0 +1,8 @@
+//go:build !linux
+
+package validator
+
+// receiveBatched is only implemented on Linux.
+func (vali *Validator) receiveBatched() {
+	vali.receive()
+}
@@ -0,0 +1,335 @@
+package validator
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCloseWhileReceiving(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	for _, batched := range []bool{false, true} {
+		logs.Reset()
+
+		vali := newTestValidator(t, map[string]float64{"alice": 100, "bob": 0}, WithBatchedReads(batched))
+
+		vali.wg.Add(1)
+		go vali.ReceiveTransactions()
+
+		conn, err := net.DialUDP("udp", nil, vali.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		// Keep sending until the validator is closed.
+		stop := make(chan struct{})
+		sent := make(chan struct{})
+		go func() {
+			defer close(sent)
+			msg := []byte(`{"fee": {"payer": "alice", "amount": 1}, "instructions": [{"account": "alice", "change": -1}, {"account": "bob", "change": 1}]}`)
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					conn.Write(msg)
+				}
+			}
+		}()
+
+		time.Sleep(50 * time.Millisecond)
+		err = vali.Close()
+		close(stop)
+		<-sent
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		done := make(chan struct{})
+		go func() {
+			vali.wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("batched %v: receiver didn't exit on Close", batched)
+		}
+
+		if n := vali.UDPReadErrors(); n != 0 {
+			t.Errorf("batched %v: %d read error(s) counted", batched, n)
+		}
+		if strings.Contains(logs.String(), "receiving a message") {
+			t.Errorf("batched %v: read errors logged on Close:\n%s", batched, logs.String())
+		}
+	}
+}
+
+func TestUDPReadErrors(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	vali := newTestValidator(t, map[string]float64{})
+
+	vali.readFailed(os.ErrDeadlineExceeded)
+	vali.readFailed(errors.New("connection refused"))
+	if n := vali.UDPReadErrors(); n != 2 {
+		t.Errorf("%d read error(s) counted, want 2", n)
+	}
+	if !strings.Contains(logs.String(), "timed out while receiving a message") {
+		t.Errorf("timeout isn't logged as one:\n%s", logs.String())
+	}
+	if !strings.Contains(logs.String(), "error while receiving a message: connection refused") {
+		t.Errorf("other error isn't logged as one:\n%s", logs.String())
+	}
+}
+
+func TestUDPReadErrorsWhileReceiving(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	vali := newTestValidator(t, map[string]float64{})
+	vali.wg.Add(1)
+	go vali.ReceiveTransactions()
+
+	// Reads time out until the deadline is lifted.
+	vali.conn.SetReadDeadline(time.Now())
+	waitFor(t, func() bool { return vali.UDPReadErrors() > 0 })
+	vali.conn.SetReadDeadline(time.Time{})
+
+	vali.Close()
+	vali.wg.Wait()
+}
+
+// BenchmarkReceive measures how many datagrams per second are received
+// and decoded, reading one datagram at a time or draining every one
+// that's ready per wakeup. Datagrams the socket drops aren't counted.
+func BenchmarkReceive(b *testing.B) {
+	msg := encode(b, transfer("alice", "bob", 1, 1))
+
+	for _, batched := range []bool{false, true} {
+		b.Run(fmt.Sprintf("batched=%v", batched), func(b *testing.B) {
+			vali := newTestValidator(b, map[string]float64{"alice": 100, "bob": 0}, WithBatchedReads(batched))
+			vali.wg.Add(1)
+			go vali.ReceiveTransactions()
+
+			var received atomic.Int64
+			go func() {
+				for {
+					select {
+					case <-vali.txCh:
+						received.Add(1)
+					case <-vali.done:
+						return
+					}
+				}
+			}()
+
+			conn, err := net.DialUDP("udp", nil, vali.Addr())
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer conn.Close()
+
+			b.ResetTimer()
+			start := time.Now()
+			for range b.N {
+				conn.Write(msg)
+			}
+			// Wait until the last of them are in, or dropped.
+			for last := int64(-1); received.Load() < int64(b.N) && received.Load() != last; {
+				last = received.Load()
+				time.Sleep(10 * time.Millisecond)
+			}
+			elapsed := time.Since(start)
+			b.StopTimer()
+
+			b.ReportMetric(float64(received.Load())/elapsed.Seconds(), "datagrams/s")
+			b.ReportMetric(1-float64(received.Load())/float64(b.N), "dropped")
+		})
+	}
+}
+
+func TestMultipleValidators(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	// Side by side in one process, each on a port of its own.
+	sinks := []*recordingSink{{}, {}}
+	validators := make([]*Validator, len(sinks))
+	for i, sink := range sinks {
+		validators[i] = newTestValidator(t, map[string]float64{"alice": 100, "bob": 0}, WithSink(sink))
+	}
+	if validators[0].Addr().Port == validators[1].Addr().Port {
+		t.Fatalf("both validators listen on port %d", validators[0].Addr().Port)
+	}
+
+	for _, vali := range validators {
+		go vali.Run()
+	}
+
+	// A transfer of a different amount to each.
+	for i, vali := range validators {
+		conn, err := net.DialUDP("udp", nil, vali.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		_, err = conn.Write(encode(t, transfer("alice", "bob", float64(10*(i+1)), 1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, vali := range validators {
+		waitFor(t, func() bool { return len(sinks[i].sent()) > 0 })
+		waitFor(t, func() bool {
+			balance, _ := vali.db.GetBalance("bob")
+			return balance != 0
+		})
+		if balance, _ := vali.db.GetBalance("bob"); balance != float64(10*(i+1)) {
+			t.Errorf("validator %d: bob has %v, want %v", i, balance, 10*(i+1))
+		}
+	}
+
+	for _, vali := range validators {
+		vali.Close()
+		vali.wg.Wait()
+	}
+}
+
+func TestUDPAck(t *testing.T) {
+	// ack sends msg to vali, returning what it answers, if anything.
+	ack := func(t *testing.T, vali *Validator, msg string) (submitResponse, bool) {
+		conn, err := net.DialUDP("udp", nil, vali.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		_, err = conn.Write([]byte(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		buffer := make([]byte, maxMessageSize)
+		n, err := conn.Read(buffer)
+		if err != nil {
+			return submitResponse{}, false
+		}
+
+		var response submitResponse
+		err = json.Unmarshal(buffer[:n], &response)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response, true
+	}
+
+	valid := `{"fee": {"payer": "alice", "amount": 1}, "instructions": [{"account": "alice", "change": -1}, {"account": "bob", "change": 1}]}`
+	for _, batched := range []bool{false, true} {
+		t.Run(fmt.Sprintf("batched=%v", batched), func(t *testing.T) {
+			vali := newTestValidator(t, map[string]float64{"alice": 100, "bob": 0}, WithBatchedReads(batched), WithUDPAck(true))
+			vali.wg.Add(1)
+			go vali.ReceiveTransactions()
+
+			response, ok := ack(t, vali, valid)
+			if !ok {
+				t.Fatal("no ack for a valid transaction")
+			}
+			if !response.Accepted || response.ID == "" {
+				t.Errorf("got %+v for a valid transaction", response)
+			}
+			if tx := <-vali.txCh; tx.ID != response.ID {
+				t.Errorf("acked %s, received %s", response.ID, tx.ID)
+			}
+
+			response, ok = ack(t, vali, `{"fee": {"payer": "alice", "amount": -1}, "instructions": [{"account": "bob", "change": 1}]}`)
+			if !ok {
+				t.Fatal("no ack for an invalid transaction")
+			}
+			if response.Accepted || response.Code != CodeNegativeFee {
+				t.Errorf("got %+v for an invalid transaction, want code %s", response, CodeNegativeFee)
+			}
+		})
+	}
+
+	// Disabled by default.
+	vali := newTestValidator(t, map[string]float64{"alice": 100, "bob": 0})
+	vali.wg.Add(1)
+	go vali.ReceiveTransactions()
+	if response, ok := ack(t, vali, valid); ok {
+		t.Errorf("got %+v, want no ack", response)
+	}
+}
+
+// Run with -race.
+func TestPauseAndResume(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	sink := &recordingSink{}
+	vali := newTestValidator(t, map[string]float64{"alice": 100}, WithSink(sink))
+	vali.Pause()
+	go vali.Run()
+	t.Cleanup(func() {
+		vali.Close()
+		vali.wg.Wait()
+	})
+
+	conn, err := net.DialUDP("udp", nil, vali.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const n = 5
+	for i := range n {
+		_, err := conn.Write(encode(t, transfer("alice", "bob", float64(i+1), 1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Still received, just not batched.
+	waitFor(t, func() bool { return vali.PendingCount() == n })
+	time.Sleep(20 * time.Millisecond)
+	if sent := sink.sent(); len(sent) != 0 {
+		t.Errorf("sent %d batch(es) while paused", len(sent))
+	}
+	if !vali.Paused() {
+		t.Error("validator isn't reported as paused")
+	}
+	var stats statsResponse
+	get(t, vali, "/stats", &stats)
+	if !stats.Paused {
+		t.Error("stats don't report the validator as paused")
+	}
+
+	vali.Resume()
+	waitFor(t, func() bool { return vali.PendingCount() == 0 })
+	if vali.Paused() {
+		t.Error("validator is reported as paused once resumed")
+	}
+	waitFor(t, func() bool {
+		sent := 0
+		for _, batch := range sink.sent() {
+			sent += len(batch)
+		}
+		return sent == n
+	})
+}
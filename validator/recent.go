@@ -0,0 +1,65 @@
+package validator
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// Number of committed batches kept for debugging, see RecentBatches.
+const recentBatchesSize = 32
+
+// batchRing keeps the most recently committed batches.
+type batchRing struct {
+	mu      sync.Mutex
+	batches [recentBatchesSize][]*Transaction
+	count   uint64 // Batches added so far.
+}
+
+// add remembers a committed batch, forgetting the oldest one if full.
+func (ring *batchRing) add(batch []*Transaction) {
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+
+	ring.batches[ring.count%recentBatchesSize] = slices.Clone(batch)
+	ring.count++
+}
+
+// recent returns up to n batches, newest first.
+func (ring *batchRing) recent(n int) [][]*Transaction {
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+
+	n = int(min(uint64(max(n, 0)), ring.count, recentBatchesSize))
+	batches := make([][]*Transaction, 0, n)
+	for i := range uint64(n) {
+		batches = append(batches, ring.batches[(ring.count-1-i)%recentBatchesSize])
+	}
+
+	return batches
+}
+
+// RecentBatches returns up to n of the most recently committed batches,
+// newest first. Only the last 32 batches are remembered. Transactions
+// are shared with the validator and must not be modified.
+func (vali *Validator) RecentBatches(n int) [][]*Transaction {
+	return vali.recentBatches.recent(n)
+}
+
+// handleRecentBatches serves recently committed batches, newest first.
+// The "n" query parameter limits how many, every remembered batch is
+// served by default.
+func (vali *Validator) handleRecentBatches(w http.ResponseWriter, r *http.Request) {
+	n := recentBatchesSize
+	if param := r.URL.Query().Get("n"); param != "" {
+		var err error
+		n, err = strconv.Atoi(param)
+		if err != nil || n < 0 {
+			http.Error(w, "n must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, vali.RecentBatches(n))
+}
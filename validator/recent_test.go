@@ -0,0 +1,51 @@
+package validator
+
+import (
+	"net/http"
+	"testing"
+
+	"transactioner/models"
+)
+
+func TestRecentBatches(t *testing.T) {
+	vali := newTestValidator(t, map[string]float64{"alice": 1000}, WithSink(&recordingSink{}))
+
+	if batches := vali.RecentBatches(10); len(batches) != 0 {
+		t.Fatalf("got %d batch(es) before any, want 0", len(batches))
+	}
+
+	// A batch of one transfer each, of increasing amounts.
+	const n = recentBatchesSize + 5
+	ids := make([]string, n)
+	for i := range n {
+		tx := transfer("alice", "bob", float64(i+1), 1)
+		ids[i] = tx.ComputeID()
+		receive(t, vali, tx)
+		_, err := vali.Flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	batches := vali.RecentBatches(2 * n)
+	if len(batches) != recentBatchesSize {
+		t.Fatalf("got %d batch(es), want %d", len(batches), recentBatchesSize)
+	}
+	// Newest first.
+	for i, batch := range batches {
+		if want := ids[n-1-i]; len(batch) != 1 || batch[0].ID != want {
+			t.Errorf("batch %d has %s, want %s", i, batch[0].ID, want)
+		}
+	}
+	if batches := vali.RecentBatches(3); len(batches) != 3 || batches[0][0].ID != ids[n-1] {
+		t.Errorf("got %d batch(es) for 3, want the newest 3", len(batches))
+	}
+
+	var served [][]models.Transaction
+	if code := get(t, vali, "/batches/recent?n=2", &served); code != http.StatusOK || len(served) != 2 {
+		t.Errorf("got %d with %d batch(es), want 200 with 2", code, len(served))
+	}
+	if code := get(t, vali, "/batches/recent?n=-1", nil); code != http.StatusBadRequest {
+		t.Errorf("got %d for a negative n, want 400", code)
+	}
+}
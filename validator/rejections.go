@@ -0,0 +1,132 @@
+package validator
+
+import (
+	"cmp"
+	"container/heap"
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Number of rejections kept for debugging, see RecentRejections.
+const rejectionSampleSize = 256
+
+// Rejections after which a rejection is e times less likely to be
+// sampled than a new one.
+const rejectionDecay = rejectionSampleSize
+
+// RejectionRecord is a rejected transaction kept for debugging.
+type RejectionRecord struct {
+	Time   time.Time  `json:"time"`
+	Reason DropReason `json:"reason"`
+	Error  string     `json:"error,omitempty"`
+	ID     string     `json:"id,omitempty"`    // Empty if it couldn't be decoded.
+	Payer  string     `json:"payer,omitempty"` // Empty if it couldn't be decoded.
+}
+
+// rejectionSample is a bounded sample of rejections weighted towards
+// recent ones, so it gives representative examples without keeping
+// every rejection.
+//
+// It's weighted reservoir sampling (A-Res) where the weight of a
+// rejection grows exponentially with its sequence number. Keys are kept
+// in log space, by the Gumbel trick, so they never overflow.
+type rejectionSample struct {
+	mu      sync.Mutex
+	seq     uint64
+	records sampledRejections
+}
+
+type sampledRejection struct {
+	key    float64
+	seq    uint64
+	record RejectionRecord
+}
+
+// add offers a record to the sample, u is uniform in (0, 1).
+func (sample *rejectionSample) add(record RejectionRecord, u float64) {
+	sample.mu.Lock()
+	defer sample.mu.Unlock()
+
+	sample.seq++
+	item := sampledRejection{
+		key:    float64(sample.seq)/rejectionDecay - math.Log(-math.Log(u)),
+		seq:    sample.seq,
+		record: record,
+	}
+
+	if len(sample.records) < rejectionSampleSize {
+		heap.Push(&sample.records, item)
+		return
+	}
+
+	if item.key > sample.records[0].key {
+		sample.records[0] = item
+		heap.Fix(&sample.records, 0)
+	}
+}
+
+// recent returns up to n sampled records, most recent first.
+func (sample *rejectionSample) recent(n int) []RejectionRecord {
+	sample.mu.Lock()
+	items := slices.Clone(sample.records)
+	sample.mu.Unlock()
+
+	slices.SortFunc(items, func(a, b sampledRejection) int {
+		return cmp.Compare(b.seq, a.seq)
+	})
+
+	n = min(max(n, 0), len(items))
+	records := make([]RejectionRecord, 0, n)
+	for _, item := range items[:n] {
+		records = append(records, item.record)
+	}
+
+	return records
+}
+
+// sampledRejections is a min-heap of sampled rejections by key.
+type sampledRejections []sampledRejection
+
+func (h sampledRejections) Len() int {
+	return len(h)
+}
+
+func (h sampledRejections) Less(i, j int) bool {
+	return h[i].key < h[j].key
+}
+
+func (h sampledRejections) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *sampledRejections) Push(item any) {
+	*h = append(*h, item.(sampledRejection))
+}
+
+func (h *sampledRejections) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[0 : n-1]
+	return item
+}
+
+// sampleRejection offers a dropped transaction to the rejection sample.
+func (vali *Validator) sampleRejection(record RejectionRecord) {
+	vali.randMu.Lock()
+	u := vali.rand.Float64()
+	vali.randMu.Unlock()
+
+	// Float64 can be 0, whose log isn't finite.
+	u = max(u, math.SmallestNonzeroFloat64)
+	vali.rejections.add(record, u)
+}
+
+// RecentRejections returns up to n rejected transactions, most recent
+// first, out of a bounded sample weighted towards recent rejections.
+// Only transactions dropped for good are sampled; deferred ones aren't.
+func (vali *Validator) RecentRejections(n int) []RejectionRecord {
+	return vali.rejections.recent(n)
+}
@@ -0,0 +1,50 @@
+package validator
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestRecentRejections(t *testing.T) {
+	vali := newTestValidator(t, map[string]float64{"alice": 100, "carol": 0},
+		WithSink(&recordingSink{}))
+
+	if got := vali.RecentRejections(10); len(got) != 0 {
+		t.Fatalf("got %d rejection(s) before any, want 0", len(got))
+	}
+
+	vali.handleMessage([]byte(`{"fee": `), netip.AddrPort{})
+	broke := transfer("carol", "bob", 1, 1)
+	receive(t, vali, broke)
+	receive(t, vali, transfer("alice", "bob", 1, 1))
+	_, err := vali.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := vali.RecentRejections(10)
+	if len(got) != 2 {
+		t.Fatalf("got %d rejection(s), want 2", len(got))
+	}
+	// Most recent first.
+	if got[0].Reason != ReasonFeeCheck || got[0].ID != broke.ComputeID() || got[0].Payer != "carol" {
+		t.Errorf("got %+v for the unpaid transaction", got[0])
+	}
+	if got[1].Reason != ReasonMalformed || got[1].ID != "" || got[1].Error == "" || got[1].Time.IsZero() {
+		t.Errorf("got %+v for the malformed message", got[1])
+	}
+	if got := vali.RecentRejections(1); len(got) != 1 || got[0].ID != broke.ComputeID() {
+		t.Errorf("got %+v for the last rejection", got)
+	}
+	if got := vali.RecentRejections(-1); len(got) != 0 {
+		t.Errorf("got %d rejection(s) for a negative count, want 0", len(got))
+	}
+
+	// Way more rejections than the sample holds.
+	for range 4 * rejectionSampleSize {
+		vali.handleMessage([]byte(`{"fee": `), netip.AddrPort{})
+	}
+	if got := vali.RecentRejections(10 * rejectionSampleSize); len(got) != rejectionSampleSize {
+		t.Errorf("got %d rejection(s), want %d", len(got), rejectionSampleSize)
+	}
+}
@@ -0,0 +1,66 @@
+package validator
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+)
+
+// Replay reads NDJSON transactions (one transaction per line) from r
+// and processes them synchronously, without going through UDP.
+//
+// Transactions are decoded, scored, batched, committed and sent exactly
+// like the ones received over the network; malformed lines are skipped.
+// Replay returns once every transaction is either committed or can't be
+// included in any further batch, the latter are left pending. An error is
+// only returned if reading from r fails.
+//
+// Replay must not be called while the validator is running.
+func (vali *Validator) Replay(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	// Let the scanner read lines bigger than the message limit,
+	// decodeTransaction is the one deciding what's too large.
+	scanner.Buffer(make([]byte, 0, maxMessageSize), 64*maxMessageSize)
+
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		tx, err := vali.decodeTransaction(line)
+		if err != nil {
+			vali.rejectDecoding(line, err)
+			continue
+		}
+
+		vali.enqueue(tx)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	for vali.PendingCount() > 0 {
+		// Non-commutative transactions are pending again,
+		// to try against the state we've just committed.
+		batch, deferred, _ := vali.processBatch()
+
+		// An empty batch means pending set was drained without any progress,
+		// deferred transactions can't be commutative with the current state.
+		// They're left pending, in case the validator runs afterwards.
+		if len(batch) == 0 {
+			if len(deferred) > 0 {
+				log.Printf("replay: %d transaction(s) could not be batched, left pending", len(deferred))
+			}
+
+			break
+		}
+	}
+
+	// Send out what's held back for coalescing.
+	vali.flushHeld()
+
+	return nil
+}
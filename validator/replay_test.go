@@ -0,0 +1,62 @@
+package validator
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReplay(t *testing.T) {
+	sink := &recordingSink{}
+	vali := newTestValidator(t, map[string]float64{"alice": 100, "bob": 1, "carol": 0}, WithSink(sink))
+
+	// Bob can pay the fee, but the transfer only once alice's is committed.
+	ndjson := `{"fee": {"payer": "alice", "amount": 1}, "instructions": [{"account": "alice", "change": -50}, {"account": "bob", "change": 50}]}
+{"fee": {"payer": "bob", "amount": 1}, "instructions": [{"account": "bob", "change": -20}, {"account": "carol", "change": 20}]}
+
+not a transaction
+{"fee": {"payer": "carol", "amount": 1}, "instructions": [{"account": "carol", "change": -5}]}
+`
+	err := vali.Replay(strings.NewReader(ndjson))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]float64{"alice": 49, "bob": 30, "carol": 20, "validator": 2}
+	for account, amount := range want {
+		balance, _ := vali.db.GetBalance(account)
+		if balance != amount {
+			t.Errorf("balance of %s is %v, want %v", account, balance, amount)
+		}
+	}
+
+	if n := vali.PendingCount(); n != 0 {
+		t.Errorf("%d transaction(s) left pending", n)
+	}
+	sent := 0
+	for _, batch := range sink.sent() {
+		sent += len(batch)
+	}
+	if sent != 2 {
+		t.Errorf("sent %d transaction(s), want 2", sent)
+	}
+	if n := vali.Rejections(ReasonMalformed); n != 1 {
+		t.Errorf("%d malformed rejection(s), want 1", n)
+	}
+}
+
+func TestReplayReturnsOnStuckTransactions(t *testing.T) {
+	vali := newTestValidator(t, map[string]float64{"alice": 10, "bob": 0}, WithSink(&recordingSink{}))
+
+	// Alice can pay the fee, but not the transfer.
+	ndjson := `{"fee": {"payer": "alice", "amount": 1}, "instructions": [{"account": "alice", "change": -5}, {"account": "bob", "change": 5}]}
+{"fee": {"payer": "alice", "amount": 1}, "instructions": [{"account": "alice", "change": -5}, {"account": "bob", "change": 5}]}
+`
+	err := vali.Replay(strings.NewReader(ndjson))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if balance, _ := vali.db.GetBalance("bob"); balance != 5 {
+		t.Errorf("bob got %v, want 5", balance)
+	}
+}
@@ -0,0 +1,74 @@
+package validator
+
+import (
+	"errors"
+)
+
+// InstructionEffect is the outcome of a single instruction of a
+// simulated transaction.
+type InstructionEffect struct {
+	Account string  `json:"account"`
+	Change  float64 `json:"change"`  // Referenced balances resolved.
+	Balance float64 `json:"balance"` // Balance of the account afterwards.
+}
+
+// Simulate previews the transaction against the current balances,
+// without changing anything. Returns the effect of every instruction in
+// order, after the fee is charged, the way a commit would apply them.
+// The error tells why the transaction wouldn't make it to a batch right
+// now, effects are still returned if the instructions could be walked.
+// Account names must already be normalized.
+func (vali *Validator) Simulate(tx *Transaction) ([]InstructionEffect, error) {
+	// References are resolved against the balances before the
+	// transaction, as they would at the start of a batch.
+	start := vali.db.Copy()
+	db := start.Copy()
+
+	if vali.debitsFrozen(db, tx) {
+		return nil, errors.New("transaction debits a frozen account")
+	}
+
+	if !vali.canPayFee(db, tx) {
+		return nil, errors.New("payer can't pay the fee")
+	}
+	chargeFee(db, tx)
+
+	candidate := *tx
+	err := candidate.resolveReferences(start)
+	if err != nil {
+		return nil, err
+	}
+
+	effects := make([]InstructionEffect, 0, len(candidate.Instructions))
+	for i, instr := range candidate.Instructions {
+		change, err := resolveChange(instr.Change)
+		if err != nil {
+			return effects, err
+		}
+
+		var amount float64
+		switch change := change.(type) {
+		case float64:
+			amount = change
+		case map[string]any:
+			amount = candidate.refs[i]
+		default:
+			return effects, errors.New("unexpected JSON format")
+		}
+
+		balance, _ := db.GetBalance(instr.Account)
+		db.SetBalance(instr.Account, balance+amount)
+		effects = append(effects, InstructionEffect{Account: instr.Account, Change: amount, Balance: balance + amount})
+	}
+
+	// Whether it'd be accepted is up to the same check batches are built by.
+	ok, err := vali.isCommutative(&candidate, nil, start, start.Copy())
+	if err != nil {
+		return effects, err
+	}
+	if !ok {
+		return effects, errors.New("operation causes balance to go negative")
+	}
+
+	return effects, nil
+}
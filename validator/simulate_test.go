@@ -0,0 +1,67 @@
+package validator
+
+import (
+	"maps"
+	"slices"
+	"testing"
+	adb "transactioner/accountsdb"
+	"transactioner/models"
+)
+
+func TestSimulate(t *testing.T) {
+	balances := map[string]float64{"alice": 100, "bob": 20, "carol": 0, "dave": 5}
+	vali := newTestValidator(t, balances)
+
+	tx := &Transaction{Transaction: models.Transaction{
+		Fee: models.Fee{Payer: "alice", Amount: 1},
+		Instructions: []models.Instruction{
+			{Account: "alice", Change: -10.0},
+			{Account: "bob", Change: 7.0},
+			{Account: "carol", Change: 3.0},
+			{Account: "carol", Change: map[string]any{"account": "dave", "sign": "plus"}},
+			{Account: "dave", Change: map[string]any{"account": "dave", "sign": "minus"}},
+			{Account: "bob", Change: -2.0},
+			{Account: "alice", Change: 2.0},
+		},
+	}}
+
+	effects, err := vali.Simulate(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Fee first, then every instruction on what the previous ones left.
+	effectsWant := []InstructionEffect{
+		{"alice", -10, 89},
+		{"bob", 7, 27},
+		{"carol", 3, 3},
+		{"carol", 5, 8},
+		{"dave", -5, 0},
+		{"bob", -2, 25},
+		{"alice", 2, 91},
+	}
+	if !slices.Equal(effects, effectsWant) {
+		t.Errorf("got effects %v, want %v", effects, effectsWant)
+	}
+
+	// Effects come along with why it wouldn't be accepted.
+	overdraw := transfer("bob", "carol", 50, 1)
+	effects, err = vali.Simulate(overdraw)
+	if err == nil {
+		t.Error("simulated an overdraw without an error")
+	}
+	if effectsWant := []InstructionEffect{{"bob", -50, -31}, {"carol", 50, 50}}; !slices.Equal(effects, effectsWant) {
+		t.Errorf("got effects %v for an overdraw, want %v", effects, effectsWant)
+	}
+
+	if _, err := vali.Simulate(transfer("carol", "bob", 0, 1)); err == nil {
+		t.Error("simulated a transaction whose payer can't pay the fee")
+	}
+
+	want := maps.Clone(balances)
+	want[adb.ValidatorAccount] = 0
+	// Nothing is committed.
+	if got := vali.db.Balances(); !maps.Equal(got, want) {
+		t.Errorf("balances are %v after simulating", got)
+	}
+}
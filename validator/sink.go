@@ -0,0 +1,165 @@
+package validator
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"maps"
+	"net/http"
+	"strconv"
+)
+
+// Sink is where committed batches are delivered to.
+type Sink interface {
+	// Send delivers the batch. Returns the status reported by the receiving
+	// end, following HTTP status code semantics (2xx means accepted), or an
+	// error if the batch couldn't be delivered at all.
+	Send(batch []*Transaction) (int, error)
+}
+
+// Deltas are net balance changes of accounts, by account name.
+type Deltas map[string]float64
+
+// compact removes accounts with no net change.
+func (deltas Deltas) compact() Deltas {
+	maps.DeleteFunc(deltas, func(_ string, delta float64) bool {
+		return delta == 0
+	})
+
+	return deltas
+}
+
+// merge adds the changes of other to deltas.
+func (deltas Deltas) merge(other Deltas) {
+	for account, delta := range other {
+		deltas[account] += delta
+	}
+}
+
+// DeltaSink is a sink that also accepts compacted batches, which carry
+// only the net change of every account. See WithBatchCompaction.
+type DeltaSink interface {
+	Sink
+	// SendDeltas delivers a compacted batch, see Send.
+	SendDeltas(deltas Deltas) (int, error)
+}
+
+// RangeSink is a sink that's told the indexes of the committed batches
+// it's sent, so that the receiving end knows which batches a send covers
+// when they're coalesced, see WithBatchCoalescing.
+type RangeSink interface {
+	Sink
+	// SendRange is Send for the batches with indexes in range
+	// [first, last], a single one unless they're coalesced.
+	SendRange(batch []*Transaction, first, last uint64) (int, error)
+}
+
+// RangeDeltaSink is RangeSink for compacted batches.
+type RangeDeltaSink interface {
+	DeltaSink
+	// SendDeltasRange is SendDeltas for the batches with
+	// indexes in range [first, last], see SendRange.
+	SendDeltasRange(deltas Deltas, first, last uint64) (int, error)
+}
+
+// HTTPSink sends batches as JSON to a batch collector, or in the
+// encoding of its codec. The Merkle root of the batch is sent along
+// in the X-Batch-Root header, hex encoded. See BatchRoot. Batches the
+// validator commits carry their indexes in the X-Batch-Range header,
+// see SendRange.
+type HTTPSink struct {
+	Client *http.Client
+	Method string // HTTP method, POST if empty.
+	URL    string
+	Codec  Codec // Encoding of batches, JSON if nil.
+}
+
+// NewHTTPSink creates a sink posting batches to given URL.
+func NewHTTPSink(url string) *HTTPSink {
+	return &HTTPSink{Client: &http.Client{}, Method: http.MethodPost, URL: url}
+}
+
+// isHTTPMethod returns true if method is one of the standard HTTP methods.
+func isHTTPMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodConnect,
+		http.MethodOptions, http.MethodTrace:
+		return true
+	default:
+		return false
+	}
+}
+
+func (sink *HTTPSink) Send(batch []*Transaction) (int, error) {
+	return sink.send(batch, batchHeader(batch))
+}
+
+// SendRange is Send with the X-Batch-Range header set to the indexes of
+// the batches sent, e.g. "3-5", or "3-3" for a single batch.
+func (sink *HTTPSink) SendRange(batch []*Transaction, first, last uint64) (int, error) {
+	header := batchHeader(batch)
+	header.Set("X-Batch-Range", batchRange(first, last))
+	return sink.send(batch, header)
+}
+
+// batchHeader returns the headers sent along with the batch.
+func batchHeader(batch []*Transaction) http.Header {
+	root := BatchRoot(batch)
+	return http.Header{"X-Batch-Root": {hex.EncodeToString(root[:])}}
+}
+
+// SendDeltas sends a compacted batch as a JSON object of
+// net changes by account, e.g. {"alice": -10, "bob": 9, "validator": 1}.
+// The X-Batch-Compacted header is set to tell it apart from a batch.
+func (sink *HTTPSink) SendDeltas(deltas Deltas) (int, error) {
+	return sink.send(deltas, http.Header{"X-Batch-Compacted": {"true"}})
+}
+
+// SendDeltasRange is SendDeltas with the X-Batch-Range header set,
+// see SendRange.
+func (sink *HTTPSink) SendDeltasRange(deltas Deltas, first, last uint64) (int, error) {
+	header := http.Header{"X-Batch-Compacted": {"true"}}
+	header.Set("X-Batch-Range", batchRange(first, last))
+	return sink.send(deltas, header)
+}
+
+// batchRange formats indexes of batches as "first-last".
+func batchRange(first, last uint64) string {
+	return strconv.FormatUint(first, 10) + "-" + strconv.FormatUint(last, 10)
+}
+
+// send sends v encoded by the codec, along with given headers.
+func (sink *HTTPSink) send(v any, header http.Header) (int, error) {
+	codec := sink.Codec
+	if codec == nil {
+		codec = JSONCodec{}
+	}
+
+	buffer, err := codec.Marshal(v)
+	if err != nil {
+		return 0, err
+	}
+
+	method := sink.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	req, err := http.NewRequest(method, sink.URL, bytes.NewBuffer(buffer))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", codec.ContentType())
+	maps.Copy(req.Header, header)
+
+	res, err := sink.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	// We don't care about the body, drain it so the connection can be reused.
+	io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+
+	return res.StatusCode, nil
+}
@@ -0,0 +1,325 @@
+package validator
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	adb "transactioner/accountsdb"
+	"transactioner/models"
+)
+
+func TestSendBatchReturnsStatus(t *testing.T) {
+	var status int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	vali := newTestValidator(t, map[string]float64{}, WithBatchEndpoint(server.URL))
+	for _, status = range []int{http.StatusOK, http.StatusAccepted, http.StatusBadRequest,
+		http.StatusRequestEntityTooLarge, http.StatusInternalServerError} {
+		got, err := vali.SendBatch([]*Transaction{transfer("alice", "bob", 1, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != status {
+			t.Errorf("got status %d, want %d", got, status)
+		}
+	}
+}
+
+func TestSendBatchUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	vali := newTestValidator(t, map[string]float64{}, WithBatchEndpoint(server.URL))
+	_, err := vali.SendBatch([]*Transaction{transfer("alice", "bob", 1, 1)})
+	if err == nil {
+		t.Error("sending to a closed server succeeded")
+	}
+}
+
+func TestBatchMethodAndPath(t *testing.T) {
+	tests := []struct {
+		method, path string
+	}{
+		{"", "/"},
+		{http.MethodPost, "/"},
+		{http.MethodPut, "/v1/batches"},
+		{http.MethodPatch, "/collector/v2/batches"},
+	}
+	for _, test := range tests {
+		var method, path string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			method, path = r.Method, r.URL.Path
+		}))
+		defer server.Close()
+
+		opts := []Option{WithBatchEndpoint(server.URL + test.path)}
+		if test.method != "" {
+			opts = append(opts, WithBatchMethod(test.method))
+		}
+		vali := newTestValidator(t, map[string]float64{}, opts...)
+		_, err := vali.SendBatch([]*Transaction{transfer("alice", "bob", 1, 1)})
+		vali.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := test.method
+		if want == "" {
+			want = http.MethodPost
+		}
+		if method != want || path != test.path {
+			t.Errorf("sent with %s %s, want %s %s", method, path, want, test.path)
+		}
+	}
+}
+
+func TestInvalidBatchMethod(t *testing.T) {
+	for _, method := range []string{"", "post", "FETCH", "GET /"} {
+		vali, err := NewFromSnapshot(writeSnapshot(t, map[string]float64{}),
+			WithListenAddr("127.0.0.1:0"), WithBatchMethod(method))
+		if err == nil {
+			vali.Close()
+			t.Errorf("method %q accepted", method)
+		}
+	}
+}
+
+func TestTransactionIDInBatch(t *testing.T) {
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	vali := newTestValidator(t, map[string]float64{"alice": 100, "bob": 0}, WithBatchEndpoint(server.URL))
+
+	// Without an ID, one is given; a wrong one is rejected.
+	msg := `{"fee": {"payer": "alice", "amount": 1}, "instructions": [{"account": "alice", "change": -5}, {"account": "bob", "change": 5}]}`
+	for _, msg := range []string{msg, `{"id": "00", ` + msg[1:]} {
+		tx, err := vali.decodeTransaction([]byte(msg))
+		if err != nil {
+			vali.rejectDecoding([]byte(msg), err)
+			continue
+		}
+		vali.PushTransaction(tx)
+	}
+	if n := vali.Rejections(ReasonInvalid); n != 1 {
+		t.Errorf("%d invalid rejection(s), want 1", n)
+	}
+
+	batches := processAll(t, vali)
+	if len(batches) != 1 || len(batches[0]) != 1 {
+		t.Fatalf("committed %v, want a single transaction", batches)
+	}
+	batch := batches[0]
+	id := batch[0].ComputeID()
+	if batch[0].ID != id {
+		t.Errorf("ID %q, want the content hash %s", batch[0].ID, id)
+	}
+
+	_, err := vali.SendBatch(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sent []models.Transaction
+	err = json.Unmarshal(body, &sent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 1 || sent[0].ID != id {
+		t.Errorf("sent %+v, want the transaction with ID %s", sent, id)
+	}
+	if _, ok := vali.WasCommitted(id); !ok {
+		t.Errorf("transaction %s isn't known as committed", id)
+	}
+}
+
+func TestBatchCompaction(t *testing.T) {
+	var deltas Deltas
+	var compacted, batchRange string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		compacted = r.Header.Get("X-Batch-Compacted")
+		batchRange = r.Header.Get("X-Batch-Range")
+		err := json.NewDecoder(r.Body).Decode(&deltas)
+		if err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	vali := newTestValidator(t, map[string]float64{"alice": 100, "bob": 100, "carol": 100, "dave": 100},
+		WithBatchEndpoint(server.URL), WithBatchCompaction(true))
+
+	// Offsetting transfers, dave's cancel out.
+	vali.PushTransaction(transfer("alice", "bob", 10, 1))
+	vali.PushTransaction(transfer("bob", "alice", 4, 1))
+	vali.PushTransaction(transfer("carol", "dave", 3, 1))
+	vali.PushTransaction(transfer("dave", "carol", 3, 0))
+	batch, err := vali.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sum of the original instructions and fees.
+	want := make(Deltas)
+	for _, tx := range batch {
+		for _, instr := range tx.Instructions {
+			want[instr.Account] += instr.Change.(float64)
+		}
+		want[tx.Fee.Payer] -= tx.Fee.Amount
+		want[adb.ValidatorAccount] += tx.Fee.Amount
+	}
+	maps.DeleteFunc(want, func(_ string, delta float64) bool { return delta == 0 })
+	if _, ok := want["dave"]; ok {
+		t.Fatal("dave's transfers don't cancel out")
+	}
+
+	if compacted != "true" {
+		t.Errorf("X-Batch-Compacted is %q, want true", compacted)
+	}
+	if batchRange != "0-0" {
+		t.Errorf("X-Batch-Range is %q, want 0-0", batchRange)
+	}
+	if !maps.Equal(deltas, want) {
+		t.Errorf("sent %v, want %v", deltas, want)
+	}
+
+	// Committed locally as they are.
+	if n := len(batch); n != 4 {
+		t.Errorf("committed %d transaction(s), want 4", n)
+	}
+	if balance, _ := vali.db.GetBalance("alice"); balance != 93 {
+		t.Errorf("alice has %v, want 93", balance)
+	}
+
+	// Sinks must take deltas.
+	_, err = NewFromSnapshot(writeSnapshot(t, map[string]float64{}), WithListenAddr("127.0.0.1:0"),
+		WithSink(&recordingSink{}), WithBatchCompaction(true))
+	if err == nil {
+		t.Error("compaction is accepted with a sink that can't take deltas")
+	}
+}
+
+func TestSplitOversizedBatches(t *testing.T) {
+	var mu sync.Mutex
+	var sizes []int
+	received := make(map[string]bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []models.Transaction
+		err := json.NewDecoder(r.Body).Decode(&batch)
+		if err != nil {
+			t.Error(err)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		sizes = append(sizes, len(batch))
+		if len(batch) > 50 {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		for _, tx := range batch {
+			received[tx.ID] = true
+		}
+	}))
+	defer server.Close()
+
+	vali := newTestValidator(t, map[string]float64{"alice": 1000}, WithBatchSize(120), WithBatchEndpoint(server.URL))
+	for i := range 120 {
+		vali.PushTransaction(transfer("alice", "bob", float64(i+1)/100, 1))
+	}
+	batch, err := vali.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// 120 is split in 60s, which are split in 30s.
+	if want := []int{120, 60, 30, 30, 60, 30, 30}; !slices.Equal(sizes, want) {
+		t.Errorf("sent batches of %v, want %v", sizes, want)
+	}
+	for _, tx := range batch {
+		if !received[tx.ID] {
+			t.Errorf("%s isn't received", tx.ID)
+		}
+	}
+	if len(received) != 120 {
+		t.Errorf("received %d transaction(s), want 120", len(received))
+	}
+	if n := vali.BatchSplits(); n != 3 {
+		t.Errorf("got %d split(s), want 3", n)
+	}
+}
+
+func TestRawBytesInBatch(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	for _, include := range []bool{false, true} {
+		bodies := make(chan []byte, 1)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			bodies <- body
+		}))
+		defer server.Close()
+
+		vali := newTestValidator(t, map[string]float64{"alice": 100, "bob": 0},
+			WithBatchEndpoint(server.URL), WithIncludeRawBytes(include))
+		go vali.Run()
+		t.Cleanup(func() {
+			vali.Close()
+			vali.wg.Wait()
+		})
+
+		conn, err := net.DialUDP("udp", nil, vali.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		// Keys out of order and spacing a re-encoded form wouldn't keep.
+		msg := []byte(`{ "instructions": [{"change": -5, "account": "alice"}, {"change": 5, "account": "bob"}],  "fee": {"amount": 1, "payer": "alice"} }`)
+		_, err = conn.Write(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var sent []struct {
+			Raw []byte `json:"raw"`
+		}
+		select {
+		case body := <-bodies:
+			err := json.Unmarshal(body, &sent)
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no batch sent")
+		}
+		if len(sent) != 1 {
+			t.Fatalf("sent %d transaction(s), want 1", len(sent))
+		}
+
+		if include && !bytes.Equal(sent[0].Raw, msg) {
+			t.Errorf("sent raw bytes %q, want %q", sent[0].Raw, msg)
+		}
+		// Disabled by default.
+		if !include && sent[0].Raw != nil {
+			t.Errorf("sent raw bytes %q while disabled", sent[0].Raw)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package validator
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	adb "transactioner/accountsdb"
+)
+
+// loadSnapshot initializes the db from given snapshot, which is either
+// a local file path or an http(s) URL to fetch it from.
+func (vali *Validator) loadSnapshot(snapshot string, opts ...adb.Option) (*adb.AccountsDb, error) {
+	if !strings.HasPrefix(snapshot, "http://") && !strings.HasPrefix(snapshot, "https://") {
+		return adb.InitFromSnapshot(snapshot, opts...)
+	}
+
+	client := &http.Client{Timeout: vali.snapshotFetchTimeout}
+	res, err := client.Get(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, fmt.Errorf("fetching snapshot: unexpected status %s", res.Status)
+	}
+
+	return adb.InitFromReader(res.Body, opts...)
+}
+
+// WriteSnapshot writes the current state of accounts to w
+// in accounts snapshot format.
+func (vali *Validator) WriteSnapshot(w io.Writer) error {
+	if vali.prettySnapshots {
+		return vali.db.WriteSnapshotIndent(w, "  ")
+	}
+
+	return vali.db.WriteSnapshot(w)
+}
+
+// Time between two snapshots, before jitter.
+const snapshotInterval = time.Second
+
+// TakeSnapshots writes the current state of accounts to
+// a new file in working directory every second, until
+// the validator is closed. A snapshot that fails to be written
+// is counted and reported, then retried on the next tick. Older
+// snapshots are removed if retention is set, see WithSnapshotRetention.
+func (vali *Validator) TakeSnapshots() {
+	defer vali.wg.Done()
+
+	for {
+		if vali.supplyCheck {
+			vali.checkSupply()
+		}
+
+		err := vali.writeSnapshotFile()
+		if err != nil {
+			vali.metrics.inc(snapshotFailuresSeries)
+			log.Printf("failed to write snapshot: %v", err)
+			if vali.onSnapshotFailure != nil {
+				vali.onSnapshotFailure(err)
+			}
+		} else if vali.snapshotRetention > 0 {
+			vali.pruneSnapshots()
+		}
+
+		select {
+		case <-vali.clock.After(vali.nextSnapshotInterval()):
+		case <-vali.done:
+			return
+		}
+	}
+}
+
+// nextSnapshotInterval returns how long to wait for the next snapshot,
+// a random duration within ±jitter of the snapshot interval.
+func (vali *Validator) nextSnapshotInterval() time.Duration {
+	if vali.snapshotJitter <= 0 {
+		return snapshotInterval
+	}
+
+	// Uniform in [-jitter, +jitter].
+	vali.randMu.Lock()
+	offset := time.Duration(vali.rand.Int64N(int64(2*vali.snapshotJitter+1))) - vali.snapshotJitter
+	vali.randMu.Unlock()
+
+	return max(snapshotInterval+offset, 0)
+}
+
+// snapshotName returns the name of a snapshot file taken at given time,
+// after given number of batches. See ParseSnapshotName.
+func snapshotName(unix int64, batchIdx uint64) string {
+	return fmt.Sprintf("accounts-%d-%d.json", unix, batchIdx)
+}
+
+// ParseSnapshotName returns the Unix time and batch index a snapshot
+// file is named after, e.g. 1700000000 and 42 for
+// "accounts-1700000000-42.json". Name may be a path, only its last
+// element is parsed.
+func ParseSnapshotName(name string) (unix int64, batchIdx uint64, err error) {
+	base := filepath.Base(name)
+
+	rest, ok := strings.CutPrefix(base, "accounts-")
+	if ok {
+		rest, ok = strings.CutSuffix(rest, ".json")
+	}
+	if !ok {
+		return 0, 0, fmt.Errorf("not a snapshot name: %q", base)
+	}
+
+	unixPart, idxPart, ok := strings.Cut(rest, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("not a snapshot name: %q", base)
+	}
+
+	unix, err = strconv.ParseInt(unixPart, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("snapshot %q: invalid time: %w", base, err)
+	}
+
+	batchIdx, err = strconv.ParseUint(idxPart, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("snapshot %q: invalid batch index: %w", base, err)
+	}
+
+	return unix, batchIdx, nil
+}
+
+// writeSnapshotFile writes a snapshot to a file named after
+// the current time and batch index. It's written to a temporary
+// file synced to disk first, then renamed, so that a crash never
+// leaves a partial file that's mistaken for a snapshot.
+func (vali *Validator) writeSnapshotFile() error {
+	name := snapshotName(vali.clock.Now().Unix(), vali.batchIdx.Load())
+	// In the same directory for the rename to be atomic,
+	// not matching snapshot names. See pruneSnapshots.
+	file, err := os.CreateTemp(".", name+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	err = vali.WriteSnapshot(file)
+	if err == nil {
+		err = file.Chmod(0644)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), name)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+
+	return err
+}
+
+// pruneSnapshots removes snapshot files of the working directory but the
+// latest ones, as many as retained. Files that aren't named like
+// snapshots are left alone.
+func (vali *Validator) pruneSnapshots() {
+	names, err := filepath.Glob("accounts-*.json")
+	if err != nil {
+		log.Printf("failed to list snapshots: %v", err)
+		return
+	}
+
+	type snapshot struct {
+		name     string
+		unix     int64
+		batchIdx uint64
+	}
+	snapshots := make([]snapshot, 0, len(names))
+	for _, name := range names {
+		unix, batchIdx, err := ParseSnapshotName(name)
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, snapshot{name, unix, batchIdx})
+	}
+
+	// Latest first.
+	slices.SortFunc(snapshots, func(a, b snapshot) int {
+		if c := cmp.Compare(b.unix, a.unix); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.batchIdx, a.batchIdx)
+	})
+
+	for _, snapshot := range snapshots[min(vali.snapshotRetention, len(snapshots)):] {
+		err := os.Remove(snapshot.name)
+		if err != nil {
+			log.Printf("failed to remove old snapshot: %v", err)
+		}
+	}
+}
@@ -0,0 +1,394 @@
+package validator
+
+import (
+	"bytes"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	adb "transactioner/accountsdb"
+
+	"github.com/benbjohnson/clock"
+)
+
+func TestWriteSnapshot(t *testing.T) {
+	for _, pretty := range []bool{false, true} {
+		vali := newTestValidator(t, map[string]float64{"alice": 100, "bob": 0},
+			WithPrettySnapshots(pretty))
+
+		receive(t, vali, transfer("alice", "bob", 10, 1))
+		processAll(t, vali)
+		err := vali.db.Freeze("bob")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buffer bytes.Buffer
+		err = vali.WriteSnapshot(&buffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vali.Close()
+		if pretty != bytes.Contains(buffer.Bytes(), []byte("\n  ")) {
+			t.Errorf("pretty %v: snapshot is\n%s", pretty, buffer.String())
+		}
+
+		db, err := adb.InitFromReader(&buffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, account := range []string{"alice", "bob"} {
+			got, err := db.GetBalance(account)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, _ := vali.db.GetBalance(account)
+			if got != want {
+				t.Errorf("pretty %v: reloaded balance of %q is %v, want %v", pretty, account, got, want)
+			}
+		}
+		if !db.IsFrozen("bob") {
+			t.Errorf("pretty %v: bob isn't frozen once reloaded", pretty)
+		}
+	}
+}
+
+func TestPrettySnapshots(t *testing.T) {
+	balances := map[string]float64{"alice": 100, "bob": 2.5}
+
+	var written [2][]byte
+	for i, pretty := range []bool{false, true} {
+		t.Chdir(t.TempDir())
+		vali := newTestValidator(t, balances, WithPrettySnapshots(pretty))
+		err := vali.db.SetOverdraft("bob", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = vali.writeSnapshotFile()
+		if err != nil {
+			t.Fatal(err)
+		}
+		vali.Close()
+		names, err := filepath.Glob("accounts-*.json")
+		if err != nil || len(names) != 1 {
+			t.Fatalf("pretty %v: snapshot files %v, error %v", pretty, names, err)
+		}
+		written[i], err = os.ReadFile(names[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	compact, pretty := written[0], written[1]
+	if bytes.Count(compact, []byte("\n")) != 1 {
+		t.Errorf("compact snapshot spans many lines:\n%s", compact)
+	}
+	if !bytes.Contains(pretty, []byte("\n  \"alice\": 100")) {
+		t.Errorf("pretty snapshot isn't indented:\n%s", pretty)
+	}
+
+	// Both load back the same.
+	for _, snapshot := range written {
+		db, err := adb.InitFromReader(bytes.NewReader(snapshot))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for account, want := range balances {
+			if balance, _ := db.GetBalance(account); balance != want {
+				t.Errorf("balance of %s is %v once reloaded, want %v", account, balance, want)
+			}
+		}
+		if account, _ := db.GetAccount("bob"); account.Overdraft != 10 {
+			t.Errorf("bob's overdraft is %v once reloaded, want 10", account.Overdraft)
+		}
+	}
+}
+
+func TestSnapshotFileLeavesNoTemporaryFile(t *testing.T) {
+	t.Chdir(t.TempDir())
+	vali := newTestValidator(t, map[string]float64{"alice": 100})
+	defer vali.Close()
+
+	err := vali.writeSnapshotFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("got %d files, want only the snapshot", len(entries))
+	}
+	if _, _, err := ParseSnapshotName(entries[0].Name()); err != nil {
+		t.Errorf("%s isn't a snapshot: %v", entries[0].Name(), err)
+	}
+	info, err := entries[0].Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0644 {
+		t.Errorf("snapshot has permissions %v, want 0644", perm)
+	}
+}
+
+func TestSnapshotJitter(t *testing.T) {
+	const jitter = 300 * time.Millisecond
+
+	vali := newTestValidator(t, map[string]float64{}, WithSnapshotJitter(jitter))
+	seen := make(map[time.Duration]bool)
+	for range 1000 {
+		interval := vali.nextSnapshotInterval()
+		if interval < snapshotInterval-jitter || interval > snapshotInterval+jitter {
+			t.Fatalf("interval %v is off by more than %v", interval, jitter)
+		}
+		seen[interval] = true
+	}
+	if len(seen) < 100 {
+		t.Errorf("only %d distinct intervals out of 1000", len(seen))
+	}
+	vali.Close()
+
+	vali = newTestValidator(t, map[string]float64{})
+	if interval := vali.nextSnapshotInterval(); interval != snapshotInterval {
+		t.Errorf("interval without jitter is %v, want %v", interval, snapshotInterval)
+	}
+}
+
+func TestSnapshotJitterWithClock(t *testing.T) {
+	const jitter = 300 * time.Millisecond
+	t.Chdir(t.TempDir())
+
+	// A twin seeded the same tells which interval comes first.
+	twin := newTestValidator(t, map[string]float64{}, WithSnapshotJitter(jitter),
+		WithRand(rand.New(rand.NewPCG(1, 2))))
+	interval := twin.nextSnapshotInterval()
+
+	mock := clock.NewMock()
+	mock.Set(time.Unix(1000, 0))
+	vali := newTestValidator(t, map[string]float64{"alice": 1}, WithSnapshotJitter(jitter),
+		WithRand(rand.New(rand.NewPCG(1, 2))), WithClock(mock))
+
+	// Whether a snapshot has alice at given balance.
+	snapshotted := func(balance string) bool {
+		names, _ := filepath.Glob("accounts-*.json")
+		for _, name := range names {
+			data, _ := os.ReadFile(name)
+			if bytes.Contains(data, []byte(`"alice":`+balance)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Snapshots are taken until the test exits.
+	vali.wg.Add(1)
+	go vali.TakeSnapshots()
+
+	waitFor(t, func() bool { return snapshotted("1") })
+	vali.db.SetBalance("alice", 2)
+	// Let it start waiting for the next one.
+	time.Sleep(20 * time.Millisecond)
+
+	mock.Add(interval - time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if snapshotted("2") {
+		t.Fatalf("snapshot taken before the jittered interval of %v", interval)
+	}
+
+	mock.Add(time.Millisecond)
+	waitFor(t, func() bool { return snapshotted("2") })
+}
+
+func TestSnapshotWriteFailure(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	mock := clock.NewMock()
+	mock.Set(time.Unix(1000, 0))
+	var mu sync.Mutex
+	var reported []error
+	vali := newTestValidator(t, map[string]float64{"alice": 1}, WithClock(mock),
+		WithSnapshotFailureHandler(func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			reported = append(reported, err)
+		}))
+
+	// A directory in the way of the first snapshot.
+	err := os.Mkdir(snapshotName(1000, 0), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vali.wg.Add(1)
+	go vali.TakeSnapshots()
+	defer func() {
+		vali.Close()
+		vali.wg.Wait()
+	}()
+
+	waitFor(t, func() bool { return vali.SnapshotFailures() == 1 })
+	mu.Lock()
+	if len(reported) != 1 {
+		t.Errorf("%d failure(s) reported, want 1", len(reported))
+	}
+	mu.Unlock()
+
+	// Retried on the next tick.
+	time.Sleep(20 * time.Millisecond)
+	mock.Add(snapshotInterval)
+	retried := snapshotName(mock.Now().Unix(), 0)
+	waitFor(t, func() bool {
+		_, err := os.Stat(retried)
+		return err == nil
+	})
+	if n := vali.SnapshotFailures(); n != 1 {
+		t.Errorf("%d snapshot failure(s), want 1", n)
+	}
+}
+
+func TestSnapshotFromURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/accounts.json":
+			w.Write([]byte(`{"alice": 10, "bob": 2.5}`))
+		case "/slow.json":
+			time.Sleep(200 * time.Millisecond)
+			w.Write([]byte(`{"alice": 10}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	vali, err := NewFromSnapshot(server.URL+"/accounts.json", WithListenAddr("127.0.0.1:0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vali.Close()
+	want := map[string]float64{"alice": 10, "bob": 2.5, adb.ValidatorAccount: 0}
+	for account, want := range want {
+		if balance, _ := vali.db.GetBalance(account); balance != want {
+			t.Errorf("fetched balance of %s is %v, want %v", account, balance, want)
+		}
+	}
+
+	_, err = NewFromSnapshot(server.URL+"/missing.json", WithListenAddr("127.0.0.1:0"))
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("missing snapshot gave error %v, want a 404", err)
+	}
+
+	_, err = NewFromSnapshot(server.URL+"/slow.json", WithListenAddr("127.0.0.1:0"),
+		WithSnapshotFetchTimeout(50*time.Millisecond))
+	if err == nil {
+		t.Error("fetching a slow snapshot didn't time out")
+	}
+}
+
+func TestSameSeedSameJitter(t *testing.T) {
+	intervals := func(seed uint64) []time.Duration {
+		vali := newTestValidator(t, map[string]float64{}, WithSnapshotJitter(300*time.Millisecond),
+			WithRand(rand.New(rand.NewPCG(seed, seed))))
+
+		intervals := make([]time.Duration, 100)
+		for i := range intervals {
+			intervals[i] = vali.nextSnapshotInterval()
+		}
+		return intervals
+	}
+
+	a, b := intervals(1), intervals(1)
+	if !slices.Equal(a, b) {
+		t.Errorf("validators seeded the same have different jitter:\n%v\n%v", a, b)
+	}
+	if c := intervals(2); slices.Equal(a, c) {
+		t.Error("validators seeded differently have the same jitter")
+	}
+}
+
+func TestParseSnapshotName(t *testing.T) {
+	unix, batchIdx, err := ParseSnapshotName("/var/snapshots/" + snapshotName(1700000000, 42))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unix != 1700000000 || batchIdx != 42 {
+		t.Errorf("got %d-%d, want 1700000000-42", unix, batchIdx)
+	}
+
+	for _, name := range []string{
+		"",
+		"accounts.json",
+		"accounts-1700000000.json",
+		"accounts-1700000000-42",
+		"snapshot-1700000000-42.json",
+		"accounts-x-42.json",
+		"accounts-1700000000-x.json",
+		"accounts-1700000000--42.json",
+		"accounts-1700000000-42-1.json",
+	} {
+		_, _, err := ParseSnapshotName(name)
+		if err == nil {
+			t.Errorf("%q parsed as a snapshot name", name)
+		}
+	}
+}
+
+func TestSnapshotRetention(t *testing.T) {
+	t.Chdir(t.TempDir())
+	vali := newTestValidator(t, map[string]float64{}, WithSnapshotRetention(3))
+
+	// Ordered by time then batch index, not by name.
+	for _, name := range []string{
+		snapshotName(999, 50),
+		snapshotName(1000, 2),
+		snapshotName(1000, 10),
+		snapshotName(998, 60),
+		snapshotName(1001, 0),
+		"accounts.json",
+		"accounts-backup.json",
+	} {
+		err := os.WriteFile(name, []byte("{}"), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	vali.pruneSnapshots()
+
+	names, err := filepath.Glob("*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"accounts-1000-10.json",
+		"accounts-1000-2.json",
+		"accounts-1001-0.json",
+		"accounts-backup.json",
+		"accounts.json",
+	}
+	if !slices.Equal(names, want) {
+		t.Errorf("files left are %v, want %v", names, want)
+	}
+}
+
+// BenchmarkNextSnapshotInterval draws jittered intervals from the shared
+// random source of a validator, from every goroutine at once.
+func BenchmarkNextSnapshotInterval(b *testing.B) {
+	vali := newTestValidator(b, map[string]float64{}, WithSnapshotJitter(300*time.Millisecond),
+		WithRand(rand.New(rand.NewPCG(1, 1))))
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			vali.nextSnapshotInterval()
+		}
+	})
+}
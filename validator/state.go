@@ -0,0 +1,128 @@
+package validator
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	adb "transactioner/accountsdb"
+	"transactioner/models"
+)
+
+// state is everything a validator needs to pick up where another one
+// left off, see SaveState.
+type state struct {
+	BatchIdx  uint64           `json:"batchIdx"`
+	Accounts  adb.Accounts     `json:"accounts"`
+	Pending   []pendingState   `json:"pending"`
+	Committed []committedState `json:"committed,omitempty"` // Oldest first.
+}
+
+// pendingState is a pending transaction along with its priority,
+// which may not be what the restoring validator would score it.
+type pendingState struct {
+	Transaction models.Transaction `json:"transaction"`
+	Priority    int                `json:"priority"`
+	Raw         []byte             `json:"raw,omitempty"` // See WithIncludeRawBytes.
+}
+
+// SaveState writes the state of the validator to w as JSON: accounts,
+// index of the next batch, pending transactions and the window of
+// recently committed transaction IDs, see WasCommitted. A standby
+// validator can resume exactly where this one is by LoadState.
+// Transactions carry no nonces, their IDs are derived from their content.
+//
+// Batches held back for coalescing are sent before saving, since they're
+// already committed. Transactions received but not made pending yet
+// aren't part of the state.
+func (vali *Validator) SaveState(w io.Writer) error {
+	// No batch is built or committed meanwhile.
+	vali.processMu.Lock()
+	defer vali.processMu.Unlock()
+
+	err := vali.sendHeld()
+	if err != nil {
+		return err
+	}
+
+	vali.pendingMu.Lock()
+	txs := vali.pending.Transactions()
+	vali.pendingMu.Unlock()
+
+	saved := state{
+		BatchIdx:  vali.batchIdx.Load(),
+		Accounts:  vali.db.Accounts(),
+		Pending:   make([]pendingState, 0, len(txs)),
+		Committed: vali.committedIDs.save(),
+	}
+	for _, tx := range txs {
+		saved.Pending = append(saved.Pending, pendingState{Transaction: tx.Transaction, Priority: tx.prio, Raw: tx.Raw})
+	}
+
+	return json.NewEncoder(w).Encode(&saved)
+}
+
+// LoadState replaces the state of the validator by one written by
+// SaveState. Accounts, pending transactions and committed transaction
+// IDs are replaced, pending ones keeping their priorities. Batch indexes
+// continue from the saved one.
+//
+// Pending transactions are checked again as if they were just received,
+// since the saving validator may not have been configured like this one:
+// ones failing the checks, e.g. above its maximum fee, are dropped and
+// written to the dead-letter file if there's one.
+func (vali *Validator) LoadState(r io.Reader) error {
+	var saved state
+	err := json.NewDecoder(r).Decode(&saved)
+	if err != nil {
+		return err
+	}
+	if saved.Accounts == nil {
+		return errors.New("state has no accounts")
+	}
+
+	vali.processMu.Lock()
+	defer vali.processMu.Unlock()
+
+	err = vali.db.Restore(saved.Accounts)
+	if err != nil {
+		return err
+	}
+	vali.batchIdx.Store(saved.BatchIdx)
+	vali.expectedSupply = vali.db.TotalSupply()
+	vali.committedIDs.restore(saved.Committed)
+
+	vali.pendingMu.Lock()
+	defer vali.pendingMu.Unlock()
+
+	vali.pending.RemoveFunc(func(*Transaction) bool { return true })
+	vali.pendingBytes = 0
+	for _, pending := range saved.Pending {
+		tx := &Transaction{Transaction: pending.Transaction, Raw: pending.Raw}
+		vali.normalizeAccounts(tx)
+
+		// IDs are derived from account names as they were sent, which
+		// saved transactions no longer carry: they're kept as saved.
+		id := tx.ID
+		tx.ID = ""
+		checked, err := vali.checkTransaction(tx)
+		if err != nil {
+			tx.ID = id
+			var rejected *rejectError
+			if errors.As(err, &rejected) {
+				vali.drop(tx, rejected.reason, err)
+			} else {
+				vali.drop(tx, ReasonInvalid, err)
+			}
+			continue
+		}
+
+		if checked == tx {
+			checked.ID = id
+		}
+		checked.prio = pending.Priority
+		vali.push(checked)
+	}
+	vali.checkHeap()
+
+	return nil
+}
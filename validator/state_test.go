@@ -0,0 +1,155 @@
+package validator
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"strings"
+	"testing"
+	adb "transactioner/accountsdb"
+)
+
+func TestSaveAndLoadState(t *testing.T) {
+	primary := newTestValidator(t, map[string]float64{"alice": 100, "bob": 100},
+		WithBatchSize(1), WithSink(&recordingSink{}))
+
+	receive(t, primary, transfer("alice", "carol", 10, 3))
+	receive(t, primary, transfer("bob", "carol", 10, 2))
+	receive(t, primary, transfer("alice", "dave", 10, 1))
+	committed, err := primary.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Mid-run: a batch is committed, two transactions are pending.
+	var saved bytes.Buffer
+	err = primary.SaveState(&saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	standby := newTestValidator(t, map[string]float64{"mallory": 1}, WithBatchSize(1), WithSink(&recordingSink{}))
+	err = standby.LoadState(&saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := standby.db.Accounts(), primary.db.Accounts(); !maps.Equal(got, want) {
+		t.Errorf("got accounts %v, want %v", got, want)
+	}
+	if got, want := standby.batchIdx.Load(), primary.batchIdx.Load(); got != want {
+		t.Errorf("got batch index %d, want %d", got, want)
+	}
+	if idx, ok := standby.WasCommitted(committed[0].ID); !ok || idx != 0 {
+		t.Errorf("committed transaction is in batch %d (%v), want 0", idx, ok)
+	}
+
+	// Same transactions pending, in the same order.
+	if got, want := standby.PendingCount(), primary.PendingCount(); got != want {
+		t.Fatalf("got %d pending, want %d", got, want)
+	}
+	var pending []*Transaction
+	for range primary.PendingCount() {
+		got, want := standby.NextTransaction(), primary.NextTransaction()
+		if got.key() != want.key() || got.prio != want.prio {
+			t.Errorf("got %s with priority %d pending, want %s with %d", got.key(), got.prio, want.key(), want.prio)
+		}
+		pending = append(pending, got)
+	}
+	standby.requeue(pending)
+
+	// Batch indexes go on from the saved one.
+	batch, err := standby.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx, ok := standby.WasCommitted(batch[0].ID); !ok || idx != 1 {
+		t.Errorf("next batch is %d (%v), want 1", idx, ok)
+	}
+}
+
+func TestLoadStateChecksPending(t *testing.T) {
+	primary := newTestValidator(t, map[string]float64{"alice": 100, "bob": 100},
+		WithAccountNormalizer(adb.TrimLower))
+	receive(t, primary, transfer("Alice", "carol", 10, 2))
+	receive(t, primary, transfer("Bob", "carol", 10, 3))
+	ids := make(map[float64]string)
+	for _, tx := range primary.pending.Transactions() {
+		ids[tx.Fee.Amount] = tx.ID
+	}
+
+	var saved bytes.Buffer
+	err := primary.SaveState(&saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Standby is stricter about fees than the primary was.
+	standby := newTestValidator(t, map[string]float64{"mallory": 1},
+		WithAccountNormalizer(adb.TrimLower), WithMaxFee(2))
+	err = standby.LoadState(&saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := standby.PendingCount(); got != 1 {
+		t.Fatalf("got %d pending, want 1", got)
+	}
+	// Kept with the ID it's been received with.
+	if tx := standby.NextTransaction(); tx.ID != ids[2] {
+		t.Errorf("pending %s, want %s", tx.ID, ids[2])
+	}
+
+	rejections := standby.RecentRejections(10)
+	if len(rejections) != 1 || rejections[0].Reason != ReasonMaxFee || rejections[0].ID != ids[3] {
+		t.Errorf("got rejections %v, want %s above the maximum fee", rejections, ids[3])
+	}
+}
+
+func TestLoadInvalidState(t *testing.T) {
+	vali := newTestValidator(t, map[string]float64{"alice": 100})
+
+	for _, saved := range []string{``, `{"batchIdx": `, `{"batchIdx": 3}`} {
+		if err := vali.LoadState(strings.NewReader(saved)); err == nil {
+			t.Errorf("loaded %q", saved)
+		}
+	}
+	// Left as it is.
+	if balance, _ := vali.db.GetBalance("alice"); balance != 100 {
+		t.Errorf("alice has %v, want 100", balance)
+	}
+}
+
+// BenchmarkState saves and loads the state of a validator with many
+// accounts and pending transactions.
+func BenchmarkState(b *testing.B) {
+	balances := make(map[string]float64)
+	for i := range 10000 {
+		balances[fmt.Sprintf("account%d", i)] = 100
+	}
+	vali := newTestValidator(b, balances)
+	for i := range 1000 {
+		vali.PushTransaction(transfer(fmt.Sprintf("account%d", i), "bob", float64(i%10+1), 1))
+	}
+
+	var saved bytes.Buffer
+	b.Run("save", func(b *testing.B) {
+		for b.Loop() {
+			saved.Reset()
+			err := vali.SaveState(&saved)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(saved.Len()), "bytes")
+	})
+
+	b.Run("load", func(b *testing.B) {
+		for b.Loop() {
+			err := vali.LoadState(bytes.NewReader(saved.Bytes()))
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
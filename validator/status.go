@@ -0,0 +1,85 @@
+package validator
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+)
+
+// statusResponse tells UDP senders how loaded the validator is,
+// so well-behaved ones can throttle themselves.
+type statusResponse struct {
+	Pending         int `json:"pending"`
+	MaxPending      int `json:"maxPending,omitempty"` // 0 if unlimited.
+	PendingBytes    int `json:"pendingBytes"`
+	MaxPendingBytes int `json:"maxPendingBytes,omitempty"` // 0 if unlimited.
+	// Fullness of the pending set in range [0, 1], by whichever
+	// limit is closer. Always 0 if neither is set.
+	Load float64 `json:"load"`
+	// Suggested share of transactions to send, 1 - load.
+	AcceptProbability float64 `json:"acceptProbability"`
+}
+
+// status reports the current load.
+func (vali *Validator) status() statusResponse {
+	vali.pendingMu.Lock()
+	response := statusResponse{
+		Pending:         vali.pending.Len(),
+		MaxPending:      vali.maxPending,
+		PendingBytes:    vali.pendingBytes,
+		MaxPendingBytes: vali.maxPendingBytes,
+	}
+	vali.pendingMu.Unlock()
+
+	if response.MaxPending > 0 {
+		response.Load = float64(response.Pending) / float64(response.MaxPending)
+	}
+	if response.MaxPendingBytes > 0 {
+		response.Load = max(response.Load, float64(response.PendingBytes)/float64(response.MaxPendingBytes))
+	}
+	response.Load = min(response.Load, 1)
+	response.AcceptProbability = 1 - response.Load
+
+	return response
+}
+
+// ServeStatus answers every datagram received over given UDP address
+// with the current load, until the validator is closed. The content of
+// the datagram doesn't matter. See WithStatusAddr.
+func (vali *Validator) ServeStatus(addr string) {
+	defer vali.wg.Done()
+
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		log.Printf("status port stopped: %v", err)
+		return
+	}
+	go func() {
+		<-vali.done
+		conn.Close()
+	}()
+
+	buffer := make([]byte, 512)
+	for {
+		_, from, err := conn.ReadFrom(buffer)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			log.Printf("failed to read status request: %v", err)
+			continue
+		}
+
+		payload, err := json.Marshal(vali.status())
+		if err != nil {
+			log.Printf("failed to encode status: %v", err)
+			continue
+		}
+
+		_, err = conn.WriteTo(payload, from)
+		if err != nil {
+			log.Printf("failed to send status to %s: %v", from, err)
+		}
+	}
+}
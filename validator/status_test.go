@@ -0,0 +1,64 @@
+package validator
+
+import (
+	"encoding/json"
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestStatusNearlyFull(t *testing.T) {
+	// A free port, the status address can't be given as port 0.
+	reserved, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := reserved.LocalAddr().String()
+	reserved.Close()
+
+	vali := newTestValidator(t, map[string]float64{"alice": 100}, WithMaxPending(10), WithStatusAddr(addr))
+	vali.wg.Add(1)
+	go vali.ServeStatus(addr)
+	t.Cleanup(func() {
+		vali.Close()
+		vali.wg.Wait()
+	})
+
+	for i := range 9 {
+		receive(t, vali, transfer("alice", "bob", float64(i+1), 1))
+	}
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Asked until answered, the port may not be listening yet.
+	var status statusResponse
+	buffer := make([]byte, 512)
+	waitFor(t, func() bool {
+		_, err := conn.Write([]byte("status"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		n, err := conn.Read(buffer)
+		if err != nil {
+			return false
+		}
+		err = json.Unmarshal(buffer[:n], &status)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return true
+	})
+
+	if status.Pending != 9 || status.MaxPending != 10 {
+		t.Errorf("got %d pending of %d, want 9 of 10", status.Pending, status.MaxPending)
+	}
+	if math.Abs(status.Load-0.9) > 1e-9 || math.Abs(status.AcceptProbability-0.1) > 1e-9 {
+		t.Errorf("got load %v, accept probability %v, want 0.9 and 0.1", status.Load, status.AcceptProbability)
+	}
+}
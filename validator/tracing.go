@@ -0,0 +1,103 @@
+package validator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Name of the tracer spans are started by, see WithTracerProvider.
+const tracerName = "transactioner/validator"
+
+// startTrace starts the span of a newly decoded transaction, received at
+// given time.
+func (vali *Validator) startTrace(tx *Transaction, received time.Time) {
+	ctx := context.Background()
+	if tx.TraceID != "" {
+		ctx = propagation.TraceContext{}.Extract(ctx, propagation.MapCarrier{"traceparent": tx.TraceID})
+	}
+	tx.trace, tx.span = vali.tracer.Start(ctx, "transaction", trace.WithTimestamp(received))
+
+	_, receive := vali.tracer.Start(tx.trace, "receive", trace.WithTimestamp(received))
+	receive.End()
+}
+
+// endTrace ends the span of a transaction that's settled or dropped.
+func (vali *Validator) endTrace(tx *Transaction, err error) {
+	if tx.span == nil {
+		return
+	}
+
+	endSpan(tx.span, err)
+	tx.span = nil
+}
+
+// startSpan starts a span of a stage of the transaction.
+// Returns nil if the transaction isn't traced.
+func (vali *Validator) startSpan(tx *Transaction, name string) trace.Span {
+	if tx.trace == nil {
+		return nil
+	}
+
+	_, span := vali.tracer.Start(tx.trace, name)
+	return span
+}
+
+// startSpans starts a span of a stage of the batch for every transaction,
+// as a child of their batch span.
+func (vali *Validator) startSpans(batch []*Transaction, name string) []trace.Span {
+	spans := make([]trace.Span, len(batch))
+	for i, tx := range batch {
+		if tx.batchTrace != nil {
+			_, spans[i] = vali.tracer.Start(tx.batchTrace, name)
+		}
+	}
+
+	return spans
+}
+
+// startBatchSpans starts the batch span of every transaction of a batch,
+// which later stages of the batch are children of.
+func (vali *Validator) startBatchSpans(batch []*Transaction) []trace.Span {
+	spans := make([]trace.Span, len(batch))
+	for i, tx := range batch {
+		if tx.trace != nil {
+			tx.batchTrace, spans[i] = vali.tracer.Start(tx.trace, "batch")
+		}
+	}
+
+	return spans
+}
+
+// endSpans ends spans of startSpans, recording err if not nil.
+func endSpans(spans []trace.Span, err error) {
+	for _, span := range spans {
+		endSpan(span, err)
+	}
+}
+
+// endSpan ends a span if it's not nil, recording err if not nil.
+func endSpan(span trace.Span, err error) {
+	if span == nil {
+		return
+	}
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// dropError describes a drop for its span.
+func dropError(reason DropReason, err error) error {
+	if err != nil {
+		return fmt.Errorf("dropped (%s): %w", reason, err)
+	}
+
+	return fmt.Errorf("dropped (%s)", reason)
+}
@@ -0,0 +1,125 @@
+package validator
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracingSpanHierarchy(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	vali := newTestValidator(t, map[string]float64{"alice": 100, "bob": 0},
+		WithTracerProvider(provider), WithSink(&recordingSink{}))
+
+	msg := `{"fee": {"payer": "alice", "amount": 1}, "instructions": [` +
+		`{"account": "alice", "change": -10}, {"account": "bob", "change": 10}],` +
+		` "traceId": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}`
+	vali.handleMessage([]byte(msg), netip.AddrPort{})
+	batch, err := vali.Flush()
+	if err != nil || len(batch) != 1 {
+		t.Fatalf("flushed %d transaction(s), error %v", len(batch), err)
+	}
+
+	spans := recorder.Ended()
+	byName := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range spans {
+		if _, ok := byName[span.Name()]; ok {
+			t.Fatalf("more than one %s span", span.Name())
+		}
+		byName[span.Name()] = span
+	}
+
+	// Every span by the name of its parent.
+	hierarchy := map[string]string{
+		"receive":  "transaction",
+		"validate": "transaction",
+		"enqueue":  "transaction",
+		"batch":    "transaction",
+		"commit":   "batch",
+		"send":     "batch",
+	}
+	if len(spans) != len(hierarchy)+1 {
+		t.Errorf("got %d spans, want %d", len(spans), len(hierarchy)+1)
+	}
+
+	root, ok := byName["transaction"]
+	if !ok {
+		t.Fatal("no transaction span")
+	}
+	// Sender's trace is continued.
+	if got := root.SpanContext().TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("trace ID %s, want the sender's", got)
+	}
+	if got := root.Parent().SpanID().String(); got != "00f067aa0ba902b7" {
+		t.Errorf("parent span ID %s, want the sender's", got)
+	}
+
+	for name, parent := range hierarchy {
+		span, ok := byName[name]
+		if !ok {
+			t.Errorf("no %s span", name)
+			continue
+		}
+		if span.Parent().SpanID() != byName[parent].SpanContext().SpanID() {
+			t.Errorf("%s span isn't a child of %s", name, parent)
+		}
+		if span.Status().Code == codes.Error {
+			t.Errorf("%s span failed: %s", name, span.Status().Description)
+		}
+	}
+}
+
+func TestTracingRecordsRejection(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	vali := newTestValidator(t, map[string]float64{"alice": 100}, WithTracerProvider(provider))
+
+	// Instruction without an account.
+	msg := `{"fee": {"payer": "alice", "amount": 1}, "instructions": [{"account": "", "change": 0}]}`
+	vali.handleMessage([]byte(msg), netip.AddrPort{})
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Errorf("got %d spans, want 3", len(spans))
+	}
+	for _, span := range spans {
+		switch span.Name() {
+		case "validate", "transaction":
+			if span.Status().Code != codes.Error {
+				t.Errorf("%s span didn't fail", span.Name())
+			}
+		case "receive":
+		default:
+			t.Errorf("unexpected %s span", span.Name())
+		}
+	}
+}
+
+func TestTracingUsesClock(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	mock := clock.NewMock()
+	mock.Set(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	vali := newTestValidator(t, map[string]float64{"alice": 100},
+		WithTracerProvider(provider), WithClock(mock))
+
+	msg := `{"fee": {"payer": "alice", "amount": 1}, "instructions": [{"account": "", "change": 0}]}`
+	vali.handleMessage([]byte(msg), netip.AddrPort{})
+
+	for _, span := range recorder.Ended() {
+		if span.Name() != "receive" {
+			continue
+		}
+		if !span.StartTime().Equal(mock.Now()) {
+			t.Errorf("received at %v, want %v", span.StartTime(), mock.Now())
+		}
+		return
+	}
+	t.Fatal("no receive span")
+}
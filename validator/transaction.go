@@ -1,18 +1,59 @@
 package validator
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"math"
+	"time"
+	adb "transactioner/accountsdb"
 	"transactioner/models"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Wraps `models.Transaction` with additional fields
 // required for sorting efficiently.
 type Transaction struct {
 	models.Transaction
-	prio  int // The priority of the item in the queue.
-	index int // The index of the item in the heap.
+	prio    int    // The priority of the item in the queue.
+	index   int    // The index of the item in the heap.
+	arrival uint64 // Order the transaction is first made pending in.
+
+	pushedAt  time.Time // When the transaction is first made pending.
+	deferrals int       // Times deferred as not commutative with a batch.
+
+	// Sum of instruction changes, non-zero only for accepted mint/burn
+	// transactions. Set when the transaction is checked for a batch.
+	imbalance float64
+
+	size int // Size of the transaction as received, in bytes.
+
+	// Transaction exactly as received, only kept if enabled by
+	// WithIncludeRawBytes. Sent along in batches, base64 encoded.
+	Raw []byte `json:"raw,omitempty"`
+
+	// Commit sequence of every account the transaction changes, see
+	// stampSequences. Set when the transaction is committed.
+	Sequences map[string]uint64 `json:"sequences,omitempty"`
+
+	// Signed amounts of reference changes by instruction index, as of the
+	// start of the batch. Set when the transaction is checked for a batch.
+	refs []float64
+
+	// What the transaction takes from each account, see Debits.
+	debits map[string]float64
+
+	// Tracing of the transaction, see WithTracerProvider. Nil if not traced.
+	trace      context.Context // Context of span.
+	span       trace.Span      // Lasts until settled or dropped.
+	batchTrace context.Context // Context of the latest batch span.
 }
 
+// ScoreFunc calculates the score of a transaction,
+// which is used as its priority.
+type ScoreFunc func(tx *Transaction) int
+
 // CalcScore calculates the score of a transaction.
 // We score the transactions by couple of factors in order to queue them.
 //
@@ -32,3 +73,219 @@ func (tx *Transaction) CalcScore() int {
 
 	return int(math.Ceil(score / 2))
 }
+
+// resolveChange converts `json.Number` changes to float64 so numeric
+// changes take the same path however they've been decoded.
+// Other kinds of changes (i.e. reference changes) are returned as is.
+//
+// An error is returned if the number can't be represented as a float64.
+func resolveChange(change any) (any, error) {
+	number, ok := change.(json.Number)
+	if !ok {
+		return change, nil
+	}
+
+	return number.Float64()
+}
+
+// resolveReferences records the amount every reference change moves,
+// the referenced balance in given db, negated for "minus" changes.
+// An error is returned if a referenced account doesn't exist.
+func (tx *Transaction) resolveReferences(db *adb.AccountsDb) error {
+	refs := make([]float64, len(tx.Instructions))
+	for i, instr := range tx.Instructions {
+		change, ok := instr.Change.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		account, _ := change["account"].(string)
+		balance, err := db.GetBalance(account)
+		if err != nil {
+			return fmt.Errorf("referenced account %q: %w", account, err)
+		}
+
+		switch change["sign"] {
+		case "plus":
+			refs[i] = balance
+		case "minus":
+			refs[i] = -balance
+		default:
+			return fmt.Errorf("unknown sign %v", change["sign"])
+		}
+	}
+
+	tx.refs = refs
+	return nil
+}
+
+// Evaluation cost of an instruction, and of a reference
+// instruction which reads another account too. See cost.
+const (
+	instructionCost = 1
+	referenceCost   = 2
+)
+
+// cost estimates the work of evaluating the transaction for a batch.
+func (tx *Transaction) cost() int {
+	cost := 0
+	for _, instr := range tx.Instructions {
+		if _, ok := instr.Change.(map[string]any); ok {
+			cost += referenceCost
+			continue
+		}
+		cost += instructionCost
+	}
+
+	return cost
+}
+
+// estimatedSize returns the size of the transaction in bytes. It's the
+// size as received if known, otherwise the size of its JSON encoding.
+func (tx *Transaction) estimatedSize() int {
+	if tx.size == 0 {
+		buffer, _ := json.Marshal(&tx.Transaction)
+		tx.size = len(buffer)
+	}
+
+	return tx.size
+}
+
+// accounts returns every account the transaction touches: the payer,
+// the instruction accounts and the referenced accounts. May contain
+// duplicates.
+func (tx *Transaction) accounts() []string {
+	accounts := []string{tx.Fee.Payer}
+	for _, instr := range tx.Instructions {
+		accounts = append(accounts, instr.Account)
+
+		if change, ok := instr.Change.(map[string]any); ok {
+			if account, ok := change["account"].(string); ok {
+				accounts = append(accounts, account)
+			}
+		}
+	}
+
+	return accounts
+}
+
+// debited returns the accounts the transaction takes balance from:
+// the payer, the accounts of negative changes and validator, the
+// validator account as the db names it, if it pays for minting.
+// May contain duplicates.
+func (tx *Transaction) debited(validator string) []string {
+	var accounts []string
+	if tx.Fee.Amount > 0 {
+		accounts = append(accounts, tx.Fee.Payer)
+	}
+
+	for _, instr := range tx.Instructions {
+		change, err := resolveChange(instr.Change)
+		if err != nil {
+			continue
+		}
+
+		switch change := change.(type) {
+		case float64:
+			if change < 0 {
+				accounts = append(accounts, instr.Account)
+			}
+		case map[string]any:
+			if change["sign"] == "minus" {
+				accounts = append(accounts, instr.Account)
+			}
+		}
+	}
+
+	if tx.imbalance > 0 {
+		accounts = append(accounts, validator)
+	}
+
+	return accounts
+}
+
+// key returns the ID of the transaction, deriving it from the content if
+// it has none yet, e.g. when it's pushed directly rather than received.
+func (tx *Transaction) key() string {
+	if tx.ID == "" {
+		tx.ID = tx.ComputeID()
+	}
+
+	return tx.ID
+}
+
+// isSelfTransfer returns true if the transaction moves balance from an
+// account to itself: an instruction referencing the payer's balance, or
+// changes netting zero on every account they touch. An instruction
+// referencing its own account isn't one, it doubles or zeroes the
+// balance. Account names must already be normalized.
+func (tx *Transaction) isSelfTransfer() bool {
+	net := make(map[string]float64)
+	references := false
+	for _, instr := range tx.Instructions {
+		change, err := resolveChange(instr.Change)
+		if err != nil {
+			return false
+		}
+
+		switch change := change.(type) {
+		case float64:
+			net[instr.Account] += change
+		case map[string]any:
+			if change["account"] == tx.Fee.Payer {
+				return true
+			}
+			references = true
+		}
+	}
+
+	// Moves a balance of another account, it's not a no-op.
+	if references {
+		return false
+	}
+
+	for _, change := range net {
+		if change != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Debits returns what the transaction takes from each account it
+// debits, as negative amounts, its fee included. It's known once the
+// transaction is checked for a batch, nil before. Credits to the payer
+// count against its fee and debits under PayerNetChange.
+func (tx *Transaction) Debits() map[string]float64 {
+	return tx.debits
+}
+
+// batchAccounts returns every account the batch touches,
+// including validator, the account earning the fees.
+func batchAccounts(batch []*Transaction, validator string) []string {
+	accounts := []string{validator}
+	for _, tx := range batch {
+		accounts = append(accounts, tx.accounts()...)
+	}
+
+	return accounts
+}
+
+// payerCredit returns the sum of numeric instruction changes crediting
+// the payer. See PayerNetChange.
+func (tx *Transaction) payerCredit() float64 {
+	var credit float64
+	for _, instr := range tx.Instructions {
+		if instr.Account != tx.Fee.Payer {
+			continue
+		}
+
+		change, err := resolveChange(instr.Change)
+		if amount, ok := change.(float64); err == nil && ok && amount > 0 {
+			credit += amount
+		}
+	}
+
+	return credit
+}
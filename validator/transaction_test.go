@@ -0,0 +1,253 @@
+package validator
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/netip"
+	"testing"
+
+	adb "transactioner/accountsdb"
+	"transactioner/models"
+)
+
+func TestResolveChange(t *testing.T) {
+	tests := []struct {
+		change any
+		want   float64
+		err    bool
+	}{
+		{json.Number("10"), 10, false},
+		{json.Number("-3"), -3, false},
+		{json.Number("0.25"), 0.25, false},
+		{json.Number("1e2"), 100, false},
+		{json.Number("ten"), 0, true},
+		{2.5, 2.5, false},
+	}
+	for _, test := range tests {
+		change, err := resolveChange(test.change)
+		if test.err {
+			if err == nil {
+				t.Errorf("%v resolved to %v, want an error", test.change, change)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", test.change, err)
+			continue
+		}
+		if change != test.want {
+			t.Errorf("%v resolved to %v, want %v", test.change, change, test.want)
+		}
+	}
+
+	// Reference changes are left as they are.
+	reference := map[string]any{"account": "bob", "sign": "plus"}
+	change, err := resolveChange(reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := change.(map[string]any); !ok {
+		t.Errorf("reference change resolved to %T", change)
+	}
+}
+
+func TestIntegerAndFractionalChanges(t *testing.T) {
+	vali := newTestValidator(t, map[string]float64{"alice": 100, "bob": 0})
+
+	for _, msg := range []string{
+		`{"fee": {"payer": "alice", "amount": 1}, "instructions": [{"account": "alice", "change": -10}, {"account": "bob", "change": 10}]}`,
+		`{"fee": {"payer": "alice", "amount": 0.5}, "instructions": [{"account": "alice", "change": -2.25}, {"account": "bob", "change": 2.25}]}`,
+	} {
+		tx, err := vali.decodeTransaction([]byte(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Changes are kept as they're written until executed.
+		if _, ok := tx.Instructions[0].Change.(json.Number); !ok {
+			t.Errorf("change decoded as %T, want json.Number", tx.Instructions[0].Change)
+		}
+		vali.PushTransaction(tx)
+	}
+
+	processAll(t, vali)
+	for account, want := range map[string]float64{"alice": 86.25, "bob": 12.25, "validator": 1.5} {
+		if balance, _ := vali.db.GetBalance(account); balance != want {
+			t.Errorf("balance of %s is %v, want %v", account, balance, want)
+		}
+	}
+}
+
+func TestUnrepresentableChange(t *testing.T) {
+	vali := newTestValidator(t, map[string]float64{"alice": 100, "bob": 0})
+
+	tx := transfer("alice", "bob", 10, 1)
+	tx.Instructions[1].Change = json.Number("1e400")
+	vali.PushTransaction(tx)
+
+	batch, _, _ := vali.buildBatch()
+	if len(batch) != 0 {
+		t.Error("transaction with an unrepresentable change was committed")
+	}
+	if n := vali.Rejections(ReasonExecution); n != 1 {
+		t.Errorf("%d execution rejection(s), want 1", n)
+	}
+	if balance, _ := vali.db.GetBalance("bob"); balance != 0 {
+		t.Errorf("bob got %v", balance)
+	}
+}
+
+func TestRejectSelfTransfers(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  string
+		self bool
+	}{
+		{"transfer", `{"fee": {"payer": "alice", "amount": 1}, "instructions": [{"account": "alice", "change": -5}, {"account": "bob", "change": 5}]}`, false},
+		{"netting zero", `{"fee": {"payer": "alice", "amount": 1}, "instructions": [{"account": "bob", "change": 5}, {"account": "bob", "change": -5}]}`, true},
+		{"plus payer", `{"fee": {"payer": "alice", "amount": 1}, "instructions": [{"account": "alice", "change": {"account": "alice", "sign": "plus"}}]}`, true},
+		{"minus payer", `{"fee": {"payer": "alice", "amount": 1}, "instructions": [{"account": "bob", "change": {"account": "alice", "sign": "minus"}}]}`, true},
+		// Names are compared once normalized.
+		{"minus payer, unnormalized", `{"fee": {"payer": "Alice", "amount": 1}, "instructions": [{"account": "bob", "change": {"account": " alice", "sign": "minus"}}]}`, true},
+		// Zeroes bob, moving nothing to the payer.
+		{"minus itself", `{"fee": {"payer": "alice", "amount": 1}, "instructions": [{"account": "bob", "change": {"account": "bob", "sign": "minus"}}]}`, false},
+	}
+
+	vali := newTestValidator(t, map[string]float64{"alice": 100, "bob": 10},
+		WithRejectSelfTransfers(true), WithAccountNormalizer(adb.TrimLower))
+	allowing := newTestValidator(t, map[string]float64{"alice": 100, "bob": 10}, WithAccountNormalizer(adb.TrimLower))
+	for _, test := range tests {
+		_, err := vali.decodeTransaction([]byte(test.msg))
+		var reject *rejectError
+		rejected := errors.As(err, &reject) && reject.reason == ReasonSelfTransfer
+		if rejected != test.self {
+			t.Errorf("%s: rejected as self-transfer %v, want %v (error %v)", test.name, rejected, test.self, err)
+		}
+
+		// Disabled by default.
+		if _, err := allowing.decodeTransaction([]byte(test.msg)); err != nil {
+			t.Errorf("%s: rejected by default: %v", test.name, err)
+		}
+	}
+}
+
+func TestMaxReferenceAmount(t *testing.T) {
+	// sweep moves the whole balance of an account to bob, alice paying.
+	sweep := func(from string) *Transaction {
+		tx := &Transaction{Transaction: models.Transaction{
+			Fee: models.Fee{Payer: "alice", Amount: 1},
+			Instructions: []models.Instruction{
+				{Account: from, Change: map[string]any{"account": from, "sign": "minus"}},
+				{Account: "bob", Change: map[string]any{"account": from, "sign": "plus"}},
+			},
+		}}
+		tx.ID = tx.ComputeID()
+		return tx
+	}
+
+	for _, max := range []float64{0, 1000} {
+		vali := newTestValidator(t, map[string]float64{"alice": 100, "bob": 0, "carol": 50, "whale": 1e12},
+			WithMaxReferenceAmount(max), WithSink(&recordingSink{}))
+
+		vali.PushTransaction(sweep("whale"))
+		vali.PushTransaction(sweep("carol"))
+		batch, err := vali.Flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := map[string]float64{"alice": 98, "bob": 1e12 + 50, "carol": 0, "whale": 0, adb.ValidatorAccount: 2}
+		if max > 0 {
+			// Whale's balance stays where it is, the fee is charged still.
+			want["bob"], want["whale"] = 50, 1e12
+			if len(batch) != 1 || vali.Rejections(ReasonExecution) != 1 {
+				t.Errorf("max %v: committed %d transaction(s), %d failed, want 1 and 1", max, len(batch), vali.Rejections(ReasonExecution))
+			}
+		} else if len(batch) != 2 {
+			t.Errorf("no max: committed %d transaction(s), want 2", len(batch))
+		}
+		if got := vali.db.Balances(); !maps.Equal(got, want) {
+			t.Errorf("max %v: balances %v, want %v", max, got, want)
+		}
+	}
+}
+
+func TestComplexityBudget(t *testing.T) {
+	// sweeps moves carol's balance to bob n times over, every
+	// sweep being two reference instructions costing 4.
+	sweeps := func(n int) *Transaction {
+		tx := &Transaction{Transaction: models.Transaction{Fee: models.Fee{Payer: "alice", Amount: 1}}}
+		for range n {
+			tx.Instructions = append(tx.Instructions,
+				models.Instruction{Account: "carol", Change: map[string]any{"account": "carol", "sign": "minus"}},
+				models.Instruction{Account: "bob", Change: map[string]any{"account": "carol", "sign": "plus"}},
+			)
+		}
+		tx.ID = tx.ComputeID()
+		return tx
+	}
+
+	vali := newTestValidator(t, map[string]float64{"alice": 100, "bob": 0, "carol": 50}, WithComplexityBudget(10))
+	unlimited := newTestValidator(t, map[string]float64{"alice": 100, "bob": 0, "carol": 50})
+
+	receive(t, vali, sweeps(2))
+	if n := vali.PendingCount(); n != 1 {
+		t.Errorf("%d pending within the budget, want 1", n)
+	}
+
+	receive(t, vali, sweeps(3))
+	if n := vali.PendingCount(); n != 1 {
+		t.Errorf("%d pending after one over the budget, want 1", n)
+	}
+	if n := vali.Rejections(ReasonTooComplex); n != 1 {
+		t.Errorf("%d too complex rejection(s), want 1", n)
+	}
+
+	// No budget by default.
+	receive(t, unlimited, sweeps(6))
+	if n := unlimited.Rejections(ReasonTooComplex); n != 0 || unlimited.PendingCount() != 1 {
+		t.Errorf("%d too complex rejection(s) without a budget, want 0", n)
+	}
+}
+
+func TestMaxAccountsPerTransaction(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  string
+		over bool
+	}{
+		{"transfer", `{"fee": {"payer": "alice", "amount": 1}, "instructions": [{"account": "alice", "change": -5}, {"account": "bob", "change": 5}]}`, false},
+		{"at the limit", `{"fee": {"payer": "alice", "amount": 1}, "instructions": [{"account": "bob", "change": -5}, {"account": "carol", "change": 5}]}`, false},
+		{"over the limit", `{"fee": {"payer": "alice", "amount": 1}, "instructions": [{"account": "bob", "change": -5}, {"account": "carol", "change": 4}, {"account": "dave", "change": 1}]}`, true},
+		// Referenced accounts count too.
+		{"referenced", `{"fee": {"payer": "alice", "amount": 1}, "instructions": [{"account": "bob", "change": {"account": "carol", "sign": "plus"}}, {"account": "dave", "change": {"account": "carol", "sign": "minus"}}]}`, true},
+		// Names are counted once normalized.
+		{"unnormalized", `{"fee": {"payer": "alice", "amount": 1}, "instructions": [{"account": "Bob", "change": -5}, {"account": " bob", "change": 4}, {"account": "carol", "change": 1}]}`, false},
+	}
+
+	vali := newTestValidator(t, map[string]float64{"alice": 100, "bob": 10, "carol": 10},
+		WithMaxAccountsPerTransaction(3), WithAccountNormalizer(adb.TrimLower))
+	unlimited := newTestValidator(t, map[string]float64{"alice": 100, "bob": 10, "carol": 10})
+	rejections := 0
+	for _, test := range tests {
+		_, err := vali.decodeTransaction([]byte(test.msg))
+		var reject *rejectError
+		over := errors.As(err, &reject) && reject.reason == ReasonTooManyAccounts
+		if over != test.over {
+			t.Errorf("%s: rejected for too many accounts %v, want %v (error %v)", test.name, over, test.over, err)
+		}
+
+		vali.handleMessage([]byte(test.msg), netip.AddrPort{})
+		if test.over {
+			rejections++
+		}
+
+		// Unlimited by default.
+		if _, err := unlimited.decodeTransaction([]byte(test.msg)); err != nil {
+			t.Errorf("%s: rejected by default: %v", test.name, err)
+		}
+	}
+	if n := vali.Rejections(ReasonTooManyAccounts); n != uint64(rejections) {
+		t.Errorf("%d too many accounts rejection(s), want %d", n, rejections)
+	}
+}
@@ -2,199 +2,1276 @@ package validator
 
 import (
 	"bytes"
-	"container/heap"
+	"cmp"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"math"
+	"math/rand/v2"
 	"net"
 	"net/http"
-	"os"
+	"net/netip"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 	adb "transactioner/accountsdb"
+	"unsafe"
 
+	"github.com/benbjohnson/clock"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 	"go.uber.org/ratelimit"
 )
 
+// Messages cannot be larger than 1024 bytes.
+const maxMessageSize = 1024
+
 type Validator struct {
 	conn     *net.UDPConn      // For receiving transactions.
 	db       *adb.AccountsDb   // Where accounts and balances stored.
 	txCh     chan *Transaction // Unordered transactions.
-	client   *http.Client      // HTTP client to send batches.
-	batchIdx uint64            //
+	sink     Sink              // Where batches are sent to.
+	batchIdx atomic.Uint64     // Index of the next batch to commit.
 	wg       sync.WaitGroup    // To wait for goroutines.
 	rl       ratelimit.Limiter // Rate limiter for sending batches.
-	txHeap   TransactionHeap   // Ordered transactions.
+	policy   SelectionPolicy   // Order transactions are batched in.
+	pending  pendingSet        // Ordered transactions, guarded by pendingMu.
+	arrivals uint64            // Transactions made pending so far, guarded by pendingMu.
+	metrics  *metrics          // Counters about processing.
+
+	// adb.ValidatorAccount as named by the db, normalized once.
+	validatorAccount string
+
+	// Options, see options.go.
+	normalize            func(string) string   // Account name normalizer, nil if none.
+	strict               bool                  // Reject transactions with unknown fields.
+	maxPerPayer          int                   // Max transactions of a payer per batch, 0 if unlimited.
+	ingestBuffer         int                   // Capacity of transaction channel.
+	maxPending           int                   // Max pending transactions, 0 if unlimited.
+	maxPendingBytes      int                   // Max size of pending transactions, 0 if unlimited.
+	queryAddr            string                // Address to serve query API, empty if disabled.
+	prettySnapshots      bool                  // Indent snapshots.
+	snapshotJitter       time.Duration         // Max deviation from snapshot interval.
+	snapshotRetention    int                   // Snapshot files kept, 0 keeps all of them.
+	snapshotFetchTimeout time.Duration         // Timeout of fetching snapshot over HTTP.
+	idleBackoff          time.Duration         // Wait after a batch couldn't be built.
+	clock                clock.Clock           // Source of time.
+	types                map[string]TypeConfig // Per-type handling of transactions.
+	allowMint            bool                  // Accept transactions with positive instruction sum.
+	allowBurn            bool                  // Accept transactions with negative instruction sum.
+	batchEndpoint        string                // URL of the default HTTP sink.
+	batchMethod          string                // Method of the default HTTP sink.
+	strictConservation   bool                  // Check total supply on commits.
+	conservationAction   ConservationAction    // What to do on violations.
+	grpcEndpoint         string                // Address of the gRPC collector, empty to send over HTTP.
+	grpcSink             *GRPCSink             // Created for grpcEndpoint, nil if none.
+	dryRun               bool                  // Never commit or send batches.
+	lanes                int                   // Batches built concurrently, 0 or 1 if one at a time.
+	batchSize            int                   // Max transactions in a batch.
+	candidateWindow      int                   // Max commutative candidates considered per batch.
+	batchedReads         bool                  // Drain every ready datagram per wakeup.
+	middleware           Middleware            // Transforms transactions before scoring, nil if none.
+	rand                 *rand.Rand            // Source of randomness, guarded by randMu.
+	listenAddr           string                // UDP address transactions are received over.
+	rejectSelfTransfers  bool                  // Reject transactions moving nothing.
+	udpAck               bool                  // Tell UDP senders if their transaction is accepted.
+	commitHook           CommitHook            // Called after each batch commit, nil if none.
+	shutdownSendPolicy   ShutdownSendPolicy    // What to do with sends after close.
+	deadLetterFile       string                // Where dropped transactions are written, empty if nowhere.
+	deadLetters          *deadLetters          // Opened deadLetterFile, nil if none.
+	maxPendingAge        time.Duration         // Age deferred transactions expire at, 0 if never.
+	maxDeferrals         int                   // Deferrals as non-commutative allowed, 0 if unlimited.
+	sequential           bool                  // Commit transactions one at a time, in order.
+	store                adb.Store             // Where the db keeps balances, nil for the default.
+	coalesce             bool                  // Merge committed batches while sending is rate limited.
+	maxFee               float64               // Max fee of a transaction, 0 if unlimited.
+	chargeFeeOnFailure   bool                  // Take the fee of transactions failing to execute.
+	compaction           bool                  // Send net changes of batches rather than transactions.
+	rescoreInterval      time.Duration         // Between re-evaluations of pending transactions, 0 if never.
+	conflicts            ConflictDetector      // Decides which transactions can't share a batch.
+	maxConnections       int                   // Max open connections to the query API, 0 if unlimited.
+	maxReferenceAmount   float64               // Max referenced balance an instruction can move, 0 if unlimited.
+	accountValidator     func(string) error    // Checks account names of transactions, nil if none.
+	batchValidator       BatchValidator        // Can veto batches before commit, nil if none.
+	vetoPolicy           VetoPolicy            // What to do with transactions of vetoed batches.
+	complexityBudget     int                   // Max evaluation cost of a transaction, 0 if unlimited.
+	batchCodec           Codec                 // Encoding of the default HTTP sink, JSON if nil.
+	includeRawBytes      bool                  // Send transactions as received along in batches.
+	heapSoftLimit        int                   // Heap estimate marking the validator degraded, 0 if none.
+	priorityVerifier     PriorityVerifier      // Checks transactions asking for priority, nil if none.
+	batchWindow          time.Duration         // How long to gather transactions for a batch, 0 if not at all.
+	statusAddr           string                // UDP address to report load over, empty if disabled.
+	onSnapshotFailure    func(error)           // Called when a snapshot fails to be written, nil if none.
+	supplyCheck          bool                  // Compare total supply to the initial one on snapshots.
+	maxAccounts          int                   // Max distinct accounts a transaction touches, 0 if unlimited.
+	tracer               trace.Tracer          // Traces transactions through stages.
+	payerPolicy          PayerPolicy           // What the payer must afford of its own transactions.
+
+	// Handling of a missing validator account.
+	reservedPolicy adb.ReservedAccountPolicy
+
+	pendingMu    sync.Mutex
+	pendingBytes int         // Estimated size of pending transactions, guarded by pendingMu.
+	degraded     atomic.Bool // Heap estimate is above the soft limit.
+
+	score   ScoreFunc // Default scorer, CalcScore if nil.
+	scoreMu sync.RWMutex
+
+	processMu      sync.Mutex   // Serializes building and settling batches.
+	held           heldBatches  // Committed but not sent yet, guarded by processMu.
+	expectedSupply float64      // Total supply as loaded, guarded by processMu.
+	lastSend       atomic.Int64 // When a batch was last sent, in Unix nanoseconds.
+
+	randMu sync.Mutex
+
+	rejections    rejectionSample // Recently dropped transactions, see RecentRejections.
+	recentBatches batchRing       // Recently committed batches, see RecentBatches.
+	committedIDs  committedIDs    // Batches of recently committed transactions, see WasCommitted.
+	acceptance    rateWindow      // Received and committed transactions, see AcceptanceRate.
+
+	fees   [feeHistorySize]batchFees // Fees of recent batches, by index modulo size.
+	feesMu sync.Mutex
+
+	resume  chan struct{} // Closed on Resume, nil if not paused.
+	pauseMu sync.Mutex
+
+	inProgress   []*Transaction // Batch that's being built, committed or sent.
+	inProgressMu sync.Mutex
+
+	running   atomic.Bool   // Run has been called.
+	done      chan struct{} // Closed when the validator is closed.
+	closeOnce sync.Once
 }
 
 // NewFromSnapshot creates a validator where it's db is initialized
-// by given accounts snapshot file.
-func NewFromSnapshot(snapshot string) (*Validator, error) {
+// by given accounts snapshot file. Snapshot can also be an http(s) URL,
+// in which case it's fetched before initializing.
+func NewFromSnapshot(snapshot string, opts ...Option) (*Validator, error) {
+	vali := &Validator{
+		wg:      sync.WaitGroup{},
+		policy:  ScorePriority,
+		metrics: newMetrics(),
+		done:    make(chan struct{}),
+
+		ingestBuffer:  256,
+		batchSize:     100,
+		batchEndpoint: "http://localhost:2002/",
+		batchMethod:   http.MethodPost,
+		listenAddr:    ":2001",
+
+		snapshotFetchTimeout: 30 * time.Second,
+		idleBackoff:          10 * time.Millisecond,
+		chargeFeeOnFailure:   true,
+		clock:                clock.New(),
+	}
+
+	for _, opt := range opts {
+		opt(vali)
+	}
+
+	vali.txCh = make(chan *Transaction, vali.ingestBuffer)
+	vali.rl = ratelimit.New(sendRate, ratelimit.WithClock(vali.clock))
+
+	if vali.conflicts == nil {
+		vali.conflicts = BalanceConflictDetector{}
+	}
+
+	if vali.tracer == nil {
+		vali.tracer = noop.NewTracerProvider().Tracer(tracerName)
+	}
+
+	if vali.rand == nil {
+		seed := uint64(time.Now().UnixNano())
+		vali.rand = rand.New(rand.NewPCG(seed, seed))
+	}
+
+	// Send to batch collector over HTTP unless told otherwise.
+	switch {
+	case vali.sink != nil:
+	case vali.grpcEndpoint != "":
+		sink, err := NewGRPCSink(vali.grpcEndpoint)
+		if err != nil {
+			return nil, err
+		}
+		vali.sink = sink
+		vali.grpcSink = sink
+	default:
+		if !isHTTPMethod(vali.batchMethod) {
+			return nil, fmt.Errorf("invalid batch method: %q", vali.batchMethod)
+		}
+
+		sink := NewHTTPSink(vali.batchEndpoint)
+		sink.Method = vali.batchMethod
+		sink.Codec = vali.batchCodec
+		vali.sink = sink
+	}
+
+	// Don't leave the connection of the gRPC sink behind if setting up
+	// fails from here on.
+	created := false
+	defer func() {
+		if !created && vali.grpcSink != nil {
+			vali.grpcSink.Close()
+		}
+	}()
+
+	if _, ok := vali.sink.(DeltaSink); vali.compaction && !ok {
+		return nil, errors.New("batch compaction requires a sink accepting deltas")
+	}
+
 	// Create the db.
-	db, err := adb.InitFromSnapshot(snapshot)
+	var dbOpts []adb.Option
+	if vali.normalize != nil {
+		dbOpts = append(dbOpts, adb.WithNormalizer(vali.normalize))
+	}
+	dbOpts = append(dbOpts, adb.WithReservedAccountPolicy(vali.reservedPolicy))
+
+	var db *adb.AccountsDb
+	var err error
+	switch {
+	// Accounts persisted by a previous run, snapshot is only for seeding.
+	case vali.store != nil && vali.store.Len() > 0:
+		db, err = adb.InitFromStore(vali.store, dbOpts...)
+	case vali.store != nil:
+		db, err = vali.loadSnapshot(snapshot, append(dbOpts, adb.WithStore(vali.store))...)
+	default:
+		db, err = vali.loadSnapshot(snapshot, dbOpts...)
+	}
 	if err != nil {
 		return nil, err
 	}
+	vali.db = db
+	vali.validatorAccount = db.Normalize(adb.ValidatorAccount)
+	vali.expectedSupply = db.TotalSupply()
 
 	// Setup UDP receiver.
-	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: 2001})
+	laddr, err := net.ResolveUDPAddr("udp", vali.listenAddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return nil, err
+	}
+	vali.conn = conn
+
+	// Create the pending transactions set.
+	capacity := vali.ingestBuffer
+	if vali.maxPending > 0 {
+		capacity = vali.maxPending
+	}
+	vali.pending = newPendingSet(vali.policy, capacity)
+
+	if vali.deadLetterFile != "" {
+		letters, err := openDeadLetters(vali.deadLetterFile)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		vali.deadLetters = letters
+	}
+
+	created = true
+	return vali, nil
+}
+
+// Close stops receiving transactions and closes the underlying UDP connection,
+// along with the connection of the gRPC sink if there's one, or once Run
+// is done draining.
+// A read in progress is interrupted by a deadline, the receiver notices
+// the validator is closed and exits quietly instead of logging errors.
+func (vali *Validator) Close() error {
+	var err error
+	vali.closeOnce.Do(func() {
+		close(vali.done)
+
+		// Wake up the receiver if it's blocked on a read.
+		vali.conn.SetReadDeadline(time.Now())
+		err = vali.conn.Close()
+
+		// Batches are still sent while draining, Run closes it then.
+		if vali.grpcSink != nil && !vali.running.Load() {
+			err = errors.Join(err, vali.grpcSink.Close())
+		}
+
+		// Batches sent while draining may still be dead-lettered,
+		// Run closes the file once they're done.
+		drains := vali.running.Load() && vali.shutdownSendPolicy == ShutdownDeadLetter
+		if vali.deadLetters != nil && !drains {
+			err = errors.Join(err, vali.deadLetters.close())
+		}
+	})
+
+	return err
+}
+
+// Addr returns the UDP address transactions are received over, e.g. to
+// find out the port picked when listening on port 0.
+func (vali *Validator) Addr() *net.UDPAddr {
+	return vali.conn.LocalAddr().(*net.UDPAddr)
+}
+
+// OnAccountCreated sets a function that's called once for every
+// account created in the db as transactions are committed.
+func (vali *Validator) OnAccountCreated(fn func(account string, initialBalance float64)) {
+	vali.db.OnAccountCreated(fn)
+}
+
+// isClosed returns true if Close has been called.
+func (vali *Validator) isClosed() bool {
+	select {
+	case <-vali.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// PushTransaction makes a transaction pending.
+func (vali *Validator) PushTransaction(tx *Transaction) {
+	vali.pendingMu.Lock()
+	defer vali.pendingMu.Unlock()
+
+	vali.push(tx)
+}
+
+// push makes a transaction pending. Must be called with pendingMu held.
+func (vali *Validator) push(tx *Transaction) {
+	// Transactions keep their arrival however many times they're pushed.
+	if tx.arrival == 0 {
+		vali.arrivals++
+		tx.arrival = vali.arrivals
+		tx.pushedAt = vali.clock.Now()
+	}
+
+	vali.pending.Push(tx)
+	vali.pendingBytes += tx.estimatedSize()
+	vali.checkHeap()
+}
+
+// Rough memory a pending transaction takes beyond its encoded size:
+// the decoded struct and its place in the pending set.
+const pendingOverhead = int(unsafe.Sizeof(Transaction{})) + 2*int(unsafe.Sizeof(uintptr(0)))
+
+// heapBytesEstimate returns roughly how much memory pending transactions
+// take. Must be called with pendingMu held.
+func (vali *Validator) heapBytesEstimate() int {
+	return vali.pendingBytes + vali.pending.Len()*pendingOverhead
+}
+
+// checkHeap marks the validator degraded while the heap estimate is
+// above the soft limit, logging a warning once it's crossed.
+// Must be called with pendingMu held.
+func (vali *Validator) checkHeap() {
+	if vali.heapSoftLimit <= 0 {
+		return
+	}
+
+	estimate := vali.heapBytesEstimate()
+	over := estimate > vali.heapSoftLimit
+	if vali.degraded.Swap(over) == over {
+		return
+	}
+
+	if over {
+		log.Printf("warning: pending transactions take about %d bytes, above the soft limit of %d", estimate, vali.heapSoftLimit)
+	} else {
+		log.Printf("pending transactions are back below the soft limit of %d bytes", vali.heapSoftLimit)
+	}
+}
+
+// Degraded reports whether pending transactions take more memory than
+// the soft limit, see WithHeapSoftLimit.
+func (vali *Validator) Degraded() bool {
+	return vali.degraded.Load()
+}
+
+// requeue makes popped transactions pending again, in the place they'd
+// have kept had they not been popped.
+func (vali *Validator) requeue(txs []*Transaction) {
+	vali.pendingMu.Lock()
+	defer vali.pendingMu.Unlock()
+
+	vali.pending.Requeue(txs)
+	for _, tx := range txs {
+		vali.pendingBytes += tx.estimatedSize()
+	}
+	vali.checkHeap()
+}
+
+// expire drops deferred transactions that are too old or have been
+// deferred too many times to retry, see WithMaxPendingAge and
+// WithMaxDeferrals. Returns the ones to make pending again.
+func (vali *Validator) expire(deferred []*Transaction) []*Transaction {
+	if vali.maxPendingAge <= 0 && vali.maxDeferrals <= 0 {
+		return deferred
+	}
+
+	now := vali.clock.Now()
+	kept := deferred[:0]
+	for _, tx := range deferred {
+		if vali.maxPendingAge > 0 && now.Sub(tx.pushedAt) >= vali.maxPendingAge {
+			vali.drop(tx, ReasonExpired, fmt.Errorf("pending for %v", now.Sub(tx.pushedAt)))
+			continue
+		}
+
+		if vali.maxDeferrals > 0 && tx.deferrals >= vali.maxDeferrals {
+			vali.drop(tx, ReasonRetriesExhausted, fmt.Errorf("deferred %d time(s)", tx.deferrals))
+			continue
+		}
+
+		kept = append(kept, tx)
+	}
+
+	return kept
+}
+
+// enqueue makes a newly received transaction pending,
+// unless there are too many pending transactions already.
+func (vali *Validator) enqueue(tx *Transaction) {
+	span := vali.startSpan(tx, "enqueue")
+	defer endSpan(span, nil)
+
+	vali.pendingMu.Lock()
+	defer vali.pendingMu.Unlock()
+
+	if vali.maxPending > 0 && vali.pending.Len() >= vali.maxPending {
+		vali.drop(tx, ReasonPendingFull, nil)
+		return
+	}
+
+	if vali.maxPendingBytes > 0 && vali.pendingBytes+tx.estimatedSize() > vali.maxPendingBytes {
+		vali.drop(tx, ReasonPendingBytes, nil)
+		return
+	}
+
+	vali.push(tx)
+}
+
+// NextTransaction removes and returns the next transaction to be batched
+// according to the selection policy. Returns nil if there's none.
+func (vali *Validator) NextTransaction() *Transaction {
+	vali.pendingMu.Lock()
+	defer vali.pendingMu.Unlock()
+
+	if vali.pending.Len() == 0 {
+		return nil
+	}
+
+	tx := vali.pending.Pop()
+	vali.pendingBytes -= tx.estimatedSize()
+	vali.checkHeap()
+
+	return tx
+}
+
+// PeekTransaction returns the next transaction to be batched without
+// removing it. Returns nil if there's none.
+func (vali *Validator) PeekTransaction() *Transaction {
+	vali.pendingMu.Lock()
+	defer vali.pendingMu.Unlock()
+
+	if vali.pending.Len() == 0 {
+		return nil
+	}
+
+	return vali.pending.Peek()
+}
+
+// RescorePending re-evaluates pending transactions against the current
+// balances every rescore interval, until the validator is closed.
+// See WithRescoreInterval.
+func (vali *Validator) RescorePending() {
+	defer vali.wg.Done()
+
+	for {
+		select {
+		case <-vali.clock.After(vali.rescoreInterval):
+			vali.prunePending()
+		case <-vali.done:
+			return
+		}
+	}
+}
+
+// prunePending drops pending transactions whose payer can't pay the fee
+// anymore, or which debit a frozen account, as building a batch would.
+// Returns the dropped transactions.
+func (vali *Validator) prunePending() []*Transaction {
+	vali.pendingMu.Lock()
+	removed := vali.pending.RemoveFunc(func(tx *Transaction) bool {
+		return !vali.canPayFee(vali.db, tx) || vali.debitsFrozen(vali.db, tx)
+	})
+	for _, tx := range removed {
+		vali.pendingBytes -= tx.estimatedSize()
+	}
+	vali.checkHeap()
+	vali.pendingMu.Unlock()
+
+	for _, tx := range removed {
+		if vali.debitsFrozen(vali.db, tx) {
+			vali.drop(tx, ReasonFrozen, nil)
+			continue
+		}
+		vali.drop(tx, ReasonFeeCheck, errors.New("payer can't pay the fee"))
+	}
+
+	return removed
+}
+
+// DropPending removes every pending transaction paid by given account,
+// e.g. after freezing it. Returns how many transactions are removed.
+// Transactions already being batched aren't affected.
+func (vali *Validator) DropPending(payer string) int {
+	payer = vali.db.Normalize(payer)
+
+	vali.pendingMu.Lock()
+	removed := vali.pending.RemoveFunc(func(tx *Transaction) bool {
+		return tx.Fee.Payer == payer
+	})
+	for _, tx := range removed {
+		vali.pendingBytes -= tx.estimatedSize()
+	}
+	vali.checkHeap()
+	vali.pendingMu.Unlock()
+
+	for _, tx := range removed {
+		vali.drop(tx, ReasonDropped, nil)
+	}
+
+	return len(removed)
+}
+
+// UpdatePriority changes the priority of a pending transaction,
+// moving it to its new place in the order. The priority is recorded even
+// if the transaction isn't pending, e.g. because it's been popped for a
+// batch; it takes effect once the transaction is pushed again.
+// Returns true if the transaction was pending.
+func (vali *Validator) UpdatePriority(tx *Transaction, prio int) bool {
+	vali.pendingMu.Lock()
+	defer vali.pendingMu.Unlock()
+
+	return vali.pending.Update(tx, prio)
+}
+
+// PendingCount returns the number of transactions waiting to be batched.
+func (vali *Validator) PendingCount() int {
+	vali.pendingMu.Lock()
+	defer vali.pendingMu.Unlock()
+
+	return vali.pending.Len()
+}
+
+// ScoreRange returns the lowest and highest priorities currently pending,
+// ok is false if nothing is pending. Clients can use it to figure out
+// what fee gets them included soon.
+func (vali *Validator) ScoreRange() (min, max int, ok bool) {
+	vali.pendingMu.Lock()
+	defer vali.pendingMu.Unlock()
+
+	return vali.pending.ScoreRange()
+}
+
+// decodeTransaction parses a single transaction message and scores it.
+// Every transaction entering the validator goes through here,
+// regardless of where it's been received from.
+func (vali *Validator) decodeTransaction(msg []byte) (*Transaction, error) {
+	received := vali.clock.Now()
+	vali.acceptance.add(received, 1, 0)
+
+	if len(msg) > maxMessageSize {
+		return nil, errMessageTooLarge
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(msg))
+	// Keep numeric changes as they're written, they're only
+	// converted when executed. See resolveChange.
+	decoder.UseNumber()
+	if vali.strict {
+		decoder.DisallowUnknownFields()
+	}
+
+	tx := &Transaction{size: len(msg)}
+	err := decoder.Decode(&tx.Transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	if vali.includeRawBytes {
+		tx.Raw = bytes.Clone(msg)
+	}
+
+	// A message carries exactly one transaction.
+	if decoder.More() {
+		return nil, errors.New("unexpected data after transaction")
+	}
+
+	vali.startTrace(tx, received)
+	span := vali.startSpan(tx, "validate")
+	checked, err := vali.checkTransaction(tx)
+	endSpan(span, err)
+	if err != nil {
+		vali.endTrace(tx, err)
+		return nil, err
+	}
+
+	return checked, nil
+}
+
+// checkTransaction makes the checks of a decoded transaction and scores
+// it. Returns the transaction to carry on with, which may be a different
+// one if middleware says so.
+func (vali *Validator) checkTransaction(tx *Transaction) (*Transaction, error) {
+	err := tx.Validate()
+	if err != nil {
+		return nil, &rejectError{ReasonInvalid, err}
+	}
+
+	if vali.complexityBudget > 0 && tx.cost() > vali.complexityBudget {
+		return nil, &rejectError{ReasonTooComplex, fmt.Errorf("transaction costs %d, above the budget of %d", tx.cost(), vali.complexityBudget)}
+	}
+
+	if vali.accountValidator != nil {
+		for _, account := range tx.accounts() {
+			err := vali.accountValidator(account)
+			if err != nil {
+				return nil, &rejectError{ReasonAccountName, fmt.Errorf("account %q: %w", account, err)}
+			}
+		}
+	}
+
+	config := vali.types[tx.Type]
+	if tx.Fee.Amount < config.MinFee {
+		return nil, &rejectError{ReasonMinFee, errors.New("fee is below the minimum")}
+	}
+
+	if vali.maxFee > 0 && tx.Fee.Amount > vali.maxFee {
+		return nil, &rejectError{ReasonMaxFee, errors.New("fee is above the maximum")}
+	}
+
+	// Senders may set the ID themselves, but it must be the one we'd
+	// derive from the content they've sent, account names as they are.
+	id := tx.ComputeID()
+	if tx.ID != "" && tx.ID != id {
+		return nil, &rejectError{ReasonInvalid, errIDMismatch}
+	}
+	tx.ID = id
+
+	vali.normalizeAccounts(tx)
+
+	if vali.maxAccounts > 0 {
+		accounts := tx.accounts()
+		slices.Sort(accounts)
+		if n := len(slices.Compact(accounts)); n > vali.maxAccounts {
+			return nil, &rejectError{ReasonTooManyAccounts, fmt.Errorf("transaction touches %d accounts, above the limit of %d", n, vali.maxAccounts)}
+		}
+	}
+
+	if vali.rejectSelfTransfers && tx.isSelfTransfer() {
+		return nil, &rejectError{ReasonSelfTransfer, errors.New("transaction transfers to itself")}
+	}
+
+	if vali.middleware != nil {
+		next, err := vali.middleware(tx)
+		if err != nil {
+			return nil, &rejectError{ReasonMiddleware, err}
+		}
+
+		if next != tx {
+			next.size = tx.size
+			next.Raw = tx.Raw
+			next.trace, next.span = tx.trace, tx.span
+		}
+		tx = next
+		// Content may have been rewritten, ID must follow.
+		tx.ID = tx.ComputeID()
+	}
+
+	// Trusted transactions skip scoring and go ahead of every other one.
+	if tx.Priority {
+		if vali.priorityVerifier == nil {
+			return nil, &rejectError{ReasonUntrustedPriority, errors.New("priority transactions aren't accepted")}
+		}
+
+		err := vali.priorityVerifier(tx)
+		if err != nil {
+			return nil, &rejectError{ReasonUntrustedPriority, err}
+		}
+
+		tx.prio = math.MaxInt
+		return tx, nil
+	}
+
+	// Calculate the transaction's score.
+	score := config.Score
+	if score == nil {
+		score = vali.scoreFunc()
+	}
+	tx.prio = score(tx)
+
+	return tx, nil
+}
+
+// SetScoreFunc replaces the function scoring transactions without a type
+// specific scorer. It only applies to transactions received afterwards;
+// pending transactions keep the score they've been given. Passing nil
+// restores CalcScore.
+func (vali *Validator) SetScoreFunc(f ScoreFunc) {
+	vali.scoreMu.Lock()
+	defer vali.scoreMu.Unlock()
+
+	vali.score = f
+}
+
+// scoreFunc returns the current default scorer.
+func (vali *Validator) scoreFunc() ScoreFunc {
+	vali.scoreMu.RLock()
+	defer vali.scoreMu.RUnlock()
+
+	if vali.score == nil {
+		return (*Transaction).CalcScore
+	}
+
+	return vali.score
+}
+
+// Errors of decodeTransaction worth telling apart.
+var (
+	errMessageTooLarge = errors.New("message too large")
+	errIDMismatch      = errors.New("id does not match transaction content")
+)
+
+// rejectError is returned by decodeTransaction for transactions
+// that are well-formed but rejected for some reason.
+type rejectError struct {
+	reason DropReason
+	err    error
+}
+
+func (e *rejectError) Error() string {
+	return fmt.Sprintf("transaction rejected (%s): %v", e.reason, e.err)
+}
+
+func (e *rejectError) Unwrap() error {
+	return e.err
+}
+
+// rejectDecoding counts and logs a message decodeTransaction failed on.
+func (vali *Validator) rejectDecoding(msg []byte, err error) {
+	var rejected *rejectError
+	if errors.As(err, &rejected) {
+		vali.dropMessage(msg, rejected.reason, err)
+		log.Print(err)
+		return
+	}
+
+	vali.dropMessage(msg, ReasonMalformed, err)
+	log.Printf("malformed transaction: %v", err)
+}
+
+// normalizeAccounts rewrites every account name the transaction
+// refers to by the db's normalizer.
+func (vali *Validator) normalizeAccounts(tx *Transaction) {
+	tx.Fee.Payer = vali.db.Normalize(tx.Fee.Payer)
+
+	for i := range tx.Instructions {
+		instr := &tx.Instructions[i]
+		instr.Account = vali.db.Normalize(instr.Account)
+
+		// Reference changes carry an account name too.
+		if change, ok := instr.Change.(map[string]any); ok {
+			if account, ok := change["account"].(string); ok {
+				change["account"] = vali.db.Normalize(account)
+			}
+		}
+	}
+}
+
+// ReceiveTransactions receives transactions over the listen address
+// (:2001 by default) and puts them in transaction channel in receive order.
+func (vali *Validator) ReceiveTransactions() {
+	defer vali.wg.Done()
+
+	if vali.batchedReads {
+		vali.receiveBatched()
+		return
+	}
+
+	vali.receive()
+}
+
+// receive reads one datagram per call, this works everywhere.
+func (vali *Validator) receive() {
+	for {
+		var buffer [maxMessageSize]byte
+		len, from, err := vali.conn.ReadFromUDPAddrPort(buffer[0:])
+		if err != nil {
+			// Read is interrupted by Close, we're done.
+			if vali.isClosed() || errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			vali.readFailed(err)
+			continue
+		}
+
+		if !vali.handleMessage(buffer[0:len], from) {
+			return
+		}
+	}
+}
+
+// readFailed records an error of reading from the connection.
+func (vali *Validator) readFailed(err error) {
+	vali.metrics.inc(udpReadErrorsSeries)
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		log.Printf("timed out while receiving a message: %v", err)
+	} else {
+		log.Printf("error while receiving a message: %v", err)
+	}
+}
+
+// handleMessage decodes a message received from given sender and passes
+// it to the processor. Returns false if the validator is closed meanwhile.
+func (vali *Validator) handleMessage(msg []byte, from netip.AddrPort) bool {
+	tx, err := vali.decodeTransaction(msg)
+	if err != nil {
+		vali.rejectDecoding(msg, err)
+		vali.ack(from, rejectionResponse(err))
+		return true
+	}
+
+	// Push to transactions channel.
+	select {
+	case vali.txCh <- tx:
+		vali.ack(from, submitResponse{Accepted: true, ID: tx.ID})
+		return true
+	case <-vali.done:
+		return false
+	}
+}
+
+// ack tells the sender whether its transaction is accepted,
+// in the same form POST /submit responds. See WithUDPAck.
+func (vali *Validator) ack(to netip.AddrPort, response submitResponse) {
+	if !vali.udpAck || !to.IsValid() {
+		return
+	}
+
+	payload, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("failed to encode ack: %v", err)
+		return
+	}
+
+	_, err = vali.conn.WriteToUDPAddrPort(payload, to)
+	if err != nil {
+		log.Printf("failed to send ack to %s: %v", to, err)
+	}
+}
+
+// Changes to the db from outside batch processing wait for the batch
+// being built to be settled: batches are built against the db as of their
+// start, reading the accounts they haven't changed through to it, see
+// AccountsDb.Copy. They mustn't be made from callbacks run while a batch
+// is processed, e.g. a BatchValidator or a commit hook.
+
+// Freeze halts activity on the account: transactions debiting it are
+// dropped until it's unfrozen. See AccountsDb.Freeze.
+func (vali *Validator) Freeze(account string) error {
+	vali.processMu.Lock()
+	defer vali.processMu.Unlock()
+
+	return vali.db.Freeze(account)
+}
+
+// Unfreeze restores normal processing of a frozen account.
+func (vali *Validator) Unfreeze(account string) error {
+	vali.processMu.Lock()
+	defer vali.processMu.Unlock()
+
+	return vali.db.Unfreeze(account)
+}
+
+// SetOverdraft lets the account go down to -limit rather than zero,
+// e.g. for liquidity pools. See AccountsDb.SetOverdraft.
+func (vali *Validator) SetOverdraft(account string, limit float64) error {
+	vali.processMu.Lock()
+	defer vali.processMu.Unlock()
+
+	return vali.db.SetOverdraft(account, limit)
+}
+
+// WithLock runs fn while holding the locks of given accounts in the db,
+// atomically with respect to batch commits, and between batches being
+// built. See AccountsDb.WithLock.
+func (vali *Validator) WithLock(accounts []string, fn func(*adb.AccountsDb) error) error {
+	vali.processMu.Lock()
+	defer vali.processMu.Unlock()
+
+	return vali.db.WithLock(accounts, fn)
+}
+
+// CommitBatch commits changes of the batch to the db. Net changes of the
+// batch are applied at once, readers never see it half-applied. Locks of
+// every account the batch touches are held meanwhile too, so that
+// multi-step operations of AccountsDb.WithLock callers don't interleave.
+//
+// Transactions debiting a frozen account are left out, the ones
+// actually committed are returned. If none is, no batch index is used
+// up and the commit hook isn't called. A payer named in instructions of its
+// own transaction ends up with its instruction changes minus the fee,
+// whatever the PayerPolicy; the policy only applies to building batches.
+func (vali *Validator) CommitBatch(batch []*Transaction) []*Transaction {
+	vali.processMu.Lock()
+	defer vali.processMu.Unlock()
+
+	committed, _ := vali.commit(batch)
+	return committed
+}
+
+// commit is CommitBatch, additionally returning the net change
+// of every account the batch has touched.
+func (vali *Validator) commit(batch []*Transaction) ([]*Transaction, Deltas) {
+	var committed []*Transaction
+	var deltas Deltas
+	accounts := batchAccounts(batch, vali.validatorAccount)
+	vali.conserve("batch commit", func() {
+		vali.db.WithLock(accounts, func(db *adb.AccountsDb) error {
+			committed, deltas = vali.commitBatch(batch)
+			return nil
+		})
+	})
+
+	// Every transaction is left out, there's no batch to speak of.
+	if len(committed) == 0 {
+		return nil, nil
+	}
+
+	vali.recordFees(vali.batchIdx.Load(), committed)
+	vali.recentBatches.add(committed)
+	vali.committedIDs.add(vali.batchIdx.Load(), committed)
+	vali.acceptance.add(vali.clock.Now(), 0, uint64(len(committed)))
+	if vali.commitHook != nil {
+		vali.commitHook(vali.batchIdx.Load(), committed, BatchRoot(committed))
+	}
+
+	vali.batchIdx.Add(1)
+	vali.metrics.observe(batchSizeSeries, vali.batchSizeBounds(), float64(len(committed)))
+
+	return committed, deltas.compact()
+}
+
+// debitsFrozen returns true if the transaction takes balance
+// from a frozen account of the db.
+func (vali *Validator) debitsFrozen(db *adb.AccountsDb, tx *Transaction) bool {
+	return slices.ContainsFunc(tx.debited(vali.validatorAccount), db.IsFrozen)
+}
+
+// chargeFees charges the fees of transactions that failed to execute.
+func (vali *Validator) chargeFees(failed []*Transaction) {
+	if len(failed) == 0 {
+		return
+	}
+
+	vali.conserve("fee charge", func() {
+		vali.db.WithLock(batchAccounts(failed, vali.validatorAccount), func(db *adb.AccountsDb) error {
+			for _, tx := range failed {
+				chargeFee(db, tx)
+			}
+
+			return nil
+		})
+	})
+}
+
+// canPayFee returns true if the payer exists and can afford the fee,
+// counting credits of the transaction to the payer under PayerNetChange.
+func (vali *Validator) canPayFee(db *adb.AccountsDb, tx *Transaction) bool {
+	available, err := db.Available(tx.Fee.Payer)
 	if err != nil {
-		return nil, err
+		return false
 	}
 
-	// Create the transaction heap.
-	txHeap := TransactionHeap{}
-	heap.Init(&txHeap)
-
-	return &Validator{
-		conn:     conn,
-		db:       db,
-		txCh:     make(chan *Transaction, 256),
-		client:   &http.Client{},
-		batchIdx: 0,
-		wg:       sync.WaitGroup{},
-		rl:       ratelimit.New(100),
-		txHeap:   txHeap,
-	}, nil
-}
+	if vali.payerPolicy == PayerNetChange {
+		available += tx.payerCredit()
+	}
 
-// Close closes the underlying UDP connection.
-func (vali *Validator) Close() error {
-	return vali.conn.Close()
+	return available-tx.Fee.Amount >= 0
 }
 
-// PushTransaction pushes a transaction to heap.
-func (vali *Validator) PushTransaction(tx *Transaction) {
-	heap.Push(&vali.txHeap, tx)
+// chargeFee moves the transaction fee from payer to validator account.
+func chargeFee(db *adb.AccountsDb, tx *Transaction) {
+	balance, _ := db.GetBalance(tx.Fee.Payer)
+	db.SetBalance(tx.Fee.Payer, balance-tx.Fee.Amount)
+	db.Earn(tx.Fee.Amount)
 }
 
-// NextTransaction returns the transaction with highest prio.
-func (vali *Validator) NextTransaction() *Transaction {
-	return heap.Pop(&vali.txHeap).(*Transaction)
-}
+// commitBatch applies the batch to the db. See CommitBatch.
+func (vali *Validator) commitBatch(batch []*Transaction) ([]*Transaction, Deltas) {
+	committed := make([]*Transaction, 0, len(batch))
 
-// ReceiveTransactions receives transactions over port :2001
-// and puts them in transaction channel in receive order.
-func (vali *Validator) ReceiveTransactions() {
-	defer vali.wg.Done()
+	// Transactions that haven't been checked for a batch, e.g. ones given
+	// to CommitBatch directly, resolve their references before any
+	// transaction of the batch is applied, like the checked ones did.
+	for _, tx := range batch {
+		if tx.refs == nil {
+			err := tx.resolveReferences(vali.db)
+			if err != nil {
+				panic(err)
+			}
+		}
+	}
 
-	for {
-		// Messages cannot be larger than 1024 bytes.
-		var buffer [1024]byte
-		len, err := vali.conn.Read(buffer[0:])
-		if err != nil {
-			log.Print("error while receiving a message")
+	// Net changes of the batch, applied to the original db at once.
+	deltas := make(Deltas)
+	sequences := make(map[string]uint64)
+	validator := vali.validatorAccount
+	for _, tx := range batch {
+		// Batches are built against frozen accounts already,
+		// only the ones frozen meanwhile can get here.
+		if vali.debitsFrozen(vali.db, tx) {
+			vali.drop(tx, ReasonFrozen, nil)
+			log.Printf("transaction %s debits a frozen account, left out of batch %d", tx.ID, vali.batchIdx.Load())
 			continue
 		}
 
-		msg := buffer[0:len]
-		tx := &Transaction{}
+		deltas[tx.Fee.Payer] -= tx.Fee.Amount
+		deltas[validator] += tx.Fee.Amount
 
-		err = json.Unmarshal(msg, &tx.Transaction)
-		if err != nil {
-			log.Print("malformed transaction")
-			continue
-		}
+		for i, instr := range tx.Instructions {
+			change, err := resolveChange(instr.Change)
+			if err != nil {
+				panic(err)
+			}
 
-		// TODO: Validate JSON.
+			switch change := change.(type) {
+			case float64:
+				deltas[instr.Account] += change
+			case map[string]any:
+				// Referenced balance as of the batch start, whatever
+				// the batch has changed since.
+				deltas[instr.Account] += tx.refs[i]
+			default:
+				panic("unexpected JSON format")
+			}
+		}
 
-		// Calculate the transaction's score.
-		tx.prio = tx.CalcScore()
+		// Validator absorbs the difference of unbalanced transactions.
+		deltas[validator] -= tx.imbalance
 
-		// Push to transactions channel.
-		vali.txCh <- tx
+		vali.stampSequences(tx, sequences)
+		committed = append(committed, tx)
 	}
+
+	vali.db.Apply(deltas, sequences, vali.batchIdx.Load())
+
+	return committed, deltas
 }
 
-func (vali *Validator) CommitBatch(batch []*Transaction) {
-	// Commit changes of the batch to the original db.
-	for _, tx := range batch {
-		{
-			balance, _ := vali.db.GetBalance(tx.Fee.Payer)
-			newBalance := balance - tx.Fee.Amount
-			vali.db.Earn(tx.Fee.Amount)
+// stampSequences gives every account the transaction changes the next
+// commit sequence of the account, so consumers can order changes per
+// account. Sequences holds the last ones given in the batch, starting
+// from the ones in the db.
+func (vali *Validator) stampSequences(tx *Transaction, sequences map[string]uint64) {
+	validator := vali.validatorAccount
+	accounts := []string{tx.Fee.Payer}
+	if tx.Fee.Amount != 0 || tx.imbalance != 0 {
+		accounts = append(accounts, validator)
+	}
+	for _, instr := range tx.Instructions {
+		accounts = append(accounts, instr.Account)
+	}
 
-			vali.db.Accounts[tx.Fee.Payer] = newBalance
+	tx.Sequences = make(map[string]uint64, len(accounts))
+	for _, account := range accounts {
+		if _, ok := tx.Sequences[account]; ok {
+			continue
 		}
 
-		for _, instr := range tx.Instructions {
-			switch change := instr.Change.(type) {
-			case float64:
-				balance, _ := vali.db.GetBalance(instr.Account)
-				newBalance := balance + change
-				vali.db.Accounts[instr.Account] = newBalance
-			case map[string]any:
-				account, ok := change["account"]
-				if !ok {
-					panic("no such account")
-				}
+		sequence, ok := sequences[account]
+		if !ok {
+			balance, _ := vali.db.GetAccount(account)
+			sequence = balance.Sequence
+		}
 
-				balance, _ := vali.db.GetBalance(instr.Account)
+		sequences[account] = sequence + 1
+		tx.Sequences[account] = sequence + 1
+	}
+}
 
-				// Get the balance from batch before (original db).
-				targetBalance, err := vali.db.GetBalance(account.(string))
-				if err != nil {
-					panic(err)
-				}
+// SendBatch sends the batch to the sink, respecting the send rate limit.
+// Returns the status reported by the sink, or an error if the batch
+// couldn't be delivered at all.
+//
+// Once the validator is closed, the rate limit is no longer waited for;
+// the batch is either sent right away or abandoned, depending on the
+// shutdown send policy.
+func (vali *Validator) SendBatch(batch []*Transaction) (int, error) {
+	return vali.sendWith(batch, vali.sink.Send)
+}
 
-				sign, ok := change["sign"]
-				if !ok {
-					panic("sign not found")
-				}
+// sendRange is SendBatch for the committed batches with indexes in range
+// [first, last], which the sink is told if it's a RangeSink.
+func (vali *Validator) sendRange(batch []*Transaction, first, last uint64) (int, error) {
+	sink, ok := vali.sink.(RangeSink)
+	if !ok {
+		return vali.SendBatch(batch)
+	}
 
-				switch sign.(string) {
-				case "plus":
-					newBalance := balance + targetBalance
-					vali.db.Accounts[instr.Account] = newBalance
-				case "minus":
-					newBalance := balance - targetBalance
-					vali.db.Accounts[instr.Account] = newBalance
-				default:
-					panic("unknown sign")
-				}
+	return vali.sendWith(batch, func(batch []*Transaction) (int, error) {
+		return sink.SendRange(batch, first, last)
+	})
+}
 
-			default:
-				panic("unexpected JSON format")
-			}
+// sendWith is SendBatch delivering the batch by given function.
+func (vali *Validator) sendWith(batch []*Transaction, send func([]*Transaction) (int, error)) (int, error) {
+	if !vali.waitRateLimit() {
+		return 0, errors.New("send abandoned on shutdown")
+	}
+	vali.lastSend.Store(vali.clock.Now().UnixNano())
+
+	spans := vali.startSpans(batch, "send")
+	status, err := send(batch)
+	if err == nil && (status < 200 || status > 299) {
+		endSpans(spans, fmt.Errorf("sink responded with status %d", status))
+	} else {
+		endSpans(spans, err)
+	}
+
+	return status, err
+}
+
+// sendDeltas is sendRange for compacted batches.
+func (vali *Validator) sendDeltas(deltas Deltas, first, last uint64) (int, error) {
+	if !vali.waitRateLimit() {
+		return 0, errors.New("send abandoned on shutdown")
+	}
+	vali.lastSend.Store(vali.clock.Now().UnixNano())
+
+	if sink, ok := vali.sink.(RangeDeltaSink); ok {
+		return sink.SendDeltasRange(deltas, first, last)
+	}
+
+	return vali.sink.(DeltaSink).SendDeltas(deltas)
+}
+
+// sendSplitting is sendRange that splits the batch in halves and sends
+// them one by one when the sink finds it too large (413), down to single
+// transactions. Returns the first status that's not 2xx, if any. Every
+// part is sent with the range of the whole batch.
+func (vali *Validator) sendSplitting(batch []*Transaction, first, last uint64) (int, error) {
+	status, err := vali.sendRange(batch, first, last)
+	if err != nil || status != http.StatusRequestEntityTooLarge || len(batch) < 2 {
+		return status, err
+	}
+
+	vali.metrics.inc(batchSplitsSeries)
+	half := len(batch) / 2
+	for _, part := range [][]*Transaction{batch[:half], batch[half:]} {
+		status, err = vali.sendSplitting(part, first, last)
+		if err != nil || status < 200 || status > 299 {
+			return status, err
 		}
 	}
 
-	vali.batchIdx++
+	return status, nil
 }
 
-func (vali *Validator) SendBatch(batch []*Transaction) {
-	buffer, err := json.Marshal(batch)
-	if err != nil {
-		panic(err)
+// waitRateLimit blocks until the rate limit allows another send, or the
+// validator is closed. Returns false if the send is to be abandoned.
+func (vali *Validator) waitRateLimit() bool {
+	if vali.isClosed() {
+		return vali.shutdownSendPolicy != ShutdownAbandon
 	}
 
-	req, err := http.NewRequest("POST", "http://localhost:2002/", bytes.NewBuffer(buffer))
-	if err != nil {
-		panic(err)
+	// Take can't be interrupted, let it finish on its own.
+	taken := make(chan struct{})
+	go func() {
+		vali.rl.Take()
+		close(taken)
+	}()
+
+	select {
+	case <-taken:
+		return true
+	case <-vali.done:
+		return vali.shutdownSendPolicy != ShutdownAbandon
+	}
+}
+
+// sendBatch sends the batch and logs if it's not accepted by the collector.
+// The batch is made of the batches with indexes in range [first, last],
+// a single one unless coalesced, which a RangeSink is told. Batches the
+// collector finds too large are split, see sendSplitting. With
+// compaction, only the net changes of the batch are sent. The returned
+// error covers both failing to send and being rejected.
+func (vali *Validator) sendBatch(batch []*Transaction, deltas Deltas, first, last uint64) error {
+	name := fmt.Sprintf("batch %d", first)
+	if first != last {
+		name = fmt.Sprintf("batches %d-%d", first, last)
+	}
+
+	var status int
+	var err error
+	if vali.compaction {
+		status, err = vali.sendDeltas(deltas, first, last)
+	} else {
+		status, err = vali.sendSplitting(batch, first, last)
+	}
+	if err == nil && (status < 200 || status > 299) {
+		log.Printf("%s rejected by collector with status %d", name, status)
+		err = fmt.Errorf("batch rejected by collector with status %d", status)
+	} else if err != nil {
+		log.Printf("failed to send %s: %v", name, err)
+	}
+
+	// It's the last chance to send, the collector won't get the batch.
+	if err != nil && vali.isClosed() && vali.shutdownSendPolicy == ShutdownDeadLetter {
+		vali.deadLetterBatch(batch, name, first, err)
 	}
 
-	vali.rl.Take()
-	// We don't care the response or error, just send it.
-	vali.client.Do(req)
+	return err
 }
 
-// isCommutative returns true if the tx would be commutative.
-// Additionally returns an error if transaction is malformed and cannot
-// be executed.
+// isCommutative returns true if the tx would be commutative with the
+// batch. Additionally returns an error if transaction is malformed and
+// cannot be executed.
+//
+// Referenced balances are read from start, the balances at the start
+// of the batch, whatever the transactions already in the batch change.
+// They're recorded in the transaction so the commit moves the same amounts.
+// The transaction must be able to execute against start on its own,
+// whether it conflicts with the batch is up to the conflict detector.
 //
 // Only ever modifies the copy db (passed as arg) if the transaction
 // doesn't fail to execute and commutative.
 //
 // Note to myself: This function MUST NEVER COMMIT TO VALIDATOR DB.
-func (vali *Validator) isCommutative(tx *Transaction, db *adb.AccountsDb) (bool, error) {
-	// Changes this tx want to do but in map format.
+func (vali *Validator) isCommutative(tx *Transaction, batch []*Transaction, start, db *adb.AccountsDb) (bool, error) {
+	// Changes this tx want to do but in map format. The fee is a debit
+	// of the payer like any other, see PayerPolicy for credits.
 	changes := make(map[string]float64)
 	changes[tx.Fee.Payer] = -tx.Fee.Amount
 
+	err := tx.resolveReferences(start)
+	if err != nil {
+		return true, err
+	}
+
 	var sum float64 = 0
-	for _, instr := range tx.Instructions {
-		switch change := instr.Change.(type) {
+	for i, instr := range tx.Instructions {
+		change, err := resolveChange(instr.Change)
+		if err != nil {
+			return true, err
+		}
+
+		switch change := change.(type) {
 		case float64:
 			sum += change
 
@@ -211,54 +1288,55 @@ func (vali *Validator) isCommutative(tx *Transaction, db *adb.AccountsDb) (bool,
 			}
 
 		case map[string]any:
-			account, ok := change["account"]
-			if !ok {
-				panic("no such account")
-			}
+			// Referenced balance, signed, as of the batch start.
+			amount := tx.refs[i]
 
-			// Get the balance from batch before (original db).
-			// We can't modify the original db!
-			targetBalance, err := vali.db.GetBalance(account.(string))
-			if err != nil {
-				panic(err)
+			// Referenced balance is too large to move at once.
+			if vali.maxReferenceAmount > 0 && math.Abs(amount) > vali.maxReferenceAmount {
+				return true, fmt.Errorf("referenced balance %v exceeds %v", math.Abs(amount), vali.maxReferenceAmount)
 			}
 
-			sign, ok := change["sign"]
-			if !ok {
-				panic("sign not found")
-			}
+			sum += amount
 
-			switch sign.(string) {
-			case "plus":
-				sum += targetBalance
-				// We're only interested in balance decrease.
+			// We're only interested in balance decrease.
+			if amount > 0 {
 				continue
-			case "minus":
-				oldChange, ok := changes[instr.Account]
-				if ok {
-					changes[instr.Account] = oldChange - targetBalance
-				} else {
-					changes[instr.Account] = targetBalance
-				}
-			default:
-				panic("unknown sign")
 			}
 
+			changes[instr.Account] += amount
+
 		default:
 			panic("unexpected JSON format")
 		}
 	}
 
-	// Sum of the all instructions must be zero.
+	// Credits of the payer fund its fee and debits, but the net change
+	// is only tested and applied if it's a decrease.
+	if vali.payerPolicy == PayerNetChange {
+		changes[tx.Fee.Payer] = min(changes[tx.Fee.Payer]+tx.payerCredit(), 0)
+	}
+
+	// Sum of the all instructions must be zero, unless minting (positive sum)
+	// or burning (negative sum) is allowed. The validator account absorbs
+	// the difference, paying for what's minted and receiving what's burnt.
 	if sum != 0 {
-		return true, errors.New("instruction sum is non-zero")
+		minting := sum > 0 && vali.allowMint
+		burning := sum < 0 && vali.allowBurn
+		if !minting && !burning {
+			return true, errors.New("instruction sum is non-zero")
+		}
+
+		// We're only interested in balance decrease.
+		if minting {
+			changes[vali.validatorAccount] -= sum
+		}
 	}
 
-	// Test each change on the copy db of the current batch.
+	// Test each change on the balances at the batch start.
 	// If any of the changes cause balance to go below zero,
-	// change breaks commutativity so cannot exist in this batch.
+	// the transaction can't execute right now, maybe in next batch.
 	for account, change := range changes {
-		balance, err := db.GetBalance(account)
+		available, err := start.Available(account)
 		if err != nil {
 			if change < 0 {
 				// No account can go/start negative balance.
@@ -270,93 +1348,442 @@ func (vali *Validator) isCommutative(tx *Transaction, db *adb.AccountsDb) (bool,
 			continue
 		}
 
-		// If this change causes balance to go negative, it can break commutativity.
-		newBalance := balance + change
-		if newBalance < 0 {
+		// Frozen accounts can't be debited, whatever the batch.
+		if change < 0 && db.IsFrozen(account) {
+			return true, errors.New("operation debits a frozen account")
+		}
+
+		// If this change causes balance to go negative (beyond the overdraft
+		// allowance), it can't execute.
+		if available+change < 0 {
 			return false, nil
 		}
 	}
 
+	// Whether it breaks commutativity with the batch is up to the
+	// detector, by default the changes are tested on the copy db.
+	tx.debits = changes
+	if vali.conflicts.Conflicts(tx, Batch{Transactions: batch, Balances: db}) {
+		return false, nil
+	}
+
 	// If we got here, none of the changes break the commutativity.
 	// Commit ONLY to copy db.
 	for account, change := range changes {
 		balance, _ := db.GetBalance(account)
 
 		newBalance := balance + change
-		db.Accounts[account] = newBalance
+		db.SetBalance(account, newBalance)
 	}
 
+	// Remember the difference validator absorbs for commit.
+	tx.imbalance = sum
+
 	// Finally all good, this tx can be included in this batch.
 	return true, nil
 }
 
+// buildBatch pops pending transactions until either the batch is
+// full or there are no pending transactions left. Transactions that would break
+// commutativity are returned separately so the caller can decide
+// when to retry them. Transactions that fail to execute but can pay
+// their fee are returned as failed, only their fees are to be charged.
+func (vali *Validator) buildBatch() (batch, failed, deferred []*Transaction) {
+	return vali.buildBatchFrom(vali.NextTransaction, true)
+}
+
+// buildBatchFrom is buildBatch taking candidates from next until it
+// returns nil. The batch is reported by CurrentBatch while it's built if
+// current is true. It's safe to build batches concurrently out of
+// transactions touching disjoint accounts, see WithPartitionedProcessing.
+func (vali *Validator) buildBatchFrom(next func() *Transaction, current bool) (batch, failed, deferred []*Transaction) {
+	// Candidates are collected up to the window, the best of them
+	// make it to the batch.
+	window := max(vali.candidateWindow, vali.batchSize)
+	// Sequential mode commits transactions one by one, in order.
+	if vali.sequential {
+		window = 1
+	}
+
+	// Batch we're filling.
+	batch = make([]*Transaction, 0, window)
+	// Copy the current state of db to apply the batch to. Nothing is
+	// committed to the original one until the batch is, and writers from
+	// outside wait for processMu, so it stays as of the batch start for
+	// referenced balances to be read from.
+	db := vali.db.Copy()
+	// IDs of transactions in the batch, the downstream
+	// must never receive the same transaction twice in a batch.
+	seen := make(map[string]struct{})
+	// Count of transactions per payer in the batch.
+	perPayer := make(map[string]int)
+
+	// We can continue as long as there are slots in candidate window
+	// and pending transactions.
+	for len(batch) < window {
+		tx := next()
+		if tx == nil {
+			break
+		}
+
+		// An identical transaction is already in this batch.
+		id := tx.key()
+		if _, ok := seen[id]; ok {
+			vali.reject(ReasonDuplicate)
+			deferred = append(deferred, tx)
+			continue
+		}
+
+		// Payer has used up its slots in this batch.
+		if vali.maxPerPayer > 0 && perPayer[tx.Fee.Payer] >= vali.maxPerPayer {
+			vali.reject(ReasonPayerQuota)
+			deferred = append(deferred, tx)
+			continue
+		}
+
+		// Frozen accounts can't be debited, not even for the fee.
+		if vali.debitsFrozen(db, tx) {
+			vali.drop(tx, ReasonFrozen, nil)
+			continue
+		}
+
+		// Check if the payer can pay tx fee.
+		// if payer acc do not exist or don't have enough balance, cancel the tx.
+		if !vali.canPayFee(db, tx) {
+			vali.drop(tx, ReasonFeeCheck, errors.New("payer can't pay the fee"))
+			continue
+		}
+
+		isCommutative, err := vali.isCommutative(tx, batch, vali.db, db)
+		if err != nil {
+			// Error indicates this transaction would fail, fee can be paid though.
+			if isCommutative && vali.chargeFeeOnFailure {
+				chargeFee(db, tx)
+				failed = append(failed, tx)
+			}
+
+			vali.drop(tx, ReasonExecution, err)
+			continue
+		}
+
+		// There's no later batch to try in sequential mode, since
+		// the batch is the transaction itself it simply fails to execute.
+		// Fee check has passed, so the fee is charged as usual.
+		if !isCommutative && vali.sequential {
+			if vali.chargeFeeOnFailure {
+				chargeFee(db, tx)
+				failed = append(failed, tx)
+			}
+			vali.drop(tx, ReasonExecution, errors.New("operation causes balance to go negative"))
+			continue
+		}
+
+		// Transaction is not commutative, maybe in next batch!
+		if !isCommutative {
+			vali.reject(ReasonNonCommutative)
+			tx.deferrals++
+			deferred = append(deferred, tx)
+			continue
+		}
+
+		// Transaction is commutative, push to the batch.
+		batch = append(batch, tx)
+		if current {
+			vali.setCurrentBatch(batch)
+		}
+
+		seen[id] = struct{}{}
+		perPayer[tx.Fee.Payer]++
+	}
+
+	// Keep the best candidates. Dropping transactions from a commutative
+	// set keeps it commutative, the rest can try again in the next batch.
+	// Under FIFO, candidates are already in arrival order, the earliest
+	// ones are kept.
+	if len(batch) > vali.batchSize {
+		if vali.policy != FIFO {
+			slices.SortStableFunc(batch, func(a, b *Transaction) int {
+				return cmp.Compare(b.prio, a.prio)
+			})
+		}
+
+		deferred = append(deferred, batch[vali.batchSize:]...)
+		batch = batch[:vali.batchSize:vali.batchSize]
+
+		if current {
+			vali.setCurrentBatch(batch)
+		}
+	}
+
+	return batch, failed, deferred
+}
+
+// CurrentBatch returns a copy of the batch currently being built,
+// committed or sent. Returns an empty slice if there's none.
+func (vali *Validator) CurrentBatch() []*Transaction {
+	vali.inProgressMu.Lock()
+	defer vali.inProgressMu.Unlock()
+
+	return append([]*Transaction{}, vali.inProgress...)
+}
+
+// setCurrentBatch makes the batch the one reported by CurrentBatch.
+func (vali *Validator) setCurrentBatch(batch []*Transaction) {
+	vali.inProgressMu.Lock()
+	defer vali.inProgressMu.Unlock()
+
+	vali.inProgress = batch
+}
+
+// clearCurrentBatch marks the batch in progress as done.
+func (vali *Validator) clearCurrentBatch() {
+	vali.inProgressMu.Lock()
+	defer vali.inProgressMu.Unlock()
+
+	vali.inProgress = nil
+}
+
+// ProcessTransactions orders received transactions and turns them into
+// batches, which are then committed and sent. It returns once the
+// validator is closed.
 func (vali *Validator) ProcessTransactions() {
 	defer vali.wg.Done()
 
 	for {
-		select {
+		// Pending transactions wouldn't be sent anyway.
+		if vali.isClosed() && vali.shutdownSendPolicy == ShutdownAbandon {
+			return
+		}
+
+		// Keep making received transactions pending, but don't batch them.
+		if resume := vali.resumeCh(); resume != nil {
+			vali.flushHeld()
+
+			select {
+			case tx := <-vali.txCh:
+				vali.enqueue(tx)
+			case <-resume:
+			case <-vali.done:
+				return
+			}
+
+			continue
+		}
+
+		// Nothing to batch, block until there's something.
+		if vali.PendingCount() == 0 {
+			vali.flushHeld()
+
+			select {
+			case tx := <-vali.txCh:
+				vali.enqueue(tx)
+			case <-vali.done:
+				vali.flushHeld()
+				return
+			}
+		}
+
+		if vali.batchWindow > 0 {
+			vali.gatherWindow()
+		}
+
 		// Receive unordered transactions and order them.
+		vali.drainIncoming()
+
+		batch, _, _ := vali.processBatch()
+		if len(batch) == 0 {
+			// Every pending transaction conflicts with the current state,
+			// retrying right away would yield the same. Wait a bit, or
+			// until a new transaction arrives, instead of spinning.
+			vali.flushHeld()
+
+			select {
+			case tx := <-vali.txCh:
+				vali.enqueue(tx)
+			case <-vali.clock.After(vali.idleBackoff):
+			case <-vali.done:
+				vali.flushHeld()
+				return
+			}
+
+			continue
+		}
+	}
+}
+
+// gatherWindow keeps making received transactions pending until there
+// are enough of them for a full batch, or the batch window is over.
+func (vali *Validator) gatherWindow() {
+	timer := vali.clock.Timer(vali.batchWindow)
+	defer timer.Stop()
+
+	for vali.PendingCount() < vali.batchSize {
+		select {
 		case tx := <-vali.txCh:
-			vali.PushTransaction(tx)
+			vali.enqueue(tx)
+		case <-timer.C:
+			return
+		case <-vali.done:
+			return
+		}
+	}
+}
 
-		default:
-			if len(vali.txHeap) == 0 {
-				break
-			}
+// Pause stops building batches until Resume is called. Transactions are
+// still received and made pending meanwhile. Explicit calls of Flush
+// still build a batch.
+func (vali *Validator) Pause() {
+	vali.pauseMu.Lock()
+	defer vali.pauseMu.Unlock()
 
-			// Batch we're filling.
-			batch := make([]*Transaction, 0, 100)
-			// Copy the current state of db.
-			db := vali.db.Copy()
+	if vali.resume == nil {
+		vali.resume = make(chan struct{})
+	}
+}
 
-			// We can continue as long as there are slots in batch
-			// and transactions in the heap.
-			for len(batch) < 100 && len(vali.txHeap) > 0 {
-				tx := vali.NextTransaction()
+// Resume continues building batches after Pause.
+func (vali *Validator) Resume() {
+	vali.pauseMu.Lock()
+	defer vali.pauseMu.Unlock()
 
-				// Check if the payer can pay tx fee.
-				balance, err := db.GetBalance(tx.Fee.Payer)
-				// if payer acc do not exist or don't have enough balance, cancel the tx.
-				if err != nil || balance-tx.Fee.Amount < 0 {
-					continue
-				}
+	if vali.resume != nil {
+		close(vali.resume)
+		vali.resume = nil
+	}
+}
 
-				isCommutative, err := vali.isCommutative(tx, db)
-				if err != nil {
-					// Error indicates this transaction would fail, fee can be paid though.
-					if isCommutative {
-						db.Earn(tx.Fee.Amount)
-					}
+// Paused returns true if the validator is paused.
+func (vali *Validator) Paused() bool {
+	return vali.resumeCh() != nil
+}
 
-					continue
-				}
+// resumeCh returns the channel closed on Resume, nil if not paused.
+func (vali *Validator) resumeCh() chan struct{} {
+	vali.pauseMu.Lock()
+	defer vali.pauseMu.Unlock()
+
+	return vali.resume
+}
+
+// settleBatch commits and sends a built batch, and charges the fees of
+// transactions that failed to execute. In dry run, it only reports
+// what it would do. Batches the batch validator vetoes aren't committed,
+// their transactions are returned to be pending again if the veto
+// policy says so.
+//
+// Returns an error if the batch is committed but couldn't be sent.
+func (vali *Validator) settleBatch(batch, failed []*Transaction) (settled, requeued []*Transaction, err error) {
+	defer vali.clearCurrentBatch()
+
+	spans := vali.startBatchSpans(batch)
+	defer func() {
+		endSpans(spans, err)
+		for _, tx := range settled {
+			vali.endTrace(tx, err)
+		}
+	}()
+
+	if len(batch) > 0 && vali.batchValidator != nil {
+		err := vali.batchValidator(batch)
+		if err != nil {
+			vali.metrics.inc(vetoedBatchesSeries)
+			log.Printf("batch of %d transaction(s) vetoed: %v", len(batch), err)
 
-				// Transaction is not commutative, maybe in next batch!
-				if !isCommutative {
-					vali.txCh <- tx
+			for _, tx := range batch {
+				if vali.vetoPolicy == VetoRequeue {
+					vali.reject(ReasonVetoed)
+					requeued = append(requeued, tx)
 					continue
 				}
-
-				// Transaction is commutative, push to the batch.
-				batch = append(batch, tx)
+				vali.drop(tx, ReasonVetoed, err)
 			}
+			batch = nil
+		}
+	}
 
-			if len(batch) == 0 {
-				break
-			}
+	if vali.dryRun {
+		if len(batch) > 0 || len(failed) > 0 {
+			vali.metrics.inc(dryRunBatchesSeries)
+			log.Printf("dry run: would commit %d transaction(s) and charge fees of %d failed one(s)", len(batch), len(failed))
+		}
 
-			vali.CommitBatch(batch)
+		return batch, requeued, nil
+	}
 
-			// Send
-			vali.SendBatch(batch)
-		}
+	vali.chargeFees(failed)
+	if len(batch) == 0 {
+		return batch, requeued, nil
+	}
+
+	batchIdx := vali.batchIdx.Load()
+	commitSpans := vali.startSpans(batch, "commit")
+	batch, deltas := vali.commit(batch)
+	endSpans(commitSpans, nil)
+	if len(batch) == 0 {
+		return batch, nil, nil
+	}
+
+	if vali.coalesce {
+		return batch, nil, vali.coalesceBatch(batch, deltas, batchIdx)
+	}
+
+	// Send
+	return batch, nil, vali.sendBatch(batch, deltas, batchIdx, batchIdx)
+}
+
+// processBatch builds a batch out of pending transactions and settles it,
+// making deferred transactions pending again. Batches are processed one
+// at a time, whoever calls this.
+func (vali *Validator) processBatch() (batch, deferred []*Transaction, err error) {
+	vali.processMu.Lock()
+	defer vali.processMu.Unlock()
+
+	if vali.lanes > 1 && !vali.sequential {
+		return vali.processPartitioned()
+	}
+
+	batch, failed, deferred := vali.buildBatch()
+	batch, requeued, err := vali.settleBatch(batch, failed)
+	deferred = append(deferred, requeued...)
+
+	// Deferred transactions are pending again, maybe in next batch!
+	// They don't go through the channel since we're the only
+	// one receiving from it, pushing many would block us forever.
+	// Under FIFO they stay ahead of transactions that arrived later.
+	deferred = vali.expire(deferred)
+	vali.requeue(deferred)
+
+	return batch, deferred, err
+}
+
+// Flush immediately builds a batch out of pending transactions, however
+// few there are, then commits and sends it. Returns the committed batch,
+// which may be empty. The error reports a batch that's committed but
+// couldn't be sent. In dry run, the batch is only reported, not committed.
+func (vali *Validator) Flush() ([]*Transaction, error) {
+	vali.drainIncoming()
+
+	batch, _, err := vali.processBatch()
+
+	// Batches held back for coalescing go out too.
+	return batch, errors.Join(err, vali.flushHeld())
+}
+
+// drainIncoming makes transactions waiting in the channel pending,
+// without blocking. Only the ones already waiting are taken so that
+// a steady stream of transactions can't hold batching back.
+func (vali *Validator) drainIncoming() {
+	for range len(vali.txCh) {
+		vali.enqueue(<-vali.txCh)
 	}
 }
 
 // Run starts the validator cycle.
 // Start receiving transactions and process them.
 func (vali *Validator) Run() {
-	fmt.Println("Waiting for transactions at localhost:2001...")
+	fmt.Printf("Waiting for transactions at %s...\n", vali.Addr())
+
+	vali.running.Store(true)
 
 	vali.wg.Add(3)
 	// Start receiving transactions.
@@ -365,24 +1792,53 @@ func (vali *Validator) Run() {
 	go vali.ProcessTransactions()
 
 	// Create snapshots.
-	go func() {
-		defer vali.wg.Done()
+	go vali.TakeSnapshots()
 
-		for {
-			buffer, err := json.Marshal(vali.db.Accounts)
-			if err != nil {
-				panic(err)
-			}
+	// Drop pending transactions that can't be afforded anymore.
+	if vali.rescoreInterval > 0 {
+		vali.wg.Add(1)
+		go vali.RescorePending()
+	}
 
-			name := fmt.Sprintf("./accounts-%d-%d.json", time.Now().Unix(), vali.batchIdx)
-			err = os.WriteFile(name, buffer, 0644)
-			if err != nil {
-				panic(err)
-			}
+	// Serve queries.
+	if vali.queryAddr != "" {
+		vali.wg.Add(1)
+		go vali.ServeQueries(vali.queryAddr)
+	}
+
+	// Report load to senders.
+	if vali.statusAddr != "" {
+		vali.wg.Add(1)
+		go vali.ServeStatus(vali.statusAddr)
+	}
+
+	vali.wg.Wait()
+
+	// Kept open while draining, see Close.
+	if vali.deadLetters != nil {
+		err := vali.deadLetters.close()
+		if err != nil {
+			log.Printf("failed to close dead-letter file: %v", err)
+		}
+	}
+	if vali.grpcSink != nil {
+		err := vali.grpcSink.Close()
+		if err != nil {
+			log.Printf("failed to close gRPC sink: %v", err)
+		}
+	}
+}
 
-			<-time.After(time.Second)
+// RunContext is like Run, but closes the validator once ctx is done.
+// Returns after every goroutine of the validator has exited.
+func (vali *Validator) RunContext(ctx context.Context) {
+	go func() {
+		select {
+		case <-ctx.Done():
+			vali.Close()
+		case <-vali.done:
 		}
 	}()
 
-	vali.wg.Wait()
+	vali.Run()
 }
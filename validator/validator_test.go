@@ -0,0 +1,1290 @@
+package validator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	adb "transactioner/accountsdb"
+	"transactioner/models"
+
+	"github.com/benbjohnson/clock"
+)
+
+// writeSnapshot writes a snapshot of given balances, returning its path.
+func writeSnapshot(t testing.TB, balances map[string]float64) string {
+	t.Helper()
+
+	buffer, err := json.Marshal(balances)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot := filepath.Join(t.TempDir(), "accounts.json")
+	err = os.WriteFile(snapshot, buffer, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return snapshot
+}
+
+// newTestValidator creates a validator with given balances, receiving
+// on a free loopback port. It's closed when the test ends.
+func newTestValidator(t testing.TB, balances map[string]float64, opts ...Option) *Validator {
+	t.Helper()
+
+	opts = append([]Option{WithListenAddr("127.0.0.1:0")}, opts...)
+	vali, err := NewFromSnapshot(writeSnapshot(t, balances), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { vali.Close() })
+
+	return vali
+}
+
+// transfer creates a transaction moving amount from one account to
+// another, paying fee.
+func transfer(from, to string, amount, fee float64) *Transaction {
+	tx := &Transaction{Transaction: models.Transaction{
+		Fee: models.Fee{Payer: from, Amount: fee},
+		Instructions: []models.Instruction{
+			{Account: from, Change: -amount},
+			{Account: to, Change: amount},
+		},
+	}}
+	tx.ID = tx.ComputeID()
+
+	return tx
+}
+
+// recordingSink accepts every batch, recording them.
+type recordingSink struct {
+	mu      sync.Mutex
+	batches [][]*Transaction
+}
+
+func (sink *recordingSink) Send(batch []*Transaction) (int, error) {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+
+	sink.batches = append(sink.batches, batch)
+	return http.StatusOK, nil
+}
+
+// sent returns the batches sent so far.
+func (sink *recordingSink) sent() [][]*Transaction {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+
+	return slices.Clone(sink.batches)
+}
+
+// processAll commits batches until nothing that can be batched is left,
+// returning them.
+func processAll(t testing.TB, vali *Validator) [][]*Transaction {
+	t.Helper()
+
+	var batches [][]*Transaction
+	for vali.PendingCount() > 0 {
+		batch, failed, deferred := vali.buildBatch()
+		vali.chargeFees(failed)
+		vali.requeue(deferred)
+		if len(batch) == 0 {
+			break
+		}
+		vali.CommitBatch(batch)
+		batches = append(batches, batch)
+	}
+
+	return batches
+}
+
+func TestAccountNormalizer(t *testing.T) {
+	vali := newTestValidator(t, map[string]float64{"Alice ": 100, "bob": 0},
+		WithAccountNormalizer(adb.TrimLower))
+
+	receive(t, vali, transfer("alice", " BOB", 10, 1))
+	receive(t, vali, transfer("ALICE", "Bob", 10, 1))
+	batches := processAll(t, vali)
+	if len(batches) == 0 {
+		t.Fatal("nothing was committed")
+	}
+
+	for account, want := range map[string]float64{"Alice ": 78, "alice": 78, "bob": 20, "BOB ": 20} {
+		balance, err := vali.db.GetBalance(account)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if balance != want {
+			t.Errorf("balance of %q is %v, want %v", account, balance, want)
+		}
+	}
+	if n := vali.db.AccountCount(false); n != 2 {
+		t.Errorf("got %d accounts, want 2", n)
+	}
+}
+
+func TestStrictDecoding(t *testing.T) {
+	// Fee is misspelled.
+	msg := `{"fees": {"payer": "alice", "amount": 1}, "instructions": [{"account": "alice", "change": -1}, {"account": "bob", "change": 1}]}`
+
+	for _, strict := range []bool{false, true} {
+		vali := newTestValidator(t, map[string]float64{"alice": 100, "bob": 0}, WithStrictDecoding(strict))
+
+		_, err := vali.decodeTransaction([]byte(msg))
+		if strict && (err == nil || !strings.Contains(err.Error(), `unknown field "fees"`)) {
+			t.Errorf("strict decoding gave error %v, want an unknown field", err)
+		}
+		if !strict && err != nil {
+			t.Errorf("lenient decoding failed: %v", err)
+		}
+
+		vali.Close()
+	}
+}
+
+func TestBatchDeduplication(t *testing.T) {
+	vali := newTestValidator(t, map[string]float64{"alice": 100, "bob": 0})
+
+	// Same transaction, requeued twice.
+	tx := transfer("alice", "bob", 10, 1)
+	copy := *tx
+	vali.requeue([]*Transaction{tx, &copy})
+
+	batch, _, deferred := vali.buildBatch()
+	if len(batch) != 1 {
+		t.Fatalf("batch has %d transaction(s), want 1", len(batch))
+	}
+	if n := vali.Rejections(ReasonDuplicate); n != 1 {
+		t.Errorf("%d duplicate rejection(s), want 1", n)
+	}
+
+	// The duplicate is deferred, not dropped.
+	if len(deferred) != 1 {
+		t.Errorf("%d transaction(s) deferred, want 1", len(deferred))
+	}
+}
+
+func TestMaxPerPayerPerBatch(t *testing.T) {
+	vali := newTestValidator(t, map[string]float64{"alice": 1000, "bob": 10, "carol": 10},
+		WithMaxPerPayerPerBatch(2))
+
+	// Alice floods the validator with transactions paying more.
+	for i := range 20 {
+		receive(t, vali, transfer("alice", "dave", float64(i+1), 5))
+	}
+	receive(t, vali, transfer("bob", "dave", 1, 1))
+	receive(t, vali, transfer("carol", "dave", 1, 1))
+
+	batch, _, deferred := vali.buildBatch()
+	perPayer := make(map[string]int)
+	for _, tx := range batch {
+		perPayer[tx.Fee.Payer]++
+	}
+	if perPayer["alice"] != 2 || perPayer["bob"] != 1 || perPayer["carol"] != 1 {
+		t.Errorf("batch has transactions by payer %v, want 2 of alice, 1 of bob and 1 of carol", perPayer)
+	}
+
+	// The rest of alice's transactions are deferred.
+	if len(deferred) != 18 {
+		t.Errorf("%d transaction(s) deferred, want 18", len(deferred))
+	}
+}
+
+// blockingSink accepts every batch, but only once it's released.
+type blockingSink struct {
+	sending chan []*Transaction
+	release chan struct{}
+}
+
+func (sink *blockingSink) Send(batch []*Transaction) (int, error) {
+	sink.sending <- batch
+	<-sink.release
+	return http.StatusOK, nil
+}
+
+func TestCurrentBatch(t *testing.T) {
+	sink := &blockingSink{sending: make(chan []*Transaction), release: make(chan struct{})}
+	vali := newTestValidator(t, map[string]float64{"alice": 100, "bob": 100}, WithSink(sink))
+
+	if batch := vali.CurrentBatch(); len(batch) != 0 {
+		t.Errorf("batch in progress has %d transaction(s) before any is built", len(batch))
+	}
+
+	var lines bytes.Buffer
+	for _, tx := range []*Transaction{transfer("alice", "carol", 10, 1), transfer("bob", "carol", 10, 1)} {
+		lines.Write(append(encode(t, tx), '\n'))
+	}
+
+	replayed := make(chan error)
+	go func() {
+		replayed <- vali.Replay(&lines)
+	}()
+
+	// Batch is in progress while it's being sent.
+	sending := <-sink.sending
+	current := vali.CurrentBatch()
+	if len(current) != 2 || !slices.Equal(current, sending) {
+		t.Errorf("batch in progress has %d transaction(s), want the 2 being sent", len(current))
+	}
+	close(sink.release)
+
+	if err := <-replayed; err != nil {
+		t.Fatal(err)
+	}
+	if batch := vali.CurrentBatch(); len(batch) != 0 {
+		t.Errorf("batch in progress has %d transaction(s) once done", len(batch))
+	}
+}
+
+func TestOverRangeAmounts(t *testing.T) {
+	vali := newTestValidator(t, map[string]float64{"alice": 100, "bob": 0}, WithSink(&recordingSink{}))
+
+	lines := strings.Join([]string{
+		// An over-range fee can't be decoded at all.
+		`{"fee": {"payer": "alice", "amount": 1e400}, "instructions": [{"account": "alice", "change": -1}, {"account": "bob", "change": 1}]}`,
+		`{"fee": {"payer": "alice", "amount": 1}, "instructions": [{"account": "alice", "change": -1e400}, {"account": "bob", "change": 1e400}]}`,
+	}, "\n")
+	err := vali.Replay(strings.NewReader(lines))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := vali.Rejections(ReasonMalformed); n != 1 {
+		t.Errorf("%d malformed rejection(s), want 1", n)
+	}
+	if n := vali.Rejections(ReasonInvalid); n != 1 {
+		t.Errorf("%d invalid rejection(s), want 1", n)
+	}
+	if balance, _ := vali.db.GetBalance("bob"); balance != 0 {
+		t.Errorf("bob got %v", balance)
+	}
+}
+
+// waitFor polls cond until it's true, failing the test if it takes long.
+func waitFor(t testing.TB, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestIdleBackoff(t *testing.T) {
+	mock := clock.NewMock()
+	vali := newTestValidator(t, map[string]float64{"alice": 1, "bob": 1},
+		WithClock(mock), WithIdleBackoff(time.Second))
+
+	// Both can pay the fee, neither the transfer.
+	vali.PushTransaction(transfer("alice", "bob", 10, 1))
+	vali.PushTransaction(transfer("bob", "alice", 10, 1))
+
+	vali.wg.Add(1)
+	go vali.ProcessTransactions()
+
+	// Every pass defers both transactions.
+	passes := func() uint64 {
+		return vali.Rejections(ReasonNonCommutative) / 2
+	}
+	waitFor(t, func() bool { return passes() == 1 })
+
+	// No retry until the backoff is over.
+	time.Sleep(50 * time.Millisecond)
+	if n := passes(); n != 1 {
+		t.Fatalf("%d passes before the backoff is over, want 1", n)
+	}
+
+	waitFor(t, func() bool {
+		mock.Add(time.Second)
+		return passes() >= 2
+	})
+
+	vali.Close()
+	vali.wg.Wait()
+	if n := vali.PendingCount(); n != 2 {
+		t.Errorf("%d transaction(s) pending, want 2", n)
+	}
+}
+
+func TestBatchWindow(t *testing.T) {
+	const window = time.Second
+
+	for _, full := range []bool{false, true} {
+		mock := clock.NewMock()
+		sink := &recordingSink{}
+		vali := newTestValidator(t, map[string]float64{"alice": 100}, WithClock(mock),
+			WithBatchWindow(window), WithBatchSize(3), WithSink(sink))
+
+		vali.wg.Add(1)
+		go vali.ProcessTransactions()
+		defer func() {
+			vali.Close()
+			vali.wg.Wait()
+		}()
+
+		n := 2
+		if full {
+			n = 3
+		}
+		for i := range n {
+			vali.handleMessage(encode(t, transfer("alice", "bob", float64(i+1), 1)), netip.AddrPort{})
+		}
+
+		// A full batch doesn't wait for the window.
+		if full {
+			waitFor(t, func() bool { return len(sink.sent()) == 1 })
+			if batch := sink.sent()[0]; len(batch) != 3 {
+				t.Errorf("sent a full batch of %d, want 3", len(batch))
+			}
+			continue
+		}
+
+		// Both gathered, the window is still open.
+		waitFor(t, func() bool { return vali.PendingCount() == n })
+		mock.Add(window - time.Millisecond)
+		time.Sleep(50 * time.Millisecond)
+		if sent := sink.sent(); len(sent) != 0 {
+			t.Fatalf("sent %d batch(es) before the window is over", len(sent))
+		}
+
+		mock.Add(time.Millisecond)
+		waitFor(t, func() bool { return len(sink.sent()) == 1 })
+		if batch := sink.sent()[0]; len(batch) != 2 {
+			t.Errorf("sent a batch of %d at the window boundary, want 2", len(batch))
+		}
+	}
+}
+
+func TestOnAccountCreated(t *testing.T) {
+	vali := newTestValidator(t, map[string]float64{"alice": 100, "bob": 0})
+
+	var mu sync.Mutex
+	created := make(map[string]int)
+	vali.OnAccountCreated(func(account string, initialBalance float64) {
+		mu.Lock()
+		defer mu.Unlock()
+		created[account]++
+	})
+
+	receive(t, vali, transfer("alice", "bob", 10, 1))
+	receive(t, vali, transfer("alice", "carol", 10, 1))
+	processAll(t, vali)
+	receive(t, vali, transfer("alice", "carol", 5, 1))
+	processAll(t, vali)
+	if balance, _ := vali.db.GetBalance("carol"); balance != 15 {
+		t.Fatalf("carol has %v, want 15", balance)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(created) != 1 || created["carol"] != 1 {
+		t.Errorf("created accounts %v, want carol once", created)
+	}
+}
+
+func TestTypeConfig(t *testing.T) {
+	vali := newTestValidator(t, map[string]float64{"alice": 100}, WithTypeConfig(map[string]TypeConfig{
+		"transfer": {MinFee: 1},
+		"swap": {MinFee: 5, Score: func(tx *Transaction) int {
+			return 1000
+		}},
+	}))
+
+	typed := func(kind string, fee float64) *Transaction {
+		tx := transfer("alice", "bob", 1, fee)
+		tx.Type = kind
+		tx.ID = tx.ComputeID()
+		return tx
+	}
+
+	tests := []struct {
+		tx       *Transaction
+		accepted bool
+	}{
+		{typed("transfer", 1), true},
+		{typed("transfer", 0.5), false},
+		{typed("swap", 5), true},
+		{typed("swap", 2), false},
+		// Unknown types and untyped ones have no min fee.
+		{typed("mint", 0), true},
+		{typed("", 0), true},
+	}
+	for _, test := range tests {
+		tx, err := vali.decodeTransaction(encode(t, test.tx))
+		if test.accepted && err != nil {
+			t.Errorf("%q paying %v rejected: %v", test.tx.Type, test.tx.Fee.Amount, err)
+		}
+		if !test.accepted && err == nil {
+			t.Errorf("%q paying %v accepted", test.tx.Type, test.tx.Fee.Amount)
+		}
+
+		// Swaps are scored by their own scorer.
+		if err == nil && (tx.Type == "swap") != (tx.prio == 1000) {
+			t.Errorf("%q scored %d", tx.Type, tx.prio)
+		}
+	}
+}
+
+func TestAllowUnbalanced(t *testing.T) {
+	single := func(account string, change float64) *Transaction {
+		return &Transaction{Transaction: models.Transaction{
+			Fee:          models.Fee{Payer: "alice", Amount: 1},
+			Instructions: []models.Instruction{{Account: account, Change: change}},
+		}}
+	}
+	mint := single("bob", 10)
+	burn := single("alice", -10)
+
+	tests := []struct {
+		mint, burn bool
+		tx         *Transaction
+		committed  bool
+		// Balances of alice, bob and validator afterwards.
+		want [3]float64
+	}{
+		{false, false, mint, false, [3]float64{100, 0, 100}},
+		{false, false, burn, false, [3]float64{100, 0, 100}},
+		{true, false, mint, true, [3]float64{99, 10, 91}},
+		{true, false, burn, false, [3]float64{100, 0, 100}},
+		{false, true, mint, false, [3]float64{100, 0, 100}},
+		{false, true, burn, true, [3]float64{89, 0, 111}},
+	}
+	for _, test := range tests {
+		balances := map[string]float64{"alice": 100, "bob": 0, adb.ValidatorAccount: 100}
+		vali := newTestValidator(t, balances, WithAllowUnbalanced(test.mint, test.burn))
+
+		copy := *test.tx
+		vali.PushTransaction(&copy)
+		batch, _, _ := vali.buildBatch()
+		vali.CommitBatch(batch)
+		if committed := len(batch) == 1; committed != test.committed {
+			t.Errorf("mint %v, burn %v: %+v committed %v, want %v", test.mint, test.burn,
+				test.tx.Instructions[0], committed, test.committed)
+		}
+
+		var got [3]float64
+		for i, account := range []string{"alice", "bob", adb.ValidatorAccount} {
+			got[i], _ = vali.db.GetBalance(account)
+		}
+		if got != test.want {
+			t.Errorf("mint %v, burn %v: %+v left balances %v, want %v", test.mint, test.burn,
+				test.tx.Instructions[0], got, test.want)
+		}
+		vali.Close()
+	}
+}
+
+func TestMaxPendingBytes(t *testing.T) {
+	vali := newTestValidator(t, map[string]float64{"alice": 100},
+		WithMaxPending(100), WithMaxPendingBytes(2400))
+
+	// About a KB each, only two fit.
+	large := strings.Repeat("b", 800)
+	for i := range 4 {
+		receive(t, vali, transfer("alice", fmt.Sprintf("%s%d", large, i), 1, 1))
+	}
+
+	if n := vali.PendingCount(); n != 2 {
+		t.Errorf("%d transaction(s) pending, want 2", n)
+	}
+	if n := vali.Rejections(ReasonPendingBytes); n != 2 {
+		t.Errorf("%d rejection(s) for pending bytes, want 2", n)
+	}
+	if n := vali.Rejections(ReasonPendingFull); n != 0 {
+		t.Errorf("%d rejection(s) for pending count, want 0", n)
+	}
+
+	// Small ones still fit in what's left.
+	receive(t, vali, transfer("alice", "bob", 1, 1))
+	if n := vali.PendingCount(); n != 3 {
+		t.Errorf("%d transaction(s) pending, want 3", n)
+	}
+}
+
+func TestSetScoreFunc(t *testing.T) {
+	vali := newTestValidator(t, map[string]float64{"alice": 100})
+
+	before := transfer("alice", "bob", 1, 1)
+	receive(t, vali, before)
+
+	vali.SetScoreFunc(func(tx *Transaction) int { return 42 })
+	after := transfer("alice", "bob", 2, 1)
+	receive(t, vali, after)
+
+	// Keyed by the amount transferred, which tells them apart.
+	scores := make(map[string]int)
+	for vali.PendingCount() > 0 {
+		tx := vali.NextTransaction()
+		scores[fmt.Sprint(tx.Instructions[1].Change)] = tx.prio
+	}
+	if score := scores["1"]; score != before.CalcScore() {
+		t.Errorf("transaction received before the swap scored %d, want %d", score, before.CalcScore())
+	}
+	if score := scores["2"]; score != 42 {
+		t.Errorf("transaction received after the swap scored %d, want 42", score)
+	}
+
+	// Back to the default.
+	vali.SetScoreFunc(nil)
+	tx := transfer("alice", "bob", 3, 1)
+	receive(t, vali, tx)
+	if got := vali.NextTransaction(); got.prio != tx.CalcScore() {
+		t.Errorf("transaction scored %d once the scorer is reset, want %d", got.prio, tx.CalcScore())
+	}
+}
+
+// Run with -race.
+func TestSetScoreFuncWhileReceiving(t *testing.T) {
+	vali := newTestValidator(t, map[string]float64{"alice": 100})
+
+	msgs := make([][]byte, 200)
+	for i := range msgs {
+		msgs[i] = encode(t, transfer("alice", "bob", float64(i+1), 1))
+	}
+
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		for _, msg := range msgs {
+			tx, err := vali.decodeTransaction(msg)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			vali.enqueue(tx)
+		}
+	})
+	for i := range 200 {
+		vali.SetScoreFunc(func(tx *Transaction) int { return i })
+	}
+	wg.Wait()
+
+	if n := vali.PendingCount(); n != 200 {
+		t.Errorf("%d transaction(s) pending, want 200", n)
+	}
+}
+
+func TestNoInstructions(t *testing.T) {
+	vali := newTestValidator(t, map[string]float64{"alice": 100})
+
+	// Pays a lot, but does nothing.
+	for _, msg := range []string{
+		`{"fee": {"payer": "alice", "amount": 50}, "instructions": []}`,
+		`{"fee": {"payer": "alice", "amount": 50}}`,
+	} {
+		tx, err := vali.decodeTransaction([]byte(msg))
+		if err != nil {
+			vali.rejectDecoding([]byte(msg), err)
+			continue
+		}
+		vali.PushTransaction(tx)
+	}
+
+	if batches := processAll(t, vali); len(batches) != 0 {
+		t.Errorf("committed %v without instructions", batches)
+	}
+	if n := vali.Rejections(ReasonInvalid); n != 2 {
+		t.Errorf("%d invalid rejection(s), want 2", n)
+	}
+	if balance, _ := vali.db.GetBalance("alice"); balance != 100 {
+		t.Errorf("alice was charged, %v left", balance)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	sink := &recordingSink{}
+	balances := map[string]float64{"alice": 100, "bob": 0}
+	vali := newTestValidator(t, balances, WithDryRun(true), WithSink(sink))
+
+	receive(t, vali, transfer("alice", "bob", 10, 1))
+	receive(t, vali, transfer("alice", "carol", 10, 1))
+	// Bob only has what alice's transfer would give him.
+	receive(t, vali, transfer("bob", "carol", 10, 0))
+	batch, failed, deferred := vali.buildBatch()
+	vali.settleBatch(batch, failed)
+	vali.requeue(deferred)
+
+	if len(batch) != 2 {
+		t.Errorf("would-be batch %v, want 2 transactions", batch)
+	}
+	if n := vali.PendingCount(); n != 1 {
+		t.Errorf("%d transaction(s) pending, want bob's", n)
+	}
+	for account, want := range balances {
+		if got, _ := vali.db.GetBalance(account); got != want {
+			t.Errorf("db is mutated, %s has %v", account, got)
+		}
+	}
+	if n := len(sink.sent()); n != 0 {
+		t.Errorf("sent %d batch(es)", n)
+	}
+	if n := vali.metrics.counter(dryRunBatchesSeries); n != 1 {
+		t.Errorf("%d dry run batch(es) counted, want 1", n)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	sink := &recordingSink{}
+	vali := newTestValidator(t, map[string]float64{"alice": 100, "bob": 50}, WithSink(sink))
+
+	// Nothing to flush.
+	batch, err := vali.Flush()
+	if err != nil || len(batch) != 0 {
+		t.Fatalf("flushed %d transaction(s), error %v", len(batch), err)
+	}
+
+	// Far fewer than a batch, still in the channel.
+	for _, tx := range []*Transaction{transfer("alice", "carol", 10, 1), transfer("bob", "carol", 5, 1)} {
+		decoded, err := vali.decodeTransaction(encode(t, tx))
+		if err != nil {
+			t.Fatal(err)
+		}
+		vali.txCh <- decoded
+	}
+	vali.PushTransaction(transfer("alice", "bob", 1, 1))
+
+	batch, err = vali.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batch) != 3 {
+		t.Errorf("flushed %d transaction(s), want 3", len(batch))
+	}
+	if sent := sink.sent(); len(sent) != 1 || len(sent[0]) != 3 {
+		t.Errorf("sent %v, want a batch of 3", sent)
+	}
+	want := map[string]float64{"alice": 87, "bob": 45, "carol": 15, adb.ValidatorAccount: 3}
+	for account, want := range want {
+		if got, _ := vali.db.GetBalance(account); got != want {
+			t.Errorf("%s has %v, want %v", account, got, want)
+		}
+	}
+	if n := vali.PendingCount(); n != 0 {
+		t.Errorf("%d transaction(s) left pending", n)
+	}
+}
+
+func TestTransactionMiddleware(t *testing.T) {
+	middleware := func(tx *Transaction) (*Transaction, error) {
+		switch tx.Fee.Payer {
+		case "mallory":
+			return nil, errors.New("payer is blocked")
+		case "Alice":
+			// Rewritten into a new transaction.
+			next := *tx
+			next.Fee.Payer = "alice"
+			next.Instructions = slices.Clone(tx.Instructions)
+			next.Instructions[0].Account = "alice"
+			return &next, nil
+		}
+		return tx, nil
+	}
+	vali := newTestValidator(t, map[string]float64{"alice": 100, "bob": 100, "mallory": 100},
+		WithTransactionMiddleware(middleware), WithSink(&recordingSink{}))
+
+	receive(t, vali, transfer("mallory", "carol", 10, 1))
+	rewritten := transfer("Alice", "carol", 10, 1)
+	receive(t, vali, rewritten)
+	receive(t, vali, transfer("bob", "carol", 10, 1))
+
+	if n := vali.Rejections(ReasonMiddleware); n != 1 {
+		t.Errorf("%d middleware rejection(s), want 1", n)
+	}
+
+	batch, err := vali.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batch) != 2 {
+		t.Fatalf("committed %d transaction(s), want 2", len(batch))
+	}
+	for _, tx := range batch {
+		if tx.ID != tx.ComputeID() {
+			t.Errorf("ID of %s's transaction doesn't follow its content", tx.Fee.Payer)
+		}
+		if tx.ID == rewritten.ComputeID() {
+			t.Error("rewritten transaction kept its ID")
+		}
+	}
+
+	want := map[string]float64{"alice": 89, "bob": 89, "carol": 20, "mallory": 100, adb.ValidatorAccount: 2}
+	for account, want := range want {
+		if got, _ := vali.db.GetBalance(account); got != want {
+			t.Errorf("%s has %v, want %v", account, got, want)
+		}
+	}
+}
+
+func TestFrozenAccountInBatch(t *testing.T) {
+	snapshot := filepath.Join(t.TempDir(), "accounts.json")
+	err := os.WriteFile(snapshot, []byte(`{"alice": 100, "dave": {"amount": 50, "frozen": true}}`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vali, err := NewFromSnapshot(snapshot, WithListenAddr("127.0.0.1:0"), WithSink(&recordingSink{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vali.Close()
+
+	// Debits dave, though alice pays for it.
+	fromDave := transfer("alice", "bob", 10, 1)
+	fromDave.Instructions[0].Account = "dave"
+	vali.PushTransaction(fromDave)
+	// Credits dave, fine.
+	vali.PushTransaction(transfer("alice", "dave", 10, 1))
+
+	batch, err := vali.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batch) != 1 || batch[0].Instructions[1].Account != "dave" {
+		t.Errorf("committed %v, want only the credit of dave", batch)
+	}
+	if n := vali.Rejections(ReasonFrozen); n != 1 {
+		t.Errorf("%d frozen rejection(s), want 1", n)
+	}
+	if balance, _ := vali.db.GetBalance("dave"); balance != 60 {
+		t.Errorf("dave has %v, want 60", balance)
+	}
+}
+
+func TestFreezeAndUnfreeze(t *testing.T) {
+	vali := newTestValidator(t, map[string]float64{"alice": 100}, WithSink(&recordingSink{}))
+
+	if err := vali.Freeze("nobody"); err == nil {
+		t.Error("froze a missing account")
+	}
+
+	err := vali.Freeze("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	receive(t, vali, transfer("alice", "bob", 10, 1))
+	batch, err := vali.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batch) != 0 {
+		t.Errorf("committed %d transaction(s) of a frozen payer", len(batch))
+	}
+	if n := vali.Rejections(ReasonFrozen); n != 1 {
+		t.Errorf("%d frozen rejection(s), want 1", n)
+	}
+	// Not even the fee is taken.
+	if balance, _ := vali.db.GetBalance("alice"); balance != 100 {
+		t.Errorf("alice has %v, want 100", balance)
+	}
+
+	err = vali.Unfreeze("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	receive(t, vali, transfer("alice", "bob", 10, 1))
+	batch, err = vali.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batch) != 1 {
+		t.Errorf("committed %d transaction(s) after unfreezing, want 1", len(batch))
+	}
+	if balance, _ := vali.db.GetBalance("alice"); balance != 89 {
+		t.Errorf("alice has %v, want 89", balance)
+	}
+}
+
+func TestShutdownSendPolicy(t *testing.T) {
+	const batches = 5
+	t.Chdir(t.TempDir())
+
+	for _, policy := range []ShutdownSendPolicy{ShutdownFlush, ShutdownAbandon} {
+		// The mock clock never moves, so sends past the first one
+		// would wait for the rate limit forever. The limiter doesn't
+		// limit at all at the zero time though.
+		mock := clock.NewMock()
+		mock.Set(time.Now())
+		sink := &recordingSink{}
+		vali := newTestValidator(t, map[string]float64{"alice": 100}, WithBatchSize(1), WithSink(sink),
+			WithClock(mock), WithShutdownSendPolicy(policy))
+		for i := range batches {
+			vali.PushTransaction(transfer("alice", "bob", float64(i+1), 1))
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			vali.RunContext(ctx)
+			close(done)
+		}()
+
+		waitFor(t, func() bool { return len(sink.sent()) > 0 })
+		time.Sleep(20 * time.Millisecond)
+		if sent := len(sink.sent()); sent != 1 {
+			t.Fatalf("policy %d: sent %d batch(es) before shutdown, want 1", policy, sent)
+		}
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("policy %d: shutdown is blocked on the rate limit", policy)
+		}
+
+		sent := len(sink.sent())
+		switch policy {
+		case ShutdownFlush:
+			if sent != batches {
+				t.Errorf("flushed %d batch(es) on shutdown, want %d", sent, batches)
+			}
+		case ShutdownAbandon:
+			if sent != 1 {
+				t.Errorf("sent %d batch(es) on shutdown, want the rest abandoned", sent)
+			}
+		}
+	}
+}
+
+// balancedTransfers returns n distinct transfers between a few accounts,
+// none of them able to drain an account however they're ordered.
+func balancedTransfers(n int) (map[string]float64, []*Transaction) {
+	accounts := []string{"alice", "bob", "carol", "dave", "erin"}
+	balances := make(map[string]float64)
+	for _, account := range accounts {
+		balances[account] = float64(n * (n + 5))
+	}
+
+	r := rand.New(rand.NewPCG(1, 2))
+	txs := make([]*Transaction, n)
+	for i := range txs {
+		from := accounts[r.IntN(len(accounts))]
+		to := accounts[r.IntN(len(accounts))]
+		txs[i] = transfer(from, to, float64(i+1), float64(1+r.IntN(3)))
+	}
+
+	return balances, txs
+}
+
+func TestSequentialModeMatchesBatched(t *testing.T) {
+	const n = 60
+
+	// The mock clock stays at the zero time, where the rate limiter
+	// doesn't wait, a batch per transaction would take a while otherwise.
+	var results []map[string]float64
+	for _, sequential := range []bool{false, true} {
+		// Transactions are pushed as they are, each run takes its own.
+		balances, txs := balancedTransfers(n)
+		vali := newTestValidator(t, balances, WithSequentialMode(sequential), WithBatchSize(16),
+			WithClock(clock.NewMock()), WithSink(&recordingSink{}))
+		for _, tx := range txs {
+			vali.PushTransaction(tx)
+		}
+
+		batches := processAll(t, vali)
+		committed := 0
+		for _, batch := range batches {
+			if sequential && len(batch) != 1 {
+				t.Errorf("sequential mode committed a batch of %d", len(batch))
+			}
+			committed += len(batch)
+		}
+		if committed != n {
+			t.Errorf("sequential=%v: committed %d transaction(s), want %d", sequential, committed, n)
+		}
+
+		results = append(results, vali.db.Balances())
+	}
+
+	if !maps.Equal(results[0], results[1]) {
+		t.Errorf("batched mode ends with %v, sequential mode with %v", results[0], results[1])
+	}
+}
+
+// BenchmarkSequentialMode processes the same transactions committed
+// one at a time and in batches.
+func BenchmarkSequentialMode(b *testing.B) {
+	const n = 256
+
+	for _, sequential := range []bool{false, true} {
+		b.Run(fmt.Sprintf("sequential=%v", sequential), func(b *testing.B) {
+			for b.Loop() {
+				b.StopTimer()
+				balances, txs := balancedTransfers(n)
+				vali := newTestValidator(b, balances, WithSequentialMode(sequential),
+					WithClock(clock.NewMock()), WithSink(&recordingSink{}))
+				for _, tx := range txs {
+					vali.PushTransaction(tx)
+				}
+				b.StartTimer()
+
+				processAll(b, vali)
+
+				b.StopTimer()
+				vali.Close()
+				b.StartTimer()
+			}
+			b.ReportMetric(float64(b.N*n)/b.Elapsed().Seconds(), "tx/s")
+		})
+	}
+}
+
+func TestMaxFee(t *testing.T) {
+	vali := newTestValidator(t, map[string]float64{"alice": 1000}, WithMaxFee(10))
+
+	vali.handleMessage(encode(t, transfer("alice", "bob", 1, 10)), netip.AddrPort{})
+	vali.handleMessage(encode(t, transfer("alice", "bob", 1, 10.5)), netip.AddrPort{})
+	vali.drainIncoming()
+	if n := vali.PendingCount(); n != 1 {
+		t.Errorf("%d transaction(s) pending, want only the one at the max fee", n)
+	}
+	if n := vali.Rejections(ReasonMaxFee); n != 1 {
+		t.Errorf("%d max fee rejection(s), want 1", n)
+	}
+
+	var response submitResponse
+	post(t, vali, "/submit", string(encode(t, transfer("alice", "bob", 1, 500))), &response)
+	if response.Accepted || response.Reason != ReasonMaxFee {
+		t.Errorf("got %+v, want reason %s", response, ReasonMaxFee)
+	}
+
+	// No limit by default.
+	unlimited := newTestValidator(t, map[string]float64{"alice": 1000})
+	receive(t, unlimited, transfer("alice", "bob", 1, 500))
+	if n := unlimited.PendingCount(); n != 1 {
+		t.Errorf("%d transaction(s) pending without a max fee, want 1", n)
+	}
+}
+
+func TestChargeFeeOnFailure(t *testing.T) {
+	for _, charge := range []bool{true, false} {
+		vali := newTestValidator(t, map[string]float64{"alice": 100, "bob": 0},
+			WithChargeFeeOnFailure(charge), WithSink(&recordingSink{}))
+
+		// Takes 10 from alice, gives 5 to bob: the instruction sum isn't zero.
+		unbalanced := transfer("alice", "bob", 10, 2)
+		unbalanced.Instructions[1].Change = 5.0
+		unbalanced.ID = unbalanced.ComputeID()
+		vali.PushTransaction(unbalanced)
+		vali.PushTransaction(transfer("alice", "bob", 10, 1))
+
+		batch, err := vali.Flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(batch) != 1 {
+			t.Fatalf("charge=%v: committed %d transaction(s), want 1", charge, len(batch))
+		}
+		if n := vali.Rejections(ReasonExecution); n != 1 {
+			t.Errorf("charge=%v: %d execution failure(s), want 1", charge, n)
+		}
+
+		want := map[string]float64{"alice": 89, "bob": 10, adb.ValidatorAccount: 1}
+		if charge {
+			want["alice"] -= 2
+			want[adb.ValidatorAccount] += 2
+		}
+		if got := vali.db.Balances(); !maps.Equal(got, want) {
+			t.Errorf("charge=%v: balances %v, want %v", charge, got, want)
+		}
+		if supply := vali.db.TotalSupply(); supply != 100 {
+			t.Errorf("charge=%v: total supply is %v, want 100", charge, supply)
+		}
+	}
+}
+
+func TestAccountValidator(t *testing.T) {
+	long := strings.Repeat("a", 33)
+	vali := newTestValidator(t, map[string]float64{"alice": 100}, WithAccountValidator(func(account string) error {
+		if len(account) > 32 {
+			return errors.New("account name is too long")
+		}
+		return nil
+	}))
+
+	reference := transfer("alice", "bob", 1, 1)
+	reference.Instructions[1].Change = map[string]any{"account": long, "sign": "plus"}
+	for _, tx := range []*Transaction{
+		transfer("alice", long, 1, 1),
+		transfer(long, "bob", 1, 1),
+		reference,
+		transfer("alice", "bob", 1, 1),
+	} {
+		vali.handleMessage(encode(t, tx), netip.AddrPort{})
+	}
+	vali.drainIncoming()
+
+	if n := vali.Rejections(ReasonAccountName); n != 3 {
+		t.Errorf("%d account name rejection(s), want 3", n)
+	}
+	if n := vali.PendingCount(); n != 1 {
+		t.Errorf("%d transaction(s) pending, want 1", n)
+	}
+}
+
+func TestBatchValidator(t *testing.T) {
+	blocked := func(batch []*Transaction) error {
+		for _, tx := range batch {
+			if slices.Contains(tx.accounts(), "mallory") {
+				return errors.New("mallory is blocked")
+			}
+		}
+		return nil
+	}
+
+	for _, policy := range []VetoPolicy{VetoRequeue, VetoDrop} {
+		vali := newTestValidator(t, map[string]float64{"alice": 100, "bob": 100},
+			WithBatchValidator(blocked, policy), WithSink(&recordingSink{}))
+		vali.PushTransaction(transfer("alice", "carol", 10, 1))
+		vali.PushTransaction(transfer("bob", "mallory", 10, 1))
+
+		batch, err := vali.Flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(batch) != 0 {
+			t.Errorf("policy %d: committed %d transaction(s) of a vetoed batch", policy, len(batch))
+		}
+		if n := vali.VetoedBatches(); n != 1 {
+			t.Errorf("policy %d: %d vetoed batch(es), want 1", policy, n)
+		}
+		if n := vali.Rejections(ReasonVetoed); n != 2 {
+			t.Errorf("policy %d: %d veto rejection(s), want 2", policy, n)
+		}
+		if balance, _ := vali.db.GetBalance("alice"); balance != 100 {
+			t.Errorf("policy %d: alice has %v, want 100", policy, balance)
+		}
+
+		want := 0
+		if policy == VetoRequeue {
+			want = 2
+		}
+		if n := vali.PendingCount(); n != want {
+			t.Errorf("policy %d: %d transaction(s) pending, want %d", policy, n, want)
+		}
+	}
+
+	// Batches without a blocked account go through.
+	vali := newTestValidator(t, map[string]float64{"alice": 100}, WithBatchValidator(blocked, VetoDrop), WithSink(&recordingSink{}))
+	vali.PushTransaction(transfer("alice", "carol", 10, 1))
+	batch, err := vali.Flush()
+	if err != nil || len(batch) != 1 {
+		t.Errorf("committed %d transaction(s), error %v, want 1", len(batch), err)
+	}
+}
+
+func TestWithLockWaitsForBatch(t *testing.T) {
+	building := make(chan struct{})
+	release := make(chan struct{})
+	held := func(batch []*Transaction) error {
+		close(building)
+		<-release
+		return nil
+	}
+	vali := newTestValidator(t, map[string]float64{"alice": 100}, WithBatchValidator(held, VetoDrop), WithSink(&recordingSink{}))
+	vali.PushTransaction(transfer("alice", "bob", 10, 1))
+
+	flushed := make(chan error)
+	go func() {
+		_, err := vali.Flush()
+		flushed <- err
+	}()
+	<-building
+
+	// Draining alice meanwhile would overdraw her once the batch commits.
+	locked := make(chan error)
+	go func() {
+		locked <- vali.WithLock([]string{"alice"}, func(db *adb.AccountsDb) error {
+			return db.UpdateBy("alice", -95)
+		})
+	}()
+	select {
+	case <-locked:
+		t.Fatal("changed the db while a batch is processed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	err := <-flushed
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = <-locked
+	if err == nil {
+		t.Error("drained alice after the batch, want an error")
+	}
+	if balance, _ := vali.db.GetBalance("alice"); balance != 89 {
+		t.Errorf("alice has %v, want 89", balance)
+	}
+}
+
+func TestOverdraft(t *testing.T) {
+	vali := newTestValidator(t, map[string]float64{"alice": 5, "pool": 5}, WithSink(&recordingSink{}))
+
+	err := vali.SetOverdraft("pool", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both send more than they have, only the pool may.
+	receive(t, vali, transfer("alice", "bob", 10, 1))
+	receive(t, vali, transfer("pool", "bob", 10, 1))
+	batch, err := vali.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batch) != 1 || batch[0].Fee.Payer != "pool" {
+		t.Fatalf("committed %v, want the pool's transfer", batch)
+	}
+	if balance, _ := vali.db.GetBalance("pool"); balance != -6 {
+		t.Errorf("pool has %v, want -6", balance)
+	}
+	// Failed transactions aren't charged a fee by default.
+	if balance, _ := vali.db.GetBalance("alice"); balance != 5 {
+		t.Errorf("alice has %v, want 5", balance)
+	}
+}
+
+func TestReferencesReadBatchStart(t *testing.T) {
+	vali := newTestValidator(t, map[string]float64{"alice": 100, "bob": 0, "carol": 50}, WithSink(&recordingSink{}))
+
+	// Carol is credited by one transaction and swept to bob by another
+	// in the same batch, which moves her balance as of the batch start.
+	sweep := &Transaction{Transaction: models.Transaction{
+		Fee: models.Fee{Payer: "alice", Amount: 1},
+		Instructions: []models.Instruction{
+			{Account: "carol", Change: map[string]any{"account": "carol", "sign": "minus"}},
+			{Account: "bob", Change: map[string]any{"account": "carol", "sign": "plus"}},
+		},
+	}}
+	sweep.ID = sweep.ComputeID()
+	receive(t, vali, transfer("alice", "carol", 40, 1))
+	receive(t, vali, sweep)
+
+	batch, err := vali.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batch) != 2 {
+		t.Fatalf("committed %d transaction(s), want 2", len(batch))
+	}
+
+	want := map[string]float64{"alice": 58, "bob": 50, "carol": 40}
+	for account, amount := range want {
+		if balance, _ := vali.db.GetBalance(account); balance != amount {
+			t.Errorf("%s has %v, want %v", account, balance, amount)
+		}
+	}
+}
+
+func TestSequences(t *testing.T) {
+	vali := newTestValidator(t, map[string]float64{"alice": 100, "carol": 100}, WithSink(&recordingSink{}))
+
+	// Bob is changed twice in one batch, once in the next.
+	receive(t, vali, transfer("alice", "bob", 1, 1))
+	receive(t, vali, transfer("carol", "bob", 2, 1))
+	first, err := vali.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	receive(t, vali, transfer("alice", "bob", 3, 1))
+	second, err := vali.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 2 || len(second) != 1 {
+		t.Fatalf("committed batches of %d and %d, want 2 and 1", len(first), len(second))
+	}
+
+	var sequences []uint64
+	for _, tx := range append(first, second...) {
+		sequences = append(sequences, tx.Sequences["bob"])
+	}
+	if want := []uint64{1, 2, 3}; !slices.Equal(sequences, want) {
+		t.Errorf("bob's sequences %v, want %v", sequences, want)
+	}
+	if seq := second[0].Sequences["alice"]; seq != 2 {
+		t.Errorf("alice's sequence %d, want 2", seq)
+	}
+	if account, _ := vali.db.GetAccount("bob"); account.Sequence != 3 {
+		t.Errorf("bob's sequence in the db %d, want 3", account.Sequence)
+	}
+
+	// Sent along in the batch.
+	payload, err := json.Marshal(second[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sent struct {
+		Sequences map[string]uint64 `json:"sequences"`
+	}
+	err = json.Unmarshal(payload, &sent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent.Sequences["bob"] != 3 {
+		t.Errorf("sent sequences %v, want bob's 3", sent.Sequences)
+	}
+}
+
+func TestPayerPolicy(t *testing.T) {
+	// credit moves amount from bob to alice, alice paying the fee.
+	credit := func(amount, fee float64) *Transaction {
+		tx := transfer("bob", "alice", amount, fee)
+		tx.Fee.Payer = "alice"
+		tx.ID = tx.ComputeID()
+		return tx
+	}
+
+	tests := []struct {
+		name   string
+		policy PayerPolicy
+		alice  float64 // Balance before.
+		tx     *Transaction
+		want   float64 // Balance after, the fee plus instruction net if committed.
+	}{
+		{"debited upfront", PayerPaysUpfront, 100, transfer("alice", "bob", 30, 2), 68},
+		{"debited net", PayerNetChange, 100, transfer("alice", "bob", 30, 2), 68},
+		{"credited upfront", PayerPaysUpfront, 10, credit(10, 5), 15},
+		{"credited net", PayerNetChange, 10, credit(10, 5), 15},
+		// Only the credit can fund the fee.
+		{"credit funds the fee upfront", PayerPaysUpfront, 1, credit(10, 5), 1},
+		{"credit funds the fee net", PayerNetChange, 1, credit(10, 5), 6},
+		// Neither lets the fee and a debit go beyond the balance.
+		{"fee and debit upfront", PayerPaysUpfront, 30, transfer("alice", "bob", 30, 2), 30},
+		{"fee and debit net", PayerNetChange, 30, transfer("alice", "bob", 30, 2), 30},
+	}
+
+	for _, test := range tests {
+		vali := newTestValidator(t, map[string]float64{"alice": test.alice, "bob": 50},
+			WithPayerPolicy(test.policy), WithSink(&recordingSink{}))
+
+		receive(t, vali, test.tx)
+		_, err := vali.Flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if balance, _ := vali.db.GetBalance("alice"); balance != test.want {
+			t.Errorf("%s: alice has %v, want %v", test.name, balance, test.want)
+		}
+		// Whatever's taken from alice and bob is with the validator.
+		if supply := vali.db.TotalSupply(); supply != test.alice+50 {
+			t.Errorf("%s: total supply %v, want %v", test.name, supply, test.alice+50)
+		}
+	}
+}